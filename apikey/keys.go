@@ -0,0 +1,234 @@
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jeffprestes/sjrpc/config"
+	"github.com/jeffprestes/sjrpc/database"
+)
+
+// Key sources.
+const (
+	SourceConfig   = "config"
+	SourceDatabase = "database"
+)
+
+var (
+	// ErrNotFound is returned for API key names that do not exist.
+	ErrNotFound = errors.New("API key not found")
+
+	// ErrConfigKey is returned when revoking a key of the configuration file.
+	ErrConfigKey = errors.New("API keys of the configuration file are revoked by editing the file")
+
+	// ErrExists is returned when creating a key with a name in use.
+	ErrExists = errors.New("API key name already in use")
+)
+
+// Key is a client of the server. Keys come from the configuration file or are
+// created through the admin endpoints and stored in the database, where only
+// the hash of the key is kept.
+type Key struct {
+	Name      string            `json:"name"`
+	Hash      string            `json:"-"`
+	RateLimit *config.RateLimit `json:"rateLimit,omitempty"`
+	Methods   config.Firewall   `json:"methods"`
+	Chains    []string          `json:"chains,omitempty"`
+	Admin     bool              `json:"admin"`
+	Created   *time.Time        `json:"created,omitempty"`
+	Source    string            `json:"source"`
+}
+
+// record is a key as stored in the database.
+type record struct {
+	Key
+	Hash string `json:"hash"`
+}
+
+var (
+	mutex sync.RWMutex
+	// keys created through the admin endpoints, by hash
+	stored = make(map[string]Key)
+)
+
+// Load reads the keys stored in the database. It must be called after the
+// database is opened.
+func Load() (err error) {
+	keys := make(map[string]Key)
+	err = database.DB.Scan(database.APIKeysNamespace, nil, []byte{0xff}, func(_, value []byte) error {
+		var item record
+		errJson := json.Unmarshal(value, &item)
+		if errJson != nil {
+			return errJson
+		}
+		item.Key.Hash = item.Hash
+		keys[item.Hash] = item.Key
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	mutex.Lock()
+	stored = keys
+	mutex.Unlock()
+	return
+}
+
+// Enabled reports if clients must authenticate, which is the case when there
+// is any API key.
+func Enabled() bool {
+	if len(config.Get().Auth.Keys) > 0 {
+		return true
+	}
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return len(stored) > 0
+}
+
+// Find returns the key whose secret is secret.
+func Find(secret string) (key Key, ok bool) {
+	if len(secret) < 1 {
+		return
+	}
+	apiKey, ok := config.Get().FindAPIKey(secret)
+	if ok {
+		key = fromConfig(apiKey)
+		return
+	}
+	mutex.RLock()
+	defer mutex.RUnlock()
+	key, ok = stored[hash(secret)]
+	return
+}
+
+// ByName returns the key named name.
+func ByName(name string) (key Key, ok bool) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return byName(name)
+}
+
+// List returns every key sorted by name.
+func List() (keys []Key) {
+	for _, apiKey := range config.Get().Auth.Keys {
+		keys = append(keys, fromConfig(apiKey))
+	}
+	mutex.RLock()
+	for _, key := range stored {
+		keys = append(keys, key)
+	}
+	mutex.RUnlock()
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Name < keys[j].Name
+	})
+	return
+}
+
+// Create stores a new key with the settings of key and returns its secret,
+// which is not kept and cannot be retrieved later.
+func Create(key Key) (created Key, secret string, err error) {
+	if !config.ValidKeyName(key.Name) {
+		err = fmt.Errorf("invalid API key name: %q", key.Name)
+		return
+	}
+	err = config.Get().ValidateKeyLimits(config.APIKey{
+		Name:      key.Name,
+		RateLimit: key.RateLimit,
+		Methods:   key.Methods,
+		Chains:    key.Chains,
+	})
+	if err != nil {
+		return
+	}
+	tmp := make([]byte, 32)
+	_, err = rand.Read(tmp)
+	if err != nil {
+		return
+	}
+	secret = hex.EncodeToString(tmp)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	_, exists := byName(key.Name)
+	if exists {
+		err = ErrExists
+		return
+	}
+	key.Hash = hash(secret)
+	now := time.Now().UTC()
+	key.Created = &now
+	key.Source = SourceDatabase
+	value, err := json.Marshal(record{Key: key, Hash: key.Hash})
+	if err != nil {
+		return
+	}
+	err = database.DB.Update(database.APIKeysNamespace, []byte(key.Hash), value)
+	if err != nil {
+		return
+	}
+	stored[key.Hash] = key
+	created = key
+	return
+}
+
+// Revoke removes the stored key named name. Its usage counters are kept.
+func Revoke(name string) (err error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	key, ok := byName(name)
+	if !ok {
+		err = ErrNotFound
+		return
+	}
+	if key.Source == SourceConfig {
+		err = ErrConfigKey
+		return
+	}
+	err = database.DB.Delete(database.APIKeysNamespace, []byte(key.Hash))
+	if err != nil {
+		return
+	}
+	delete(stored, key.Hash)
+	return
+}
+
+// byName returns the key named name. The caller holds the mutex.
+func byName(name string) (key Key, ok bool) {
+	for _, apiKey := range config.Get().Auth.Keys {
+		if apiKey.Name == name {
+			return fromConfig(apiKey), true
+		}
+	}
+	for _, key = range stored {
+		if key.Name == name {
+			ok = true
+			return
+		}
+	}
+	key = Key{}
+	return
+}
+
+func fromConfig(apiKey config.APIKey) Key {
+	return Key{
+		Name:      apiKey.Name,
+		Hash:      hash(apiKey.Key),
+		RateLimit: apiKey.RateLimit,
+		Methods:   apiKey.Methods,
+		Chains:    apiKey.Chains,
+		Admin:     apiKey.Admin,
+		Source:    SourceConfig,
+	}
+}
+
+func hash(secret string) string {
+	tmp := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(tmp[:])
+}
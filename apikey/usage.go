@@ -0,0 +1,167 @@
+package apikey
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/jeffprestes/sjrpc/database"
+)
+
+// Usage is the number of calls of a key by day (YYYY-MM-DD, UTC) and method.
+type Usage map[string]map[string]uint64
+
+// calls counted and not saved yet, by usage key
+var counters sync.Map
+
+// flushMutex serializes the updates of the saved counters.
+var flushMutex sync.Mutex
+
+// pruneMutex is held by Count while it adds to a counter, and by Flush while
+// it removes the counters without calls, so no call is added to a counter
+// already removed.
+var pruneMutex sync.RWMutex
+
+// Longest method name counted on its own; longer names, as any name holding
+// '/', are counted as otherMethod.
+const (
+	maxMethodLength = 64
+	otherMethod     = "other"
+)
+
+// Count adds a call of method by the key named name.
+func Count(name, method string) {
+	if len(method) > maxMethodLength || strings.Contains(method, "/") {
+		method = otherMethod
+	}
+	key := usageKey(name, time.Now().UTC().Format(time.DateOnly), method)
+	pruneMutex.RLock()
+	defer pruneMutex.RUnlock()
+	tmp, ok := counters.Load(key)
+	if !ok {
+		tmp, _ = counters.LoadOrStore(key, new(atomic.Uint64))
+	}
+	tmp.(*atomic.Uint64).Add(1)
+}
+
+// Flush adds the calls counted to the counters saved in the database.
+func Flush() (err error) {
+	flushMutex.Lock()
+	defer flushMutex.Unlock()
+	var items []database.Item
+	var idle []any
+	counters.Range(func(key, value any) bool {
+		calls := value.(*atomic.Uint64).Swap(0)
+		if calls < 1 {
+			idle = append(idle, key)
+			return true
+		}
+		saved, errGet := savedCalls([]byte(key.(string)))
+		if errGet != nil {
+			// counted again on the next flush
+			value.(*atomic.Uint64).Add(calls)
+			err = errGet
+			return true
+		}
+		items = append(items, database.Item{
+			Key:   []byte(key.(string)),
+			Value: binary.BigEndian.AppendUint64(nil, saved+calls),
+		})
+		return true
+	})
+	if len(items) > 0 {
+		errUpdate := database.DB.UpdateMany(database.APIKeyUsageNamespace, items)
+		if errUpdate != nil {
+			err = errUpdate
+		}
+	}
+
+	// counters without calls since the previous flush, unless a call was
+	// counted since the swap
+	pruneMutex.Lock()
+	defer pruneMutex.Unlock()
+	for _, key := range idle {
+		value, ok := counters.Load(key)
+		if ok && value.(*atomic.Uint64).Load() < 1 {
+			counters.Delete(key)
+		}
+	}
+	return
+}
+
+// RunFlush saves the calls counted every interval until ctx is done.
+func RunFlush(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := Flush()
+			if err != nil {
+				slog.Error("error saving API key usage", "error", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// UsageOf returns the calls of the key named name, including the ones not
+// saved yet.
+func UsageOf(name string) (usage Usage, err error) {
+	usage = make(Usage)
+	add := func(key string, calls uint64) {
+		_, rest, _ := strings.Cut(key, "/")
+		day, method, found := strings.Cut(rest, "/")
+		if !found || calls < 1 {
+			return
+		}
+		if usage[day] == nil {
+			usage[day] = make(map[string]uint64)
+		}
+		usage[day][method] += calls
+	}
+	flushMutex.Lock()
+	defer flushMutex.Unlock()
+	prefix := []byte(name + "/")
+	err = database.DB.Scan(database.APIKeyUsageNamespace, prefix, append(bytes.Clone(prefix), 0xff), func(key, value []byte) error {
+		if len(value) == 8 {
+			add(string(key), binary.BigEndian.Uint64(value))
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	counters.Range(func(key, value any) bool {
+		if strings.HasPrefix(key.(string), string(prefix)) {
+			add(key.(string), value.(*atomic.Uint64).Load())
+		}
+		return true
+	})
+	return
+}
+
+func savedCalls(key []byte) (calls uint64, err error) {
+	value, err := database.DB.Get(database.APIKeyUsageNamespace, key)
+	if err == badger.ErrKeyNotFound {
+		err = nil
+		return
+	}
+	if err == nil && len(value) == 8 {
+		calls = binary.BigEndian.Uint64([]byte(value))
+	}
+	return
+}
+
+// usageKey is the key name, the day and the method. Key names cannot hold
+// '/'.
+func usageKey(name, day, method string) string {
+	return name + "/" + day + "/" + method
+}
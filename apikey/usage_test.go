@@ -0,0 +1,95 @@
+package apikey
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jeffprestes/sjrpc/database"
+)
+
+func useTestDB(t *testing.T) {
+	db, err := database.NewBadgerDB(t.TempDir())
+	if err != nil {
+		t.Fatalf("cannot open database: %v", err)
+	}
+	previous := database.DB
+	database.DB = db
+	t.Cleanup(func() {
+		database.DB = previous
+		db.Close()
+	})
+}
+
+func TestUsageOf(t *testing.T) {
+	useTestDB(t)
+	today := time.Now().UTC().Format(time.DateOnly)
+	Count("alice", "eth_call")
+	Count("alice", "eth_call")
+	Count("alice", "a/b")
+	Count("alicia", "eth_call")
+	if err := Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	Count("alice", "eth_call")
+	Count("alice", "eth_getLogs")
+
+	usage, err := UsageOf("alice")
+	if err != nil {
+		t.Fatalf("UsageOf failed: %v", err)
+	}
+	want := map[string]uint64{"eth_call": 3, "eth_getLogs": 1, otherMethod: 1}
+	if len(usage) != 1 || len(usage[today]) != len(want) {
+		t.Fatalf("usage %v, want %v on %s", usage, want, today)
+	}
+	for method, calls := range want {
+		if usage[today][method] != calls {
+			t.Errorf("%s calls = %d, want %d", method, usage[today][method], calls)
+		}
+	}
+}
+
+func TestFlushKeepsConcurrentCalls(t *testing.T) {
+	useTestDB(t)
+	const workers, calls = 8, 500
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < calls; j++ {
+				Count("busy", "eth_call")
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for flushing := true; flushing; {
+		select {
+		case <-done:
+			flushing = false
+		default:
+		}
+		if err := Flush(); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+	// the last flushes find the counter idle and remove it
+	Flush()
+	Flush()
+
+	usage, err := UsageOf("busy")
+	if err != nil {
+		t.Fatalf("UsageOf failed: %v", err)
+	}
+	today := time.Now().UTC().Format(time.DateOnly)
+	if got := usage[today]["eth_call"]; got != workers*calls {
+		t.Errorf("%d calls counted, want %d", got, workers*calls)
+	}
+	if _, ok := counters.Load(usageKey("busy", today, "eth_call")); ok {
+		t.Error("idle counter not removed")
+	}
+}
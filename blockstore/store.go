@@ -0,0 +1,231 @@
+package blockstore
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/jeffprestes/sjrpc/database"
+	"github.com/jeffprestes/sjrpc/model"
+	"github.com/jeffprestes/sjrpc/tracker"
+)
+
+// Block is a block with its transaction objects, from which every variant of
+// the block methods is answered.
+type Block struct {
+	Number       uint64
+	Hash         string
+	Transactions []json.RawMessage
+	fields       map[string]json.RawMessage
+	// decoded transaction objects, in the order of Transactions
+	transactions []model.Transaction
+}
+
+// Parse decodes an eth_getBlockByNumber or eth_getBlockByHash result fetched
+// with full transaction objects.
+func Parse(result json.RawMessage) (block *Block, err error) {
+	block = &Block{}
+	err = json.Unmarshal(result, &block.fields)
+	if err != nil {
+		return
+	}
+	var header struct {
+		Number       string            `json:"number"`
+		Hash         string            `json:"hash"`
+		Transactions []json.RawMessage `json:"transactions"`
+	}
+	err = json.Unmarshal(result, &header)
+	if err != nil {
+		return
+	}
+	if len(header.Hash) < 1 || len(header.Number) < 1 {
+		err = fmt.Errorf("block without number or hash")
+		return
+	}
+	block.Number, err = tracker.ParseHexUint64(header.Number)
+	if err != nil {
+		err = fmt.Errorf("invalid block number %q: %w", header.Number, err)
+		return
+	}
+	block.Hash = strings.ToLower(header.Hash)
+	block.transactions = make([]model.Transaction, len(header.Transactions))
+	for i, tx := range header.Transactions {
+		if len(tx) < 1 || tx[0] != '{' {
+			err = fmt.Errorf("block %d without transaction objects", block.Number)
+			return
+		}
+		err = json.Unmarshal(tx, &block.transactions[i])
+		if err != nil {
+			return
+		}
+	}
+	block.Transactions = header.Transactions
+	return
+}
+
+// Result returns the block as returned by eth_getBlockBy... with fullTx.
+// Without fullTx the transactions are replaced by their hashes.
+func (block *Block) Result(fullTx bool) (result json.RawMessage, err error) {
+	if fullTx {
+		result, err = json.Marshal(block.fields)
+		return
+	}
+	hashes := block.TransactionHashes()
+	fields := make(map[string]json.RawMessage, len(block.fields))
+	for name, value := range block.fields {
+		fields[name] = value
+	}
+	fields["transactions"], err = json.Marshal(hashes)
+	if err != nil {
+		return
+	}
+	result, err = json.Marshal(fields)
+	return
+}
+
+// TransactionHashes returns the hashes of the transactions in block order.
+func (block *Block) TransactionHashes() []string {
+	hashes := make([]string, 0, len(block.transactions))
+	for _, tx := range block.transactions {
+		hashes = append(hashes, tx.Hash)
+	}
+	return hashes
+}
+
+// TransactionCount returns the number of transactions as a quantity.
+func (block *Block) TransactionCount() json.RawMessage {
+	return json.RawMessage(fmt.Sprintf(`"0x%x"`, len(block.Transactions)))
+}
+
+// Transaction returns the transaction at index, or null.
+func (block *Block) Transaction(index uint64) json.RawMessage {
+	if index >= uint64(len(block.Transactions)) {
+		return json.RawMessage("null")
+	}
+	return block.Transactions[index]
+}
+
+// TransactionByHash returns the transaction with the hash, if it is in the
+// block.
+func (block *Block) TransactionByHash(hash string) (tx json.RawMessage, ok bool) {
+	for i, item := range block.transactions {
+		if strings.EqualFold(item.Hash, hash) {
+			return block.Transactions[i], true
+		}
+	}
+	return
+}
+
+// Store saves the block by hash and, when canonical, makes it the block of
+// its number and indexes its transactions.
+func Store(ctx context.Context, chainId *int, block *Block, canonical bool) (err error) {
+	value, err := json.Marshal(block.fields)
+	if err != nil {
+		return
+	}
+	db := database.Traced(ctx)
+	err = db.Update(database.BlocksNamespace, HashKey(chainId, block.Hash), value)
+	if err != nil || !canonical {
+		return
+	}
+	if len(block.transactions) > 0 {
+		items := make([]database.Item, 0, len(block.transactions))
+		for _, tx := range block.transactions {
+			items = append(items, database.Item{Key: TransactionKey(chainId, tx.Hash), Value: []byte(block.Hash)})
+		}
+		err = db.UpdateMany(database.TransactionsNamespace, items)
+		if err != nil {
+			return
+		}
+	}
+	err = db.Update(database.BlockNumbersNamespace, NumberKey(chainId, block.Number), []byte(block.Hash))
+	return
+}
+
+// ByHash returns the stored block with the hash. ok is false when the block
+// is not stored.
+func ByHash(ctx context.Context, chainId *int, hash string) (block *Block, ok bool, err error) {
+	value, err := database.Traced(ctx).Get(database.BlocksNamespace, HashKey(chainId, hash))
+	if err == badger.ErrKeyNotFound {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	block, err = Parse(json.RawMessage(value))
+	ok = err == nil
+	return
+}
+
+// ByNumber returns the stored canonical block with the number. ok is false
+// when the block is not stored.
+func ByNumber(ctx context.Context, chainId *int, number uint64) (block *Block, ok bool, err error) {
+	hash, err := database.Traced(ctx).Get(database.BlockNumbersNamespace, NumberKey(chainId, number))
+	if err == badger.ErrKeyNotFound {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	block, ok, err = ByHash(ctx, chainId, hash)
+	return
+}
+
+// TransactionByHash returns the transaction with the hash from the canonical
+// block stored with it. ok is false when the transaction is unknown or its
+// block is no longer the canonical block of its number.
+func TransactionByHash(ctx context.Context, chainId *int, hash string) (tx json.RawMessage, ok bool, err error) {
+	db := database.Traced(ctx)
+	blockHash, err := db.Get(database.TransactionsNamespace, TransactionKey(chainId, hash))
+	if err == badger.ErrKeyNotFound {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	block, found, err := ByHash(ctx, chainId, blockHash)
+	if err != nil || !found {
+		return
+	}
+	canonical, err := db.Get(database.BlockNumbersNamespace, NumberKey(chainId, block.Number))
+	if err == badger.ErrKeyNotFound {
+		err = nil
+		return
+	}
+	if err != nil || canonical != block.Hash {
+		return
+	}
+	tx, ok = block.TransactionByHash(hash)
+	return
+}
+
+// HashKey is the key of a block in BlocksNamespace.
+func HashKey(chainId *int, hash string) []byte {
+	return append(chainKey(chainId), strings.ToLower(hash)...)
+}
+
+// TransactionKey is the key of a transaction in TransactionsNamespace.
+func TransactionKey(chainId *int, hash string) []byte {
+	return append(chainKey(chainId), strings.ToLower(hash)...)
+}
+
+// NumberKey is the key of a block number in BlockNumbersNamespace.
+func NumberKey(chainId *int, number uint64) []byte {
+	return binary.BigEndian.AppendUint64(chainKey(chainId), number)
+}
+
+// chainKey isolates the blocks of each chain. Without a chainId the chain is
+// 1, as in model.RPCRequest.Hash.
+func chainKey(chainId *int) []byte {
+	id := uint64(1)
+	if chainId != nil {
+		id = uint64(*chainId)
+	}
+	return binary.BigEndian.AppendUint64(nil, id)
+}
@@ -0,0 +1,187 @@
+package blockstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/jeffprestes/sjrpc/database"
+)
+
+// useTestDB replaces the database with an empty one for the test.
+func useTestDB(t *testing.T) {
+	db, err := database.NewBadgerDB(t.TempDir())
+	if err != nil {
+		t.Fatalf("cannot open database: %v", err)
+	}
+	previous := database.DB
+	database.DB = db
+	t.Cleanup(func() {
+		database.DB = previous
+		db.Close()
+	})
+}
+
+// testBlock returns a parsed block with the number and hash, holding a
+// transaction for each of txHashes.
+func testBlock(t *testing.T, number uint64, hash string, txHashes ...string) *Block {
+	txs := make([]json.RawMessage, 0, len(txHashes))
+	for i, txHash := range txHashes {
+		txs = append(txs, json.RawMessage(fmt.Sprintf(`{"hash":%q,"blockHash":%q,"blockNumber":"0x%x","transactionIndex":"0x%x","from":"0x01"}`, txHash, hash, number, i)))
+	}
+	result, _ := json.Marshal(map[string]any{
+		"number":       fmt.Sprintf("0x%x", number),
+		"hash":         hash,
+		"parentHash":   fmt.Sprintf("0x%064x", number-1),
+		"transactions": txs,
+	})
+	block, err := Parse(result)
+	if err != nil {
+		t.Fatalf("cannot parse block %d: %v", number, err)
+	}
+	return block
+}
+
+func TestStoreByHashAndNumber(t *testing.T) {
+	useTestDB(t)
+	ctx := context.Background()
+	chainId := 5
+	block := testBlock(t, 100, "0xAA01", "0xT1", "0xT2")
+	if err := Store(ctx, &chainId, block, true); err != nil {
+		t.Fatalf("store: %v", err)
+	}
+
+	byHash, ok, err := ByHash(ctx, &chainId, "0xaa01")
+	if err != nil || !ok || byHash.Number != 100 || byHash.Hash != "0xaa01" || len(byHash.Transactions) != 2 {
+		t.Fatalf("by hash %+v, %v, %v", byHash, ok, err)
+	}
+	byNumber, ok, err := ByNumber(ctx, &chainId, 100)
+	if err != nil || !ok || byNumber.Hash != "0xaa01" {
+		t.Fatalf("by number %+v, %v, %v", byNumber, ok, err)
+	}
+
+	if _, ok, err = ByNumber(ctx, &chainId, 101); ok || err != nil {
+		t.Errorf("block 101 found: %v, %v", ok, err)
+	}
+	if _, ok, err = ByHash(ctx, nil, "0xaa01"); ok || err != nil {
+		t.Errorf("block found on another chain: %v, %v", ok, err)
+	}
+
+	uncle := testBlock(t, 101, "0xbb01")
+	if err = Store(ctx, &chainId, uncle, false); err != nil {
+		t.Fatalf("store non canonical: %v", err)
+	}
+	if _, ok, _ = ByHash(ctx, &chainId, "0xbb01"); !ok {
+		t.Errorf("non canonical block not stored by hash")
+	}
+	if _, ok, _ = ByNumber(ctx, &chainId, 101); ok {
+		t.Errorf("non canonical block stored by number")
+	}
+}
+
+func TestStoreReplacesNumberAfterReorg(t *testing.T) {
+	useTestDB(t)
+	ctx := context.Background()
+	chainId := 5
+	if err := Store(ctx, &chainId, testBlock(t, 100, "0xaa01", "0xt1"), true); err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	if err := Store(ctx, &chainId, testBlock(t, 100, "0xaa02", "0xt2"), true); err != nil {
+		t.Fatalf("store replacement: %v", err)
+	}
+
+	block, ok, err := ByNumber(ctx, &chainId, 100)
+	if err != nil || !ok || block.Hash != "0xaa02" {
+		t.Fatalf("block 100 after reorg %+v, %v, %v", block, ok, err)
+	}
+	if hashes := block.TransactionHashes(); len(hashes) != 1 || hashes[0] != "0xt2" {
+		t.Errorf("block 100 transactions %v", hashes)
+	}
+	if _, ok, _ = ByHash(ctx, &chainId, "0xaa01"); !ok {
+		t.Errorf("reorged out block no longer stored by hash")
+	}
+}
+
+func TestResultWithoutFullTx(t *testing.T) {
+	block := testBlock(t, 100, "0xaa01", "0xt1", "0xt2")
+
+	result, err := block.Result(false)
+	if err != nil {
+		t.Fatalf("result: %v", err)
+	}
+	var hashesOnly struct {
+		Hash         string   `json:"hash"`
+		ParentHash   string   `json:"parentHash"`
+		Transactions []string `json:"transactions"`
+	}
+	if err = json.Unmarshal(result, &hashesOnly); err != nil {
+		t.Fatalf("transactions are not hashes: %v in %s", err, result)
+	}
+	if hashesOnly.Hash != "0xaa01" || hashesOnly.ParentHash != fmt.Sprintf("0x%064x", 99) {
+		t.Errorf("header fields %+v", hashesOnly)
+	}
+	if len(hashesOnly.Transactions) != 2 || hashesOnly.Transactions[0] != "0xt1" || hashesOnly.Transactions[1] != "0xt2" {
+		t.Errorf("transactions %v", hashesOnly.Transactions)
+	}
+
+	full, err := block.Result(true)
+	if err != nil {
+		t.Fatalf("full result: %v", err)
+	}
+	var fullTx struct {
+		Transactions []map[string]string `json:"transactions"`
+	}
+	if err = json.Unmarshal(full, &fullTx); err != nil || len(fullTx.Transactions) != 2 || fullTx.Transactions[1]["hash"] != "0xt2" {
+		t.Errorf("full transactions changed: %v in %s", err, full)
+	}
+	if string(block.TransactionCount()) != `"0x2"` {
+		t.Errorf("transaction count %s", block.TransactionCount())
+	}
+}
+
+func TestTransactionByHash(t *testing.T) {
+	useTestDB(t)
+	ctx := context.Background()
+	chainId := 5
+	if err := Store(ctx, &chainId, testBlock(t, 100, "0xaa01", "0xt1", "0xt2"), true); err != nil {
+		t.Fatalf("store: %v", err)
+	}
+
+	tx, ok, err := TransactionByHash(ctx, &chainId, "0xT2")
+	var found struct {
+		Hash      string `json:"hash"`
+		BlockHash string `json:"blockHash"`
+	}
+	json.Unmarshal(tx, &found)
+	if err != nil || !ok || found.Hash != "0xt2" || found.BlockHash != "0xaa01" {
+		t.Fatalf("transaction %s, %v, %v", tx, ok, err)
+	}
+	if _, ok, err = TransactionByHash(ctx, &chainId, "0xt3"); ok || err != nil {
+		t.Errorf("unknown transaction found: %v, %v", ok, err)
+	}
+	if _, ok, err = TransactionByHash(ctx, nil, "0xt1"); ok || err != nil {
+		t.Errorf("transaction found on another chain: %v, %v", ok, err)
+	}
+
+	// a block of a side chain does not index its transactions
+	if err = Store(ctx, &chainId, testBlock(t, 101, "0xbb01", "0xt4"), false); err != nil {
+		t.Fatalf("store non canonical: %v", err)
+	}
+	if _, ok, _ = TransactionByHash(ctx, &chainId, "0xt4"); ok {
+		t.Errorf("transaction of a non canonical block found")
+	}
+
+	// once the block is replaced its transactions are no longer found
+	if err = Store(ctx, &chainId, testBlock(t, 100, "0xaa02", "0xt2"), true); err != nil {
+		t.Fatalf("store replacement: %v", err)
+	}
+	if _, ok, err = TransactionByHash(ctx, &chainId, "0xt1"); ok || err != nil {
+		t.Errorf("reorged out transaction found: %v, %v", ok, err)
+	}
+	tx, ok, _ = TransactionByHash(ctx, &chainId, "0xt2")
+	json.Unmarshal(tx, &found)
+	if !ok || found.BlockHash != "0xaa02" {
+		t.Errorf("transaction included again %s, %v", tx, ok)
+	}
+}
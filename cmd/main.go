@@ -1,50 +1,340 @@
 package main
 
 import (
-	"log"
-	"net/http"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
 	"os"
-	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/jeffprestes/sjrpc/config"
 	"github.com/jeffprestes/sjrpc/database"
 	"github.com/jeffprestes/sjrpc/handler"
-	"github.com/labstack/echo/v4"
-	"github.com/labstack/echo/v4/middleware"
+	"github.com/jeffprestes/sjrpc/logging"
+	"github.com/jeffprestes/sjrpc/model"
 )
 
+// version is set at build time by the Makefile.
+var version = "dev"
+
+// Number of recent reorgs listed by the stats command.
+const lastReorgsShown = 10
+
+type command struct {
+	name        string
+	description string
+	run         func(args []string) error
+}
+
+var commands = []command{
+	{"serve", "start the JSON-RPC reverse proxy (default)", serveCommand},
+	{"stats", "show database size and number of cached entries", statsCommand},
+	{"export", "write a backup of the cache database", exportCommand},
+	{"import", "load a backup into the cache database", importCommand},
+	{"purge", "remove cached entries from the database, keeping API keys and warm jobs", purgeCommand},
+	{"warm", "fetch a block range from upstream into the cache", warmCommand},
+	{"version", "print the sjrpc version", versionCommand},
+}
+
 func main() {
-	whereAmI, err := os.Getwd()
+	args := os.Args[1:]
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name = args[0]
+		args = args[1:]
+	}
+
+	if name == "help" {
+		usage()
+		return
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == name {
+			err := cmd.run(args)
+			if err == flag.ErrHelp {
+				return
+			}
+			if err != nil {
+				slog.Error("command failed", "command", name, "error", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command: %s\n\n", name)
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: sjrpc <command> [flags]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.description)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun 'sjrpc <command> -h' to see the command flags.\n")
+}
+
+// newFlagSet returns a flag set with the flags shared by every command bound
+// to cfg. Flags take precedence over SJRPC_* environment variables and the
+// configuration file.
+func newFlagSet(name string, cfg *config.Config) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&cfg.ConfigFile, "config", cfg.ConfigFile, "YAML configuration file, reloaded when it changes (env SJRPC_CONFIG)")
+	fs.StringVar(&cfg.Listen, "listen", cfg.Listen, "listen address of the web server (env SJRPC_LISTEN)")
+	fs.StringVar(&cfg.AdminListen, "admin-listen", cfg.AdminListen, "listen address of the admin endpoints, host:port or unix:<path>, the web server address if empty (env SJRPC_ADMIN_LISTEN)")
+	fs.StringVar(&cfg.TLS.CertFile, "tls-cert", cfg.TLS.CertFile, "TLS certificate file, serves HTTPS and HTTP/2 with --tls-key (env SJRPC_TLS_CERT)")
+	fs.StringVar(&cfg.TLS.KeyFile, "tls-key", cfg.TLS.KeyFile, "TLS private key file (env SJRPC_TLS_KEY)")
+	fs.StringVar(&cfg.TLS.ClientCAFile, "tls-client-ca", cfg.TLS.ClientCAFile, "CA file of the client certificates required, enables mutual TLS (env SJRPC_TLS_CLIENT_CA)")
+	fs.BoolVar(&cfg.TLS.H2C, "h2c", cfg.TLS.H2C, "serve HTTP/2 without TLS")
+	fs.StringVar(&cfg.DataDir, "data-dir", cfg.DataDir, "cache database directory (env SJRPC_DATA_DIR)")
+	fs.Var(&listFlag{values: &cfg.Upstreams}, "upstream", "upstream JSON-RPC URL, repeat or use commas for several (env SJRPC_URL)")
+	fs.Var(&listFlag{values: &cfg.WSUpstreams}, "ws-upstream", "upstream WebSocket URL used by subscriptions, derived from --upstream if empty (env SJRPC_WS_URL)")
+	fs.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "log level: debug, info, warn or error (env SJRPC_LOG_LEVEL)")
+	fs.StringVar(&cfg.LogFormat, "log-format", cfg.LogFormat, "log format: text or json (env SJRPC_LOG_FORMAT)")
+	fs.StringVar(&cfg.Tracing.Exporter, "tracing", cfg.Tracing.Exporter, "span exporter: otlp or memory, tracing is off if empty (env SJRPC_TRACING)")
+	fs.StringVar(&cfg.Tracing.Endpoint, "otlp-endpoint", cfg.Tracing.Endpoint, "OTLP/HTTP collector URL, as http://localhost:4318 (env SJRPC_OTLP_ENDPOINT)")
+	fs.StringVar(&cfg.CachePolicyFile, "cache-policy", cfg.CachePolicyFile, "JSON or YAML file defining the cache tier of each method, reloaded when it changes (env SJRPC_CACHE_POLICY)")
+	return fs
+}
+
+// flagValues keeps the flags given in the command line so they can be applied
+// again when the configuration file is reloaded.
+var flagValues = make(map[string]string)
+
+// parseFlags parses the command arguments and makes the resulting
+// configuration and cache policy the ones in use.
+func parseFlags(fs *flag.FlagSet, cfg *config.Config, args []string) (resolved *config.Config, err error) {
+	err = fs.Parse(args)
+	if err != nil {
+		return
+	}
+	fs.Visit(func(f *flag.Flag) {
+		flagValues[f.Name] = f.Value.String()
+	})
+	resolved, err = resolveConfig(cfg.ConfigFile)
+	if err != nil {
+		return
+	}
+	err = applyConfig(resolved)
+	if err != nil {
+		resolved = nil
+	}
+	return
+}
+
+// resolveConfig loads the configuration file, if any, and applies the command
+// line flags on top of it.
+func resolveConfig(path string) (cfg *config.Config, err error) {
+	if len(path) > 0 {
+		cfg, err = config.Load(path)
+		if err != nil {
+			return
+		}
+	} else {
+		cfg = config.Default()
+	}
+	fs := newFlagSet("", cfg)
+	for name, value := range flagValues {
+		if fs.Lookup(name) == nil {
+			continue
+		}
+		err = fs.Set(name, value)
+		if err != nil {
+			return
+		}
+	}
+	err = cfg.Validate()
+	return
+}
+
+// applyConfig makes cfg, its cache policy and its log settings the ones in
+// use.
+func applyConfig(cfg *config.Config) (err error) {
+	policy, err := cfg.CachePolicy()
+	if err != nil {
+		return
+	}
+	err = logging.Setup(cfg.LogLevel, cfg.LogFormat == config.LogFormatJSON)
+	if err != nil {
+		return
+	}
+	model.SetCachePolicy(policy)
+	config.Set(cfg)
+	return
+}
+
+func openDatabase(cfg *config.Config) (err error) {
+	database.DB, err = database.NewBadgerDB(cfg.DataDir)
+	return
+}
+
+func versionCommand(args []string) error {
+	fmt.Println("sjrpc", version)
+	return nil
+}
+
+func statsCommand(args []string) (err error) {
+	cfg := config.Default()
+	fs := newFlagSet("stats", cfg)
+	cfg, err = parseFlags(fs, cfg, args)
 	if err != nil {
-		log.Fatal(err)
+		return
 	}
-	dbPath := filepath.Join(whereAmI, "database", "data")
-	database.DB, err = database.NewBadgerDB(dbPath)
+	err = openDatabase(cfg)
 	if err != nil {
-		log.Fatal(err)
+		return
 	}
 	defer database.DB.Close()
 
-	webserver := echo.New()
-	webserver.Use(middleware.Logger())
-	webserver.Use(middleware.Recover())
-	webserver.Use(middleware.Decompress())
-	webserver.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowOrigins: []string{"*"},
-	}))
+	stats, err := database.DB.Stats()
+	if err != nil {
+		return
+	}
+	fmt.Printf("Data directory: %s\n", cfg.DataDir)
+	fmt.Printf("LSM size:       %d bytes\n", stats.LSMSize)
+	fmt.Printf("Value log size: %d bytes\n", stats.VLogSize)
+	namespaces := make([]string, 0, len(stats.Namespaces))
+	for namespace := range stats.Namespaces {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	for _, namespace := range namespaces {
+		fmt.Printf("Namespace %s: %d entries\n", namespace, stats.Namespaces[namespace])
+	}
 
-	webserver.GET("/", func(c echo.Context) error {
-		return c.HTML(http.StatusOK, "Hello, This is Save JSON-RPC")
+	var reorgs []handler.ReorgEvent
+	err = database.DB.Scan(database.ReorgsNamespace, nil, []byte{0xff}, func(_, value []byte) error {
+		var event handler.ReorgEvent
+		err := json.Unmarshal(value, &event)
+		if err != nil {
+			return err
+		}
+		reorgs = append(reorgs, event)
+		return nil
 	})
+	if err != nil {
+		return
+	}
+	fmt.Printf("Reorgs:         %d\n", len(reorgs))
+	if len(reorgs) > lastReorgsShown {
+		reorgs = reorgs[len(reorgs)-lastReorgsShown:]
+	}
+	for _, event := range reorgs {
+		fmt.Printf("  %s %s: %d blocks after %d, %d cache entries removed\n",
+			event.When.Format(time.RFC3339), event.Upstream, event.Depth, event.Fork, event.Invalidated)
+	}
+	return
+}
 
-	webserver.OPTIONS("/", func(c echo.Context) error {
-		return c.HTML(http.StatusOK, "")
-	})
+func exportCommand(args []string) (err error) {
+	cfg := config.Default()
+	fs := newFlagSet("export", cfg)
+	out := fs.String("out", "", "backup file to write, stdout if empty")
+	cfg, err = parseFlags(fs, cfg, args)
+	if err != nil {
+		return
+	}
+	err = openDatabase(cfg)
+	if err != nil {
+		return
+	}
+	defer database.DB.Close()
+
+	w := os.Stdout
+	if len(*out) > 0 {
+		w, err = os.Create(*out)
+		if err != nil {
+			return
+		}
+		defer w.Close()
+	}
+	return database.DB.Backup(w)
+}
+
+func importCommand(args []string) (err error) {
+	cfg := config.Default()
+	fs := newFlagSet("import", cfg)
+	in := fs.String("in", "", "backup file to read, stdin if empty")
+	cfg, err = parseFlags(fs, cfg, args)
+	if err != nil {
+		return
+	}
+	err = openDatabase(cfg)
+	if err != nil {
+		return
+	}
+	defer database.DB.Close()
 
-	webserver.GET("/cleanup", handler.DbCleanHandler)
+	r := os.Stdin
+	if len(*in) > 0 {
+		r, err = os.Open(*in)
+		if err != nil {
+			return
+		}
+		defer r.Close()
+	}
+	return database.DB.Load(r)
+}
 
-	webserver.POST("/", handler.PostHandler)
+func purgeCommand(args []string) (err error) {
+	cfg := config.Default()
+	fs := newFlagSet("purge", cfg)
+	namespace := fs.String("namespace", "", "only remove the entries of this namespace")
+	all := fs.Bool("all", false, "remove every namespace, including API keys, their usage and warm jobs")
+	cfg, err = parseFlags(fs, cfg, args)
+	if err != nil {
+		return
+	}
+	err = openDatabase(cfg)
+	if err != nil {
+		return
+	}
+	defer database.DB.Close()
 
-	httpPort := "8434"
+	switch {
+	case len(*namespace) > 0:
+		err = database.DB.DropNamespace([]byte(*namespace))
+	case *all:
+		err = database.DB.DropAll()
+	default:
+		for _, ns := range database.CacheNamespaces {
+			err = database.DB.DropNamespace(ns)
+			if err != nil {
+				return
+			}
+		}
+	}
+	if err != nil {
+		return
+	}
+	slog.Info("cache purged", "namespace", *namespace, "all", *all)
+	return
+}
+
+// listFlag is a flag.Value that collects a list of values. The first use of
+// the flag replaces the default list.
+type listFlag struct {
+	values *[]string
+	set    bool
+}
 
-	webserver.Logger.Fatal(webserver.Start(":" + httpPort))
+func (f *listFlag) String() string {
+	if f.values == nil {
+		return ""
+	}
+	return strings.Join(*f.values, ",")
+}
+
+func (f *listFlag) Set(value string) error {
+	if !f.set {
+		*f.values = nil
+		f.set = true
+	}
+	*f.values = append(*f.values, config.SplitList(value)...)
+	return nil
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/jeffprestes/sjrpc/config"
+	"github.com/jeffprestes/sjrpc/logging"
+	"github.com/jeffprestes/sjrpc/model"
+)
+
+// useTestFlags parses args as the flags of a command, with the configuration
+// in use restored after the test.
+func useTestFlags(t *testing.T, args ...string) *config.Config {
+	policy := model.GetCachePolicy()
+	t.Cleanup(func() {
+		flagValues = make(map[string]string)
+		config.Set(config.Default())
+		model.SetCachePolicy(policy)
+		logging.Setup(config.LogLevelInfo, false)
+	})
+	cfg := config.Default()
+	resolved, err := parseFlags(newFlagSet("test", cfg), cfg, args)
+	if err != nil {
+		t.Fatalf("parse %v: %v", args, err)
+	}
+	return resolved
+}
+
+func writeConfigFile(t *testing.T, path, content string) {
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("cannot write config file: %v", err)
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sjrpc.yaml")
+	writeConfigFile(t, path, `
+listen: ":1000"
+logLevel: warn
+logFormat: json
+dataDir: /var/lib/file
+upstreams: ["http://file.example.com"]
+`)
+	t.Setenv("SJRPC_LISTEN", ":2000")
+	t.Setenv("SJRPC_LOG_LEVEL", "error")
+	t.Setenv("SJRPC_DATA_DIR", "/var/lib/env")
+
+	cfg := useTestFlags(t, "--config", path, "--listen", ":3000", "--upstream", "http://flag.example.com")
+	for _, tc := range []struct {
+		name, got, want string
+	}{
+		{"listen from flag over env and file", cfg.Listen, ":3000"},
+		{"upstream from flag over file", cfg.Upstream(), "http://flag.example.com"},
+		{"data dir from env over file", cfg.DataDir, "/var/lib/env"},
+		{"log level from env over file", cfg.LogLevel, config.LogLevelError},
+		{"log format from file", cfg.LogFormat, config.LogFormatJSON},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("%s: %q, want %q", tc.name, tc.got, tc.want)
+		}
+	}
+	if config.Get() != cfg {
+		t.Error("resolved configuration not in use")
+	}
+}
+
+func TestReloadKeepsFlags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sjrpc.yaml")
+	writeConfigFile(t, path, `
+logLevel: warn
+dataDir: /var/lib/file
+upstreams: ["http://first.example.com"]
+`)
+	useTestFlags(t, "--config", path, "--log-level", "error")
+
+	writeConfigFile(t, path, `
+logLevel: debug
+dataDir: /var/lib/file
+upstreams: ["http://second.example.com", "http://third.example.com"]
+`)
+	reloadConfig()
+	cfg := config.Get()
+	if cfg.LogLevel != config.LogLevelError {
+		t.Errorf("log level %q after reload, want the flag value %q", cfg.LogLevel, config.LogLevelError)
+	}
+	if !slices.Equal(cfg.Upstreams, []string{"http://second.example.com", "http://third.example.com"}) {
+		t.Errorf("upstreams %v not reloaded from the file", cfg.Upstreams)
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jeffprestes/sjrpc/apikey"
+	"github.com/jeffprestes/sjrpc/config"
+	"github.com/jeffprestes/sjrpc/database"
+	"github.com/jeffprestes/sjrpc/handler"
+	"github.com/jeffprestes/sjrpc/tracing"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"golang.org/x/net/http2"
+)
+
+const (
+	// configWatchInterval is how often the configuration file is checked for
+	// changes.
+	configWatchInterval = 2 * time.Second
+
+	// usageFlushInterval is how often the API key usage counters are saved.
+	usageFlushInterval = 10 * time.Second
+
+	// evictionInterval is how often idle upstreams given by clients are
+	// forgotten.
+	evictionInterval = time.Minute
+
+	// shutdownTimeout is how long requests in progress are waited for when
+	// the server stops.
+	shutdownTimeout = 10 * time.Second
+)
+
+func serveCommand(args []string) (err error) {
+	cfg := config.Default()
+	fs := newFlagSet("serve", cfg)
+	cfg, err = parseFlags(fs, cfg, args)
+	if err != nil {
+		return
+	}
+	err = openDatabase(cfg)
+	if err != nil {
+		return
+	}
+	defer database.DB.Close()
+
+	err = apikey.Load()
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	go apikey.RunFlush(ctx, usageFlushInterval)
+	go handler.RunEviction(ctx, evictionInterval)
+	for _, path := range []string{cfg.ConfigFile, cfg.CachePolicyFile} {
+		if len(path) > 0 {
+			go config.Watch(ctx, path, configWatchInterval, reloadConfig)
+		}
+	}
+	go reloadOnSignal(ctx)
+
+	var memoryExporter *tracing.MemoryExporter
+	var exporter sdktrace.SpanExporter
+	switch cfg.Tracing.Exporter {
+	case config.TracingOTLP:
+		exporter, err = tracing.NewOTLPExporter(cfg.Tracing.Endpoint, cfg.Tracing.Headers)
+		if err != nil {
+			return
+		}
+		err = tracing.Setup(exporter, cfg.Tracing.ServiceName, version, cfg.Tracing.SampleRatio)
+	case config.TracingMemory:
+		memoryExporter = tracing.NewMemoryExporter()
+		err = tracing.Setup(memoryExporter, cfg.Tracing.ServiceName, version, cfg.Tracing.SampleRatio)
+	}
+	if err != nil {
+		return
+	}
+	defer tracing.Shutdown()
+
+	webserver := echo.New()
+	webserver.Use(middleware.RequestID())
+	webserver.Use(handler.LogMiddleware)
+	webserver.Use(middleware.Recover())
+	webserver.Use(middleware.Decompress())
+	webserver.Use(handler.CORSMiddleware)
+
+	webserver.GET("/", func(c echo.Context) error {
+		if handler.IsWebSocketRequest(c) {
+			return handler.AuthMiddleware(handler.RateLimitMiddleware(handler.WebSocketHandler))(c)
+		}
+		return c.HTML(http.StatusOK, "Hello, This is Save JSON-RPC")
+	})
+
+	webserver.OPTIONS("/", func(c echo.Context) error {
+		return c.HTML(http.StatusOK, "")
+	})
+
+	// admin endpoints are served on their own address when there is one
+	adminServer := webserver
+	if len(cfg.AdminListen) > 0 {
+		adminServer = echo.New()
+		adminServer.HideBanner = true
+		adminServer.Use(middleware.RequestID())
+		adminServer.Use(handler.LogMiddleware)
+		adminServer.Use(middleware.Recover())
+		adminServer.Listener, err = adminListener(cfg.AdminListen)
+		if err != nil {
+			return
+		}
+	}
+	adminServer.GET("/metrics", handler.MetricsHandler, handler.AuthMiddleware)
+
+	admin := adminServer.Group("/admin", handler.AuthMiddleware, handler.AdminMiddleware)
+	admin.DELETE("/cache", handler.DbCleanHandler)
+	if memoryExporter != nil {
+		admin.GET("/traces", handler.TracesHandler(memoryExporter))
+	}
+
+	admin.POST("/keys", handler.KeyCreateHandler)
+	admin.GET("/keys", handler.KeyListHandler)
+	admin.DELETE("/keys/:"+handler.ParamKeyName, handler.KeyRevokeHandler)
+	admin.GET("/keys/:"+handler.ParamKeyName+"/usage", handler.KeyUsageHandler)
+
+	admin.POST("/warm", handler.WarmStartHandler)
+	admin.GET("/warm", handler.WarmListHandler)
+	admin.DELETE("/warm/:"+handler.ParamWarmJobId, handler.WarmCancelHandler)
+	handler.ResumeWarmJobs()
+
+	webserver.POST("/", handler.PostHandler, handler.AuthMiddleware, handler.RateLimitMiddleware)
+
+	webserver.OPTIONS("/:"+handler.ParamChainName, func(c echo.Context) error {
+		return c.HTML(http.StatusOK, "")
+	})
+	webserver.POST("/:"+handler.ParamChainName, handler.PostHandler, handler.AuthMiddleware, handler.RateLimitMiddleware)
+	webserver.GET("/:"+handler.ParamChainName, handler.WebSocketHandler, handler.AuthMiddleware, handler.RateLimitMiddleware)
+
+	webserver.OPTIONS("/chain/:"+handler.ParamChainId, func(c echo.Context) error {
+		return c.HTML(http.StatusOK, "")
+	})
+	webserver.POST("/chain/:"+handler.ParamChainId, handler.PostHandler, handler.AuthMiddleware, handler.RateLimitMiddleware)
+	webserver.GET("/chain/:"+handler.ParamChainId, handler.WebSocketHandler, handler.AuthMiddleware, handler.RateLimitMiddleware)
+
+	// the same routes with the API key in the path
+	keyPath := "/key/:" + handler.ParamAPIKey
+	for _, path := range []string{keyPath, keyPath + "/:" + handler.ParamChainName, keyPath + "/chain/:" + handler.ParamChainId} {
+		webserver.OPTIONS(path, func(c echo.Context) error {
+			return c.HTML(http.StatusOK, "")
+		})
+		webserver.POST(path, handler.PostHandler, handler.AuthMiddleware, handler.RateLimitMiddleware)
+		webserver.GET(path, handler.WebSocketHandler, handler.AuthMiddleware, handler.RateLimitMiddleware)
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancelShutdown()
+		webserver.Shutdown(shutdownCtx)
+		if adminServer != webserver {
+			adminServer.Shutdown(shutdownCtx)
+		}
+	}()
+	if adminServer != webserver {
+		slog.Info("admin endpoints listening", "address", cfg.AdminListen)
+		go func() {
+			errAdmin := adminServer.Start("")
+			if errAdmin != nil && errAdmin != http.ErrServerClosed {
+				slog.Error("admin server stopped", "error", errAdmin)
+			}
+		}()
+	}
+	switch {
+	case len(cfg.TLS.CertFile) > 0:
+		var certs *certificates
+		certs, err = loadCertificates(cfg.TLS)
+		if err != nil {
+			return
+		}
+		certs.watch(ctx)
+		webserver.TLSServer.Addr = cfg.Listen
+		webserver.TLSServer.TLSConfig = certs.tlsConfig()
+		err = webserver.StartServer(webserver.TLSServer)
+	case cfg.TLS.H2C:
+		err = webserver.StartH2CServer(cfg.Listen, &http2.Server{})
+	default:
+		err = webserver.Start(cfg.Listen)
+	}
+	if err == http.ErrServerClosed {
+		err = nil
+	}
+	errFlush := apikey.Flush()
+	if errFlush != nil {
+		slog.Error("error saving API key usage", "error", errFlush)
+	}
+	return
+}
+
+// adminListener listens on address, a host:port or unix:<path>. A socket left
+// by a previous run is replaced, and the new socket is only accessible by the
+// user running sjrpc and its group.
+func adminListener(address string) (listener net.Listener, err error) {
+	path, isUnix := strings.CutPrefix(address, "unix:")
+	if !isUnix {
+		return net.Listen("tcp", address)
+	}
+	info, err := os.Lstat(path)
+	if err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			err = fmt.Errorf("admin socket %s exists and is not a socket", path)
+			return
+		}
+		err = os.Remove(path)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return
+	}
+	listener, err = net.Listen("unix", path)
+	if err != nil {
+		return
+	}
+	err = os.Chmod(path, 0660)
+	if err != nil {
+		listener.Close()
+		listener = nil
+	}
+	return
+}
+
+// reloadConfig reads the configuration file again and swaps the
+// configuration in use. Settings that need a restart keep their current
+// values.
+func reloadConfig() {
+	current := config.Get()
+	cfg, err := resolveConfig(current.ConfigFile)
+	if err != nil {
+		slog.Error("configuration not reloaded", "error", err)
+		return
+	}
+	if cfg.Listen != current.Listen {
+		slog.Warn("listen address change needs a restart", "listen", cfg.Listen, "kept", current.Listen)
+		cfg.Listen = current.Listen
+	}
+	if cfg.AdminListen != current.AdminListen {
+		slog.Warn("admin listen address change needs a restart", "admin_listen", cfg.AdminListen, "kept", current.AdminListen)
+		cfg.AdminListen = current.AdminListen
+	}
+	if cfg.TLS != current.TLS {
+		slog.Warn("TLS settings change needs a restart, keeping the current ones")
+		cfg.TLS = current.TLS
+	}
+	if !reflect.DeepEqual(cfg.Tracing, current.Tracing) {
+		slog.Warn("tracing settings change needs a restart, keeping the current ones")
+		cfg.Tracing = current.Tracing
+	}
+	if cfg.DataDir != current.DataDir {
+		slog.Warn("data directory change needs a restart", "data_dir", cfg.DataDir, "kept", current.DataDir)
+		cfg.DataDir = current.DataDir
+	}
+	err = applyConfig(cfg)
+	if err != nil {
+		slog.Error("configuration not reloaded", "error", err)
+		return
+	}
+	slog.Info("configuration reloaded", "file", cfg.ConfigFile)
+}
+
+// reloadOnSignal reloads the configuration every time the process receives
+// SIGHUP.
+func reloadOnSignal(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	for {
+		select {
+		case <-signals:
+			reloadConfig()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
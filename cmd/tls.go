@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+
+	"github.com/jeffprestes/sjrpc/config"
+)
+
+// certificates holds the TLS certificate of the server and the CAs of the
+// client certificates, reloaded when their files change so certificates can
+// be rotated without a restart.
+type certificates struct {
+	files     config.TLS
+	cert      atomic.Pointer[tls.Certificate]
+	clientCAs atomic.Pointer[x509.CertPool]
+}
+
+func loadCertificates(files config.TLS) (certs *certificates, err error) {
+	certs = &certificates{files: files}
+	err = certs.load()
+	if err != nil {
+		certs = nil
+	}
+	return
+}
+
+func (certs *certificates) load() (err error) {
+	cert, err := tls.LoadX509KeyPair(certs.files.CertFile, certs.files.KeyFile)
+	if err != nil {
+		return
+	}
+	if len(certs.files.ClientCAFile) > 0 {
+		var data []byte
+		data, err = os.ReadFile(certs.files.ClientCAFile)
+		if err != nil {
+			return
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			err = fmt.Errorf("no certificates found in %s", certs.files.ClientCAFile)
+			return
+		}
+		certs.clientCAs.Store(pool)
+	}
+	certs.cert.Store(&cert)
+	return
+}
+
+// watch reloads the certificates every time one of their files changes,
+// until ctx is done. A certificate and key not matching while they are
+// being replaced are reported and the files in use kept.
+func (certs *certificates) watch(ctx context.Context) {
+	reload := func() {
+		err := certs.load()
+		if err != nil {
+			slog.Error("TLS certificates not reloaded", "error", err)
+			return
+		}
+		slog.Info("TLS certificates reloaded", "cert", certs.files.CertFile)
+	}
+	for _, path := range []string{certs.files.CertFile, certs.files.KeyFile, certs.files.ClientCAFile} {
+		if len(path) > 0 {
+			go config.Watch(ctx, path, configWatchInterval, reload)
+		}
+	}
+}
+
+// tlsConfig returns the TLS configuration of the server, which offers HTTP/2
+// and takes the certificates in use at every handshake. Clients must present
+// a certificate signed by one of the client CAs when there are any.
+func (certs *certificates) tlsConfig() *tls.Config {
+	base := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cfg := base.Clone()
+		cfg.GetConfigForClient = nil
+		cfg.Certificates = []tls.Certificate{*certs.cert.Load()}
+		if pool := certs.clientCAs.Load(); pool != nil {
+			cfg.ClientCAs = pool
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		return cfg, nil
+	}
+	return base
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jeffprestes/sjrpc/config"
+)
+
+// testCert is a certificate and its key, signed by parent or self-signed
+// when parent is nil.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCert(t *testing.T, serial int64, isCA bool, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "sjrpc test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("cannot create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("cannot parse certificate: %v", err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+// write saves the certificate and its key in PEM files.
+func (c *testCert) write(t *testing.T, certFile, keyFile string) {
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatalf("cannot encode key: %v", err)
+	}
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0o600)
+	if err == nil && len(keyFile) > 0 {
+		err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	}
+	if err != nil {
+		t.Fatalf("cannot write certificate: %v", err)
+	}
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}
+}
+
+// serveTLS accepts connections with the configuration of certs and sends
+// the result of their handshakes.
+func serveTLS(t *testing.T, certs *certificates) (addr string, handshakes chan error) {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", certs.tlsConfig())
+	if err != nil {
+		t.Fatalf("cannot listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	handshakes = make(chan error, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			handshakes <- conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	return listener.Addr().String(), handshakes
+}
+
+// servedSerial returns the serial number of the certificate the server
+// presents.
+func servedSerial(t *testing.T, addr string) int64 {
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("cannot connect: %v", err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+}
+
+func TestCertificatesReload(t *testing.T) {
+	dir := t.TempDir()
+	files := config.TLS{CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem")}
+	newTestCert(t, 1, false, nil).write(t, files.CertFile, files.KeyFile)
+	certs, err := loadCertificates(files)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	addr, _ := serveTLS(t, certs)
+	if serial := servedSerial(t, addr); serial != 1 {
+		t.Fatalf("served certificate %d, want 1", serial)
+	}
+
+	newTestCert(t, 2, false, nil).write(t, files.CertFile, files.KeyFile)
+	if err = certs.load(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if serial := servedSerial(t, addr); serial != 2 {
+		t.Errorf("served certificate %d after the swap, want 2", serial)
+	}
+
+	// a certificate replaced without its key yet keeps the previous one
+	newTestCert(t, 3, false, nil).write(t, files.CertFile, "")
+	if err = certs.load(); err == nil {
+		t.Error("certificate loaded with the key of another one")
+	}
+	if serial := servedSerial(t, addr); serial != 2 {
+		t.Errorf("served certificate %d after a failed reload, want 2", serial)
+	}
+}
+
+func TestCertificatesClientCA(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, 10, true, nil)
+	files := config.TLS{
+		CertFile:     filepath.Join(dir, "cert.pem"),
+		KeyFile:      filepath.Join(dir, "key.pem"),
+		ClientCAFile: filepath.Join(dir, "ca.pem"),
+	}
+	newTestCert(t, 1, false, nil).write(t, files.CertFile, files.KeyFile)
+	ca.write(t, files.ClientCAFile, "")
+	certs, err := loadCertificates(files)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	addr, handshakes := serveTLS(t, certs)
+
+	for _, tc := range []struct {
+		name    string
+		certs   []tls.Certificate
+		allowed bool
+	}{
+		{"without certificate", nil, false},
+		{"signed by another CA", []tls.Certificate{newTestCert(t, 12, false, newTestCert(t, 11, true, nil)).tlsCertificate()}, false},
+		{"signed by the client CA", []tls.Certificate{newTestCert(t, 13, false, ca).tlsCertificate()}, true},
+	} {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true, Certificates: tc.certs})
+		if err == nil {
+			// with TLS 1.3 the client learns the certificate was refused on
+			// its first read
+			conn.Read(make([]byte, 1))
+			conn.Close()
+		}
+		if err = <-handshakes; (err == nil) != tc.allowed {
+			t.Errorf("client %s: handshake error %v", tc.name, err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/jeffprestes/sjrpc/config"
+	"github.com/jeffprestes/sjrpc/database"
+	"github.com/jeffprestes/sjrpc/handler"
+)
+
+func warmCommand(args []string) (err error) {
+	cfg := config.Default()
+	fs := newFlagSet("warm", cfg)
+	var job handler.WarmJob
+	fs.StringVar(&job.Chain, "chain", "", "name of a configured chain to warm")
+	chainIdFlag := fs.Int("chain-id", -1, "chainId used to isolate the cache, the same given to the chainId query parameter")
+	fs.Uint64Var(&job.From, "from", 0, "first block number to fetch")
+	fs.Uint64Var(&job.To, "to", 0, "last block number to fetch")
+	fs.Var(&listFlag{values: &job.Methods}, "methods", "data to fetch for each block: blocks, receipts, logs (comma separated)")
+	fs.Var(&listFlag{values: &job.Addresses}, "address", "address whose logs are fetched, can be repeated")
+	fs.Float64Var(&job.RequestsPerSecond, "rps", 10, "upstream requests per second")
+	cfg, err = parseFlags(fs, cfg, args)
+	if err != nil {
+		return
+	}
+	if *chainIdFlag >= 0 {
+		job.ChainID = chainIdFlag
+	}
+	err = job.Prepare()
+	if err != nil {
+		return
+	}
+
+	err = openDatabase(cfg)
+	if err != nil {
+		return
+	}
+	defer database.DB.Close()
+
+	// progress is saved, so an interrupted job continues when run again
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	err = handler.RunWarmJob(ctx, &job)
+	if err != nil && ctx.Err() == nil {
+		return
+	}
+	err = nil
+	fmt.Printf("warm job %s: %s, next block %d\n", job.ID, job.Status, job.Next)
+	return
+}
@@ -0,0 +1,782 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jeffprestes/sjrpc/model"
+	"gopkg.in/yaml.v3"
+)
+
+// Supported log levels.
+const (
+	LogLevelDebug = "debug"
+	LogLevelInfo  = "info"
+	LogLevelWarn  = "warn"
+	LogLevelError = "error"
+)
+
+// Span exporters of Tracing.
+const (
+	TracingOTLP   = "otlp"
+	TracingMemory = "memory"
+)
+
+// Supported log formats.
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// Config holds the runtime settings of sjrpc. Values come from defaults,
+// then the configuration file, then environment variables, then command line
+// flags.
+type Config struct {
+	ConfigFile      string            `yaml:"-"`
+	Listen          string            `yaml:"listen"`
+	AdminListen     string            `yaml:"adminListen"`
+	DataDir         string            `yaml:"dataDir"`
+	Upstreams       []string          `yaml:"upstreams"`
+	WSUpstreams     []string          `yaml:"wsUpstreams"`
+	LogLevel        string            `yaml:"logLevel"`
+	LogFormat       string            `yaml:"logFormat"`
+	CachePolicyFile string            `yaml:"cachePolicyFile"`
+	Chains          []Chain           `yaml:"chains"`
+	Cache           model.CachePolicy `yaml:"cache"`
+	Auth            Auth              `yaml:"auth"`
+	RateLimit       RateLimit         `yaml:"rateLimit"`
+	Transactions    Transactions      `yaml:"transactions"`
+	Firewall        Firewall          `yaml:"firewall"`
+	RPCURL          RPCURL            `yaml:"rpcUrl"`
+	CORS            CORS              `yaml:"cors"`
+	Limits          Limits            `yaml:"limits"`
+	CacheHeaders    CacheHeaders      `yaml:"cacheHeaders"`
+	TLS             TLS               `yaml:"tls"`
+	Tracing         Tracing           `yaml:"tracing"`
+}
+
+// Chain defines the upstream servers of a blockchain network. The chain is
+// served at /<name>, /<alias> and /chain/<chainId>, and its cache is isolated
+// by chainId.
+type Chain struct {
+	Name        string   `yaml:"name"`
+	Aliases     []string `yaml:"aliases"`
+	ChainID     int      `yaml:"chainId"`
+	Upstreams   []string `yaml:"upstreams"`
+	WSUpstreams []string `yaml:"wsUpstreams"`
+	Firewall    Firewall `yaml:"firewall"`
+}
+
+// Auth holds the API keys accepted by the server. Authentication is disabled
+// when there are no keys.
+type Auth struct {
+	Keys []APIKey `yaml:"keys"`
+	// LocalAdmin accepts admin requests from loopback addresses while there
+	// are no API keys, to create the first ones. Connections through a unix
+	// socket are always accepted then.
+	LocalAdmin bool `yaml:"localAdmin"`
+}
+
+// APIKey identifies a client of the server. RateLimit overrides the rate
+// limit of the server, Methods restricts the methods the client can call and
+// Chains, when not empty, lists the only chains it can use. Admin keys can
+// manage the keys created through the admin endpoints.
+type APIKey struct {
+	Name      string     `yaml:"name"`
+	Key       string     `yaml:"key"`
+	RateLimit *RateLimit `yaml:"rateLimit"`
+	Methods   Firewall   `yaml:"methods"`
+	Chains    []string   `yaml:"chains"`
+	Admin     bool       `yaml:"admin"`
+}
+
+// RateLimit limits the number of HTTP requests per client, identified by its
+// API key or IP address. It is disabled when RequestsPerSecond is zero.
+type RateLimit struct {
+	RequestsPerSecond float64 `json:"requestsPerSecond" yaml:"requestsPerSecond"`
+	Burst             int     `json:"burst" yaml:"burst"`
+}
+
+// RPCURL sets the upstreams clients can select with the rpcUrl query
+// parameter, besides the configured upstreams.
+type RPCURL struct {
+	// Allow lists the upstream URLs accepted.
+	Allow []string `yaml:"allow"`
+	// Aliases maps names accepted in place of an URL to upstream URLs.
+	Aliases map[string]string `yaml:"aliases"`
+	// Arbitrary accepts any URL whose host is reachable on public addresses
+	// only.
+	Arbitrary bool `yaml:"arbitrary"`
+}
+
+// Transactions sets how transactions sent with eth_sendRawTransaction are
+// submitted.
+type Transactions struct {
+	// Broadcast sends each transaction to every upstream of its chain instead
+	// of the first one only.
+	Broadcast bool `yaml:"broadcast"`
+}
+
+// CORS sets the browser origins allowed to call the server and the request
+// headers they can send. Empty AllowHeaders accepts the headers asked for by
+// the browser.
+type CORS struct {
+	AllowOrigins []string `yaml:"allowOrigins"`
+	AllowHeaders []string `yaml:"allowHeaders"`
+}
+
+// AllowsOrigin reports if pages of the browser origin can call the server.
+// Allowed origins accept the * and ? wildcards of the CORS middleware.
+func (cors CORS) AllowsOrigin(origin string) bool {
+	for _, allowed := range cors.AllowOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		pattern := strings.ReplaceAll(regexp.QuoteMeta(allowed), "\\*", ".*")
+		pattern = strings.ReplaceAll(pattern, "\\?", ".")
+		if ok, _ := regexp.MatchString("^"+pattern+"$", origin); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// TLS sets how the web server is served. With a certificate and key it
+// serves HTTPS and HTTP/2, otherwise plain HTTP/1.1, or HTTP/2 without TLS
+// (h2c) when H2C is set.
+type TLS struct {
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+	// ClientCAFile enables mutual TLS: clients must present a certificate
+	// signed by one of the CAs in the file.
+	ClientCAFile string `yaml:"clientCaFile"`
+	// H2C serves HTTP/2 over plain connections, for service meshes where
+	// TLS is terminated by a sidecar.
+	H2C bool `yaml:"h2c"`
+}
+
+// Tracing sets where the spans of the requests served are sent. Tracing is
+// off when Exporter is empty.
+type Tracing struct {
+	// Exporter is otlp, which sends the spans to the OTLP/HTTP collector at
+	// Endpoint, or memory, which keeps the last spans in memory.
+	Exporter string            `yaml:"exporter"`
+	Endpoint string            `yaml:"endpoint"`
+	Headers  map[string]string `yaml:"headers"`
+	// ServiceName is the service.name of the spans.
+	ServiceName string `yaml:"serviceName"`
+	// SampleRatio is the share of traces recorded, from 0 to 1. Requests
+	// with a traceparent header follow the decision of their caller.
+	SampleRatio float64 `yaml:"sampleRatio"`
+}
+
+// Limits bounds the requests accepted from clients. Zero disables a limit.
+type Limits struct {
+	// MaxBodySize is the largest HTTP body or WebSocket message, in bytes,
+	// after decompression.
+	MaxBodySize int64 `yaml:"maxBodySize"`
+	// MaxBatchLength is the largest number of requests in a batch.
+	MaxBatchLength int `yaml:"maxBatchLength"`
+	// MaxRequestSize is the largest request of a batch, or single request,
+	// in bytes.
+	MaxRequestSize int `yaml:"maxRequestSize"`
+	// MaxJSONDepth is the deepest nesting of objects and arrays in a
+	// request.
+	MaxJSONDepth int `yaml:"maxJsonDepth"`
+}
+
+// CacheHeaders sets the cache status headers of the HTTP responses.
+type CacheHeaders struct {
+	// Upstream adds the host of the upstream called, which tells clients the
+	// provider behind the server.
+	Upstream bool `yaml:"upstream"`
+	// MaxBatchLength is the largest batch whose requests get their own
+	// values; larger batches only get the summary.
+	MaxBatchLength int `yaml:"maxBatchLength"`
+}
+
+var current atomic.Pointer[Config]
+
+// Get returns the configuration currently in use. It never returns nil.
+func Get() *Config {
+	cfg := current.Load()
+	if cfg == nil {
+		cfg = Default()
+		current.CompareAndSwap(nil, cfg)
+		cfg = current.Load()
+	}
+	return cfg
+}
+
+// Set replaces the configuration currently in use.
+func Set(cfg *Config) {
+	current.Store(cfg)
+}
+
+// Default returns a configuration filled with the default values overridden
+// by the SJRPC_* environment variables when they are set.
+func Default() *Config {
+	cfg := defaults()
+	cfg.ApplyEnv()
+	return cfg
+}
+
+// Load returns the configuration read from the file at path on top of the
+// default values, overridden by the SJRPC_* environment variables.
+func Load(path string) (cfg *Config, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	cfg = defaults()
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err = decoder.Decode(cfg)
+	if err != nil {
+		cfg = nil
+		err = fmt.Errorf("parsing config file %s: %w", path, err)
+		return
+	}
+	cfg.ConfigFile = path
+	cfg.ApplyEnv()
+	return
+}
+
+func defaults() *Config {
+	cfg := &Config{
+		Listen:    ":8434",
+		LogLevel:  LogLevelInfo,
+		LogFormat: LogFormatText,
+		Cache:     *model.DefaultCachePolicy(),
+		CORS: CORS{
+			AllowOrigins: []string{"*"},
+		},
+		Limits: Limits{
+			MaxBodySize:    10 << 20,
+			MaxBatchLength: 1000,
+			MaxRequestSize: 5 << 20,
+			MaxJSONDepth:   64,
+		},
+		CacheHeaders: CacheHeaders{
+			MaxBatchLength: 100,
+		},
+		Tracing: Tracing{
+			ServiceName: "sjrpc",
+			SampleRatio: 1,
+		},
+	}
+	whereAmI, err := os.Getwd()
+	if err == nil {
+		cfg.DataDir = filepath.Join(whereAmI, "database", "data")
+	}
+	return cfg
+}
+
+// Watch checks the file at path every interval and calls reload when its
+// modification time or size changes. It returns when ctx is done.
+func Watch(ctx context.Context, path string, interval time.Duration, reload func()) {
+	var lastModTime time.Time
+	var lastSize int64
+	info, err := os.Stat(path)
+	if err == nil {
+		lastModTime, lastSize = info.ModTime(), info.Size()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			if info.ModTime().Equal(lastModTime) && info.Size() == lastSize {
+				continue
+			}
+			lastModTime, lastSize = info.ModTime(), info.Size()
+			reload()
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// ApplyEnv overrides the configuration with the SJRPC_* environment variables
+// that are set.
+func (cfg *Config) ApplyEnv() {
+	if value := os.Getenv("SJRPC_CONFIG"); len(value) > 0 && len(cfg.ConfigFile) < 1 {
+		cfg.ConfigFile = value
+	}
+	if value := os.Getenv("SJRPC_LISTEN"); len(value) > 0 {
+		cfg.Listen = value
+	}
+	if value := os.Getenv("SJRPC_ADMIN_LISTEN"); len(value) > 0 {
+		cfg.AdminListen = value
+	}
+	if value := os.Getenv("SJRPC_TLS_CERT"); len(value) > 0 {
+		cfg.TLS.CertFile = value
+	}
+	if value := os.Getenv("SJRPC_TLS_KEY"); len(value) > 0 {
+		cfg.TLS.KeyFile = value
+	}
+	if value := os.Getenv("SJRPC_TLS_CLIENT_CA"); len(value) > 0 {
+		cfg.TLS.ClientCAFile = value
+	}
+	if value := os.Getenv("SJRPC_TRACING"); len(value) > 0 {
+		cfg.Tracing.Exporter = value
+	}
+	if value := os.Getenv("SJRPC_OTLP_ENDPOINT"); len(value) > 0 {
+		cfg.Tracing.Endpoint = value
+	}
+	if value := os.Getenv("SJRPC_DATA_DIR"); len(value) > 0 {
+		cfg.DataDir = value
+	}
+	if value := os.Getenv("SJRPC_URL"); len(value) > 0 {
+		cfg.Upstreams = SplitList(value)
+	}
+	if value := os.Getenv("SJRPC_WS_URL"); len(value) > 0 {
+		cfg.WSUpstreams = SplitList(value)
+	}
+	if value := os.Getenv("SJRPC_LOG_LEVEL"); len(value) > 0 {
+		cfg.LogLevel = value
+	}
+	if value := os.Getenv("SJRPC_LOG_FORMAT"); len(value) > 0 {
+		cfg.LogFormat = value
+	}
+	if value := os.Getenv("SJRPC_CACHE_POLICY"); len(value) > 0 {
+		cfg.CachePolicyFile = value
+	}
+}
+
+// Validate checks if the configuration values are usable.
+func (cfg *Config) Validate() (err error) {
+	cfg.LogLevel = strings.ToLower(cfg.LogLevel)
+	switch cfg.LogLevel {
+	case LogLevelDebug, LogLevelInfo, LogLevelWarn, LogLevelError:
+	default:
+		err = fmt.Errorf("invalid log level: %s", cfg.LogLevel)
+		return
+	}
+	cfg.LogFormat = strings.ToLower(cfg.LogFormat)
+	switch cfg.LogFormat {
+	case LogFormatText, LogFormatJSON:
+	default:
+		err = fmt.Errorf("invalid log format: %s", cfg.LogFormat)
+		return
+	}
+	if len(cfg.DataDir) < 1 {
+		err = fmt.Errorf("no data directory set")
+		return
+	}
+	if (len(cfg.TLS.CertFile) > 0) != (len(cfg.TLS.KeyFile) > 0) {
+		err = fmt.Errorf("TLS needs both a certificate and a key file")
+		return
+	}
+	if len(cfg.TLS.ClientCAFile) > 0 && len(cfg.TLS.CertFile) < 1 {
+		err = fmt.Errorf("client certificates need TLS, set a certificate and a key file")
+		return
+	}
+	if cfg.TLS.H2C && len(cfg.TLS.CertFile) > 0 {
+		err = fmt.Errorf("h2c is only used without TLS, HTTP/2 is already served over TLS")
+		return
+	}
+	if len(cfg.Tracing.Exporter) < 1 && len(cfg.Tracing.Endpoint) > 0 {
+		cfg.Tracing.Exporter = TracingOTLP
+	}
+	switch cfg.Tracing.Exporter {
+	case "", TracingMemory:
+	case TracingOTLP:
+		err = validCollectorURL(cfg.Tracing.Endpoint)
+		if err != nil {
+			return
+		}
+	default:
+		err = fmt.Errorf("invalid tracing exporter: %s", cfg.Tracing.Exporter)
+		return
+	}
+	if cfg.Tracing.SampleRatio < 0 || cfg.Tracing.SampleRatio > 1 {
+		err = fmt.Errorf("invalid tracing sample ratio: %g, it goes from 0 to 1", cfg.Tracing.SampleRatio)
+		return
+	}
+	if cfg.AdminListen == "unix:" || (len(cfg.AdminListen) > 0 && cfg.AdminListen == cfg.Listen) {
+		err = fmt.Errorf("invalid admin listen address: %s", cfg.AdminListen)
+		return
+	}
+	names := make(map[string]bool)
+	chainIds := make(map[int]bool)
+	for i, chain := range cfg.Chains {
+		if len(chain.Upstreams) < 1 {
+			err = fmt.Errorf("chain %d (%s) has no upstreams", i, chain.Name)
+			return
+		}
+		if chain.ChainID < 1 {
+			err = fmt.Errorf("chain %d (%s) has no chainId", i, chain.Name)
+			return
+		}
+		if chainIds[chain.ChainID] {
+			err = fmt.Errorf("chainId %d is used by more than one chain", chain.ChainID)
+			return
+		}
+		chainIds[chain.ChainID] = true
+		for _, name := range append([]string{chain.Name}, chain.Aliases...) {
+			if !validChainName(name) {
+				err = fmt.Errorf("chain %d has an invalid name or alias: %q", i, name)
+				return
+			}
+			if names[name] {
+				err = fmt.Errorf("chain name %s is used more than once", name)
+				return
+			}
+			names[name] = true
+		}
+	}
+	keyNames := make(map[string]bool)
+	for i, key := range cfg.Auth.Keys {
+		if len(key.Key) < 1 {
+			err = fmt.Errorf("auth key %d (%s) is empty", i, key.Name)
+			return
+		}
+		if !ValidKeyName(key.Name) || keyNames[key.Name] {
+			err = fmt.Errorf("auth key %d has an invalid or repeated name: %q", i, key.Name)
+			return
+		}
+		keyNames[key.Name] = true
+		err = cfg.ValidateKeyLimits(key)
+		if err != nil {
+			err = fmt.Errorf("auth key %s: %w", key.Name, err)
+			return
+		}
+	}
+	for _, rpcUrl := range cfg.RPCURL.Allow {
+		err = validUpstreamURL(rpcUrl)
+		if err != nil {
+			return
+		}
+	}
+	for alias, rpcUrl := range cfg.RPCURL.Aliases {
+		if strings.Contains(alias, "://") {
+			err = fmt.Errorf("invalid rpcUrl alias: %q", alias)
+			return
+		}
+		err = validUpstreamURL(rpcUrl)
+		if err != nil {
+			return
+		}
+	}
+	err = cfg.Firewall.validate()
+	if err != nil {
+		return
+	}
+	for _, chain := range cfg.Chains {
+		err = chain.Firewall.validate()
+		if err != nil {
+			err = fmt.Errorf("chain %s: %w", chain.Name, err)
+			return
+		}
+	}
+	if cfg.RateLimit.RequestsPerSecond < 0 || cfg.RateLimit.Burst < 0 {
+		err = fmt.Errorf("invalid rate limit: %+v", cfg.RateLimit)
+		return
+	}
+	if cfg.Limits.MaxBodySize < 0 || cfg.Limits.MaxBatchLength < 0 || cfg.Limits.MaxRequestSize < 0 || cfg.Limits.MaxJSONDepth < 0 {
+		err = fmt.Errorf("invalid limits: %+v", cfg.Limits)
+		return
+	}
+	if cfg.CacheHeaders.MaxBatchLength < 0 {
+		err = fmt.Errorf("invalid cache headers batch length: %d", cfg.CacheHeaders.MaxBatchLength)
+		return
+	}
+	return
+}
+
+// CachePolicy returns the cache policy of the configuration. A cache policy
+// file takes precedence over the cache section of the configuration file.
+func (cfg *Config) CachePolicy() (policy *model.CachePolicy, err error) {
+	if len(cfg.CachePolicyFile) > 0 {
+		policy, err = model.LoadCachePolicy(cfg.CachePolicyFile)
+		if err != nil {
+			err = fmt.Errorf("loading cache policy %s: %w", cfg.CachePolicyFile, err)
+		}
+		return
+	}
+	tmp := cfg.Cache
+	policy = &tmp
+	if policy.TimelyTTL < 1 {
+		policy.TimelyTTL = model.DefaultCachePolicy().TimelyTTL
+	}
+	if policy.LogsChunkSize < 1 {
+		policy.LogsChunkSize = model.DefaultCachePolicy().LogsChunkSize
+	}
+	return
+}
+
+// ChainByID returns the configured chain with the chainId, if any.
+func (cfg *Config) ChainByID(chainId int) (chain Chain, ok bool) {
+	for _, chain = range cfg.Chains {
+		if chain.ChainID == chainId {
+			ok = true
+			return
+		}
+	}
+	chain = Chain{}
+	return
+}
+
+// ChainByName returns the configured chain with the name or alias, if any.
+func (cfg *Config) ChainByName(name string) (chain Chain, ok bool) {
+	for _, chain = range cfg.Chains {
+		if chain.Name == name {
+			ok = true
+			return
+		}
+		for _, alias := range chain.Aliases {
+			if alias == name {
+				ok = true
+				return
+			}
+		}
+	}
+	chain = Chain{}
+	return
+}
+
+func validUpstreamURL(rawUrl string) error {
+	tmp, err := url.Parse(rawUrl)
+	if err != nil || len(tmp.Host) < 1 {
+		return fmt.Errorf("invalid upstream URL: %q", rawUrl)
+	}
+	switch tmp.Scheme {
+	case "http", "https", "ws", "wss":
+		return nil
+	}
+	return fmt.Errorf("invalid upstream URL scheme: %q", rawUrl)
+}
+
+func validCollectorURL(rawUrl string) error {
+	tmp, err := url.Parse(rawUrl)
+	if err != nil || len(tmp.Host) < 1 || (tmp.Scheme != "http" && tmp.Scheme != "https") {
+		return fmt.Errorf("invalid OTLP endpoint: %q, an http or https URL is needed", rawUrl)
+	}
+	return nil
+}
+
+// ValidKeyName reports if name can name an API key: letters, digits, '-',
+// '_' and '.'.
+func ValidKeyName(name string) bool {
+	if len(name) < 1 {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateKeyLimits checks the rate limit, method patterns and chains of an
+// API key.
+func (cfg *Config) ValidateKeyLimits(key APIKey) (err error) {
+	if key.RateLimit != nil && (key.RateLimit.RequestsPerSecond < 0 || key.RateLimit.Burst < 0) {
+		err = fmt.Errorf("invalid rate limit: %+v", *key.RateLimit)
+		return
+	}
+	err = key.Methods.validate()
+	if err != nil {
+		return
+	}
+	for _, name := range key.Chains {
+		_, ok := cfg.ChainByName(name)
+		if !ok {
+			err = fmt.Errorf("unknown chain %s", name)
+			return
+		}
+	}
+	return
+}
+
+// reservedPaths are the first path segments of the routes of the server,
+// which chains cannot be named after. Keep it in sync with cmd/serve.go.
+var reservedPaths = map[string]bool{
+	"":        true,
+	"admin":   true,
+	"chain":   true,
+	"key":     true,
+	"metrics": true,
+}
+
+// validChainName reports if name can be used as a URL path segment that does
+// not clash with the other routes of the server.
+func validChainName(name string) bool {
+	if reservedPaths[name] {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// FindAPIKey returns the configured API key matching key, if any.
+func (cfg *Config) FindAPIKey(key string) (apiKey APIKey, ok bool) {
+	for _, apiKey = range cfg.Auth.Keys {
+		if subtle.ConstantTimeCompare([]byte(apiKey.Key), []byte(key)) == 1 {
+			ok = true
+			return
+		}
+	}
+	apiKey = APIKey{}
+	return
+}
+
+// Upstream returns the first configured upstream URL or an empty string.
+func (cfg *Config) Upstream() string {
+	if len(cfg.Upstreams) < 1 {
+		return ""
+	}
+	return cfg.Upstreams[0]
+}
+
+// UpstreamsFor returns the upstream URLs of the chain served by rpcUrl, the
+// first being rpcUrl. Upstreams not configured have only their own URL.
+func (cfg *Config) UpstreamsFor(rpcUrl string) []string {
+	if rpcUrl == cfg.Upstream() {
+		return cfg.Upstreams
+	}
+	for _, chain := range cfg.Chains {
+		if len(chain.Upstreams) > 0 && chain.Upstreams[0] == rpcUrl {
+			return chain.Upstreams
+		}
+	}
+	return []string{rpcUrl}
+}
+
+// IsUpstream reports if rpcUrl is one of the configured upstreams.
+func (cfg *Config) IsUpstream(rpcUrl string) bool {
+	if slices.Contains(cfg.Upstreams, rpcUrl) {
+		return true
+	}
+	for _, chain := range cfg.Chains {
+		if slices.Contains(chain.Upstreams, rpcUrl) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsConfiguredURL reports if url is a configured upstream, an allowed rpcUrl,
+// the URL of an alias or the WebSocket URL of one of them.
+func (cfg *Config) IsConfiguredURL(url string) bool {
+	matches := func(upstreams, wsUpstreams []string) bool {
+		if slices.Contains(upstreams, url) || slices.Contains(wsUpstreams, url) {
+			return true
+		}
+		for _, rpcUrl := range upstreams {
+			if WebSocketURL(rpcUrl) == url {
+				return true
+			}
+		}
+		return false
+	}
+	if matches(cfg.Upstreams, cfg.WSUpstreams) || matches(cfg.RPCURL.Allow, nil) {
+		return true
+	}
+	for _, aliased := range cfg.RPCURL.Aliases {
+		if matches([]string{aliased}, nil) {
+			return true
+		}
+	}
+	for _, chain := range cfg.Chains {
+		if matches(chain.Upstreams, chain.WSUpstreams) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAllowedRPCURL reports if rpcUrl is a configured upstream, an allowed URL
+// or the URL of an alias. URLs given by clients that are not allowed are
+// only accepted in arbitrary mode, when their host is public.
+func (cfg *Config) IsAllowedRPCURL(rpcUrl string) bool {
+	if cfg.IsUpstream(rpcUrl) || slices.Contains(cfg.RPCURL.Allow, rpcUrl) {
+		return true
+	}
+	for _, aliased := range cfg.RPCURL.Aliases {
+		if aliased == rpcUrl {
+			return true
+		}
+	}
+	return false
+}
+
+// WSUpstream returns the first configured upstream WebSocket URL or, when
+// there is none, the first upstream URL with a WebSocket scheme.
+func (cfg *Config) WSUpstream() string {
+	if len(cfg.WSUpstreams) > 0 {
+		return cfg.WSUpstreams[0]
+	}
+	return WebSocketURL(cfg.Upstream())
+}
+
+// WSUpstream returns the first upstream WebSocket URL of the chain or, when
+// there is none, its first upstream URL with a WebSocket scheme.
+func (chain *Chain) WSUpstream() string {
+	if len(chain.WSUpstreams) > 0 {
+		return chain.WSUpstreams[0]
+	}
+	if len(chain.Upstreams) < 1 {
+		return ""
+	}
+	return WebSocketURL(chain.Upstreams[0])
+}
+
+// WSUpstreamFor returns the upstream WebSocket URL of the server behind
+// rpcUrl, looking for it in the configured chains before deriving it from
+// rpcUrl.
+func (cfg *Config) WSUpstreamFor(rpcUrl string) string {
+	if rpcUrl == cfg.Upstream() {
+		return cfg.WSUpstream()
+	}
+	for _, chain := range cfg.Chains {
+		if len(chain.Upstreams) > 0 && chain.Upstreams[0] == rpcUrl {
+			return chain.WSUpstream()
+		}
+	}
+	return WebSocketURL(rpcUrl)
+}
+
+// WebSocketURL replaces the http or https scheme of rpcUrl by ws or wss.
+func WebSocketURL(rpcUrl string) string {
+	if after, found := strings.CutPrefix(rpcUrl, "http://"); found {
+		return "ws://" + after
+	}
+	if after, found := strings.CutPrefix(rpcUrl, "https://"); found {
+		return "wss://" + after
+	}
+	return rpcUrl
+}
+
+// SplitList splits a comma separated list ignoring empty items.
+func SplitList(value string) (items []string) {
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+	return
+}
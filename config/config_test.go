@@ -0,0 +1,71 @@
+package config
+
+import "testing"
+
+func TestValidChainName(t *testing.T) {
+	for _, name := range []string{"", "admin", "chain", "key", "metrics", "a/b", "main net", "sepolia?x"} {
+		if validChainName(name) {
+			t.Errorf("%q accepted as a chain name", name)
+		}
+	}
+	for _, name := range []string{"mainnet", "sepolia", "base-sepolia", "op_mainnet", "arb1.nova", "cleanup", "Admin"} {
+		if !validChainName(name) {
+			t.Errorf("%q refused as a chain name", name)
+		}
+	}
+}
+
+func TestValidateReservedChainName(t *testing.T) {
+	for _, name := range []string{"eth", "admin", "key", "metrics"} {
+		cfg := Default()
+		cfg.Chains = []Chain{{Name: "mainnet", ChainID: 1, Upstreams: []string{"https://example.com"}, Aliases: []string{name}}}
+		err := cfg.Validate()
+		if reserved := name != "eth"; reserved != (err != nil) {
+			t.Errorf("alias %s: error %v", name, err)
+		}
+	}
+}
+
+func TestCORSAllowsOrigin(t *testing.T) {
+	cors := CORS{AllowOrigins: []string{"https://app.example.com", "https://*.example.org", "http://localhost:300?"}}
+	tests := map[string]bool{
+		"https://app.example.com":      true,
+		"https://a.b.example.org":      true,
+		"http://localhost:3000":        true,
+		"https://evil.com":             false,
+		"http://app.example.com":       false,
+		"https://app.example.com.evil": false,
+		"http://localhost:30000":       false,
+	}
+	for origin, want := range tests {
+		if got := cors.AllowsOrigin(origin); got != want {
+			t.Errorf("AllowsOrigin(%s) = %v, want %v", origin, got, want)
+		}
+	}
+	if !(CORS{AllowOrigins: []string{"*"}}).AllowsOrigin("https://any.site") {
+		t.Error("* does not allow every origin")
+	}
+	if (CORS{}).AllowsOrigin("https://any.site") {
+		t.Error("no allowed origins allows an origin")
+	}
+}
+
+func TestIsConfiguredURL(t *testing.T) {
+	cfg := Default()
+	cfg.Upstreams = []string{"https://main.example.com"}
+	cfg.Chains = []Chain{{Name: "sepolia", ChainID: 11155111, Upstreams: []string{"https://sepolia.example.com"}, WSUpstreams: []string{"wss://ws.sepolia.example.com"}}}
+	cfg.RPCURL.Allow = []string{"https://allowed.example.com"}
+	cfg.RPCURL.Aliases = map[string]string{"anvil": "http://localhost:8545"}
+	for _, url := range []string{
+		"https://main.example.com", "wss://main.example.com",
+		"https://sepolia.example.com", "wss://ws.sepolia.example.com",
+		"https://allowed.example.com", "http://localhost:8545", "ws://localhost:8545",
+	} {
+		if !cfg.IsConfiguredURL(url) {
+			t.Errorf("%s not configured", url)
+		}
+	}
+	if cfg.IsConfiguredURL("https://client.example.com") {
+		t.Error("URL given by a client is configured")
+	}
+}
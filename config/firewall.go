@@ -0,0 +1,62 @@
+package config
+
+import (
+	"fmt"
+	"path"
+)
+
+// Firewall restricts the methods clients can call. Patterns are matched as
+// path.Match patterns, so "personal_*" matches every personal method. A
+// method is blocked when it matches a Deny pattern, or when Allow is not
+// empty and it matches no Allow pattern.
+type Firewall struct {
+	Allow []string `json:"allow,omitempty" yaml:"allow"`
+	Deny  []string `json:"deny,omitempty" yaml:"deny"`
+}
+
+// FirewallFor returns the firewall of the chain route name, or of the default
+// route when name is empty. Chains add their deny patterns to the ones of the
+// default route, and their allow patterns, when set, replace them.
+func (cfg *Config) FirewallFor(name string) (firewall Firewall) {
+	firewall = cfg.Firewall
+	if len(name) < 1 {
+		return
+	}
+	chain, ok := cfg.ChainByName(name)
+	if !ok {
+		return
+	}
+	firewall.Deny = append(append([]string{}, cfg.Firewall.Deny...), chain.Firewall.Deny...)
+	if len(chain.Firewall.Allow) > 0 {
+		firewall.Allow = chain.Firewall.Allow
+	}
+	return
+}
+
+// Allows reports if the method can be called.
+func (firewall Firewall) Allows(method string) bool {
+	if matchAny(firewall.Deny, method) {
+		return false
+	}
+	return len(firewall.Allow) < 1 || matchAny(firewall.Allow, method)
+}
+
+func (firewall Firewall) validate() error {
+	for _, pattern := range append(append([]string{}, firewall.Allow...), firewall.Deny...) {
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			return fmt.Errorf("invalid firewall pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+func matchAny(patterns []string, method string) bool {
+	for _, pattern := range patterns {
+		ok, _ := path.Match(pattern, method)
+		if ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package config
+
+import "testing"
+
+func TestFirewallAllows(t *testing.T) {
+	firewall := Firewall{
+		Allow: []string{"eth_*", "net_version", "web3_clientVersio?"},
+		Deny:  []string{"eth_sign*", "eth_sendTransaction"},
+	}
+	tests := map[string]bool{
+		"eth_call":               true,
+		"eth_getLogs":            true,
+		"net_version":            true,
+		"web3_clientVersion":     true,
+		"eth_sign":               false,
+		"eth_signTypedData_v4":   false,
+		"eth_sendTransaction":    false,
+		"eth_sendRawTransaction": true,
+		"net_peerCount":          false,
+		"personal_sign":          false,
+		"debug_eth_call":         false,
+		"eth/call":               false,
+	}
+	for method, want := range tests {
+		if got := firewall.Allows(method); got != want {
+			t.Errorf("Allows(%q) = %v, want %v", method, got, want)
+		}
+	}
+}
+
+func TestFirewallWithoutAllowList(t *testing.T) {
+	firewall := Firewall{Deny: []string{"personal_*", "admin_*"}}
+	for method, want := range map[string]bool{"eth_call": true, "debug_traceTransaction": true, "personal_sign": false, "admin_peers": false} {
+		if got := firewall.Allows(method); got != want {
+			t.Errorf("Allows(%q) = %v, want %v", method, got, want)
+		}
+	}
+	if !(Firewall{}).Allows("anything") {
+		t.Error("empty firewall blocks a method")
+	}
+}
+
+func TestFirewallFor(t *testing.T) {
+	cfg := Default()
+	cfg.Firewall = Firewall{Allow: []string{"eth_*"}, Deny: []string{"eth_sign"}}
+	cfg.Chains = []Chain{
+		{Name: "mainnet", ChainID: 1, Upstreams: []string{"https://example.com"}, Firewall: Firewall{Deny: []string{"eth_getLogs"}}},
+		{Name: "sepolia", ChainID: 11155111, Upstreams: []string{"https://example.org"}, Firewall: Firewall{Allow: []string{"debug_*"}}},
+	}
+	tests := []struct {
+		chain, method string
+		want          bool
+	}{
+		{"", "eth_getLogs", true},
+		{"", "eth_sign", false},
+		{"mainnet", "eth_getLogs", false},
+		{"mainnet", "eth_sign", false},
+		{"mainnet", "eth_call", true},
+		{"sepolia", "debug_traceTransaction", true},
+		{"sepolia", "eth_call", false},
+		{"sepolia", "eth_sign", false},
+		{"unknown", "eth_call", true},
+	}
+	for _, test := range tests {
+		if got := cfg.FirewallFor(test.chain).Allows(test.method); got != test.want {
+			t.Errorf("%s: Allows(%q) = %v, want %v", test.chain, test.method, got, test.want)
+		}
+	}
+}
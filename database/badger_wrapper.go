@@ -1,10 +1,13 @@
 package database
 
 import (
+	"bytes"
 	"context"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
+	"sync/atomic"
 	"time"
 
 	"github.com/dgraph-io/badger/v4"
@@ -24,6 +27,30 @@ const (
 // BadgerAlertNamespace defines the alerts BadgerDB namespace.
 var BadgerAlertNamespace = []byte("alerts")
 
+// GCMetrics counts the value log garbage collections of the database.
+type GCMetrics struct {
+	// Runs is the number of collections tried.
+	Runs uint64
+	// Rewrites is the number of collections that rewrote a value log file.
+	Rewrites uint64
+	// Failures is the number of collections that failed.
+	Failures uint64
+}
+
+var (
+	gcRuns     atomic.Uint64
+	gcRewrites atomic.Uint64
+	gcFailures atomic.Uint64
+)
+
+// GCStats returns the garbage collection counters.
+func GCStats() (metrics GCMetrics) {
+	metrics.Runs = gcRuns.Load()
+	metrics.Rewrites = gcRewrites.Load()
+	metrics.Failures = gcFailures.Load()
+	return
+}
+
 type (
 	// DBInstance defines an embedded key/value store database interface.
 	DBInstance interface {
@@ -31,9 +58,33 @@ type (
 		Update(namespace, key, value []byte) error
 		Insert(namespace, key, value []byte) error
 		Has(namespace, key []byte) (bool, error)
+		Delete(namespace, key []byte) error
+		UpdateMany(namespace []byte, items []Item) error
+		DeleteMany(namespace []byte, keys [][]byte) error
+		Scan(namespace, start, end []byte, fn func(key, value []byte) error) error
+		DropNamespace(namespace []byte) error
+		DropAll() error
+		Stats() (Stats, error)
+		Size() (lsm, vlog int64)
+		Backup(w io.Writer) error
+		Load(r io.Reader) error
 		Close() error
 	}
 
+	// Item is a key/value pair.
+	Item struct {
+		Key   []byte
+		Value []byte
+	}
+
+	// Stats describes the database disk usage and the number of keys stored in
+	// each namespace.
+	Stats struct {
+		LSMSize    int64
+		VLogSize   int64
+		Namespaces map[string]int64
+	}
+
 	// BadgerDB is a wrapper around a BadgerDB backend database that implements
 	// the DB interface.
 	BadgerDB struct {
@@ -54,7 +105,7 @@ func NewBadgerDB(dataDir string) (DBInstance, error) {
 	opts.Logger = nil
 	opts.SyncWrites = true
 
-	log.Printf("Opening db with these options: %+v\n", opts)
+	slog.Info("opening database", "dir", dataDir)
 
 	badgerDB, err := badger.Open(opts)
 	if err != nil {
@@ -79,17 +130,13 @@ func (bdb *BadgerDB) Get(namespace, key []byte) (value string, err error) {
 		if err != nil {
 			return err
 		}
-		// log.Println("Get - View: ", item)
 		err = item.Value(func(val []byte) error {
 			value = string(val)
-			// log.Println("Get - View - Val: ", string(val))
-			// log.Println("Get - View - value 1: ", value)
 			return nil
 		})
 		if err != nil {
 			return err
 		}
-		// log.Println("Get - View - Value 2: ", value)
 		return nil
 	})
 	return
@@ -102,7 +149,7 @@ func (bdb *BadgerDB) Update(namespace, key, value []byte) error {
 		return txn.Set(badgerNamespaceKey(namespace, key), value)
 	})
 	if err != nil {
-		log.Printf("failed to set key %s for namespace %s: %v", key, namespace, err)
+		slog.Error("failed to set key", "namespace", string(namespace), "key", string(key), "error", err)
 		return err
 	}
 
@@ -114,7 +161,7 @@ func (bdb *BadgerDB) Update(namespace, key, value []byte) error {
 func (bdb *BadgerDB) Insert(namespace, key, value []byte) error {
 	has, err := bdb.Has(namespace, key)
 	if err != nil {
-		log.Printf("error checking if exists the key %s for namespace %s: %v", key, namespace, err)
+		slog.Error("error checking if the key exists", "namespace", string(namespace), "key", string(key), "error", err)
 		return err
 	}
 	if has {
@@ -125,7 +172,7 @@ func (bdb *BadgerDB) Insert(namespace, key, value []byte) error {
 		return txn.Set(badgerNamespaceKey(namespace, key), value)
 	})
 	if err != nil {
-		log.Printf("failed to set key %s for namespace %s: %v", key, namespace, err)
+		slog.Error("failed to set key", "namespace", string(namespace), "key", string(key), "error", err)
 		return err
 	}
 	return nil
@@ -155,39 +202,129 @@ func (bdb *BadgerDB) Close() error {
 	return bdb.db.Close()
 }
 
-// TODO: Implement Delete function
-// TODO: Test both and check performance
-/*
+// Delete implements the DB interface. It removes a key from the namespace. It
+// is not an error to delete a key that does not exist.
+func (bdb *BadgerDB) Delete(namespace, key []byte) error {
+	err := bdb.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(badgerNamespaceKey(namespace, key))
+	})
+	if err != nil {
+		slog.Error("failed to delete key", "namespace", string(namespace), "key", string(key), "error", err)
+		return err
+	}
+	return nil
+}
+
+// UpdateMany implements the DB interface. It stores or updates every item in
+// the namespace using a single write batch.
+func (bdb *BadgerDB) UpdateMany(namespace []byte, items []Item) error {
+	wb := bdb.db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, item := range items {
+		err := wb.Set(badgerNamespaceKey(namespace, item.Key), item.Value)
+		if err != nil {
+			slog.Error("failed to set key", "namespace", string(namespace), "key", string(item.Key), "error", err)
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+// DeleteMany implements the DB interface. It removes every key from the
+// namespace using a single write batch.
+func (bdb *BadgerDB) DeleteMany(namespace []byte, keys [][]byte) error {
+	wb := bdb.db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, key := range keys {
+		err := wb.Delete(badgerNamespaceKey(namespace, key))
+		if err != nil {
+			slog.Error("failed to delete key", "namespace", string(namespace), "key", string(key), "error", err)
+			return err
+		}
+	}
+	return wb.Flush()
+}
 
-func (bdb *BadgerDB) Delete(key []byte) error {
- wb := bdb.db.NewWriteBatch()
- defer wb.Cancel()
- err := wb.Delete(key)
- if err != nil {
-  return err
- }
- return wb.Flush()
+// Scan implements the DB interface. It calls fn for every key of the
+// namespace between start and end, both included, in key order. The key
+// given to fn does not include the namespace, and both key and value are
+// only valid during the call.
+func (bdb *BadgerDB) Scan(namespace, start, end []byte, fn func(key, value []byte) error) error {
+	prefix := badgerNamespaceKey(namespace, nil)
+	last := badgerNamespaceKey(namespace, end)
+	return bdb.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(badgerNamespaceKey(namespace, start)); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			if bytes.Compare(item.Key(), last) > 0 {
+				break
+			}
+			err := item.Value(func(val []byte) error {
+				return fn(item.Key()[len(prefix):], val)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
-or
+// DropNamespace implements the DB interface. It removes every key stored in
+// the namespace.
+func (bdb *BadgerDB) DropNamespace(namespace []byte) error {
+	return bdb.db.DropPrefix(badgerNamespaceKey(namespace, nil))
+}
 
-func (bdb *BadgerDB) DeleteFoo(ctx context.Context, fooKey string) error {
- return bdb.db.Update(func(txn *badger.Txn) error {
-  _, err := txn.Get([]byte(fooKey))
-  if errors.Is(err, badger.ErrKeyNotFound) {
-   return fmt.Errorf("error deleting foo with ID '%s': %w", fooKey, repo.ErrRecordNotFound)
-  } else if err != nil {
-   return fmt.Errorf("error deleting foo with ID '%s': %w", fooKey, err)
-  }
-  err = txn.Delete([]byte(fooKey))
-  if err != nil {
-   return fmt.Errorf("error removing foo with ID '%s' from db: %w", fooKey, err)
-  }
-  return nil
- })
+// DropAll implements the DB interface. It removes every key of the database.
+func (bdb *BadgerDB) DropAll() error {
+	return bdb.db.DropAll()
 }
 
-*/
+// Stats implements the DB interface. It returns the disk usage of the
+// database and counts the keys of each namespace.
+func (bdb *BadgerDB) Stats() (stats Stats, err error) {
+	stats.LSMSize, stats.VLogSize = bdb.db.Size()
+	stats.Namespaces = make(map[string]int64)
+	err = bdb.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			key := it.Item().Key()
+			namespace, _, found := bytes.Cut(key, []byte("/"))
+			if !found {
+				namespace = nil
+			}
+			stats.Namespaces[string(namespace)]++
+		}
+		return nil
+	})
+	return
+}
+
+// Size implements the DB interface. It returns the size of the LSM tree and
+// value log files as last computed by BadgerDB, without scanning the keys.
+func (bdb *BadgerDB) Size() (lsm, vlog int64) {
+	return bdb.db.Size()
+}
+
+// Backup implements the DB interface. It writes a full backup of the
+// database to w.
+func (bdb *BadgerDB) Backup(w io.Writer) error {
+	_, err := bdb.db.Backup(w, 0)
+	return err
+}
+
+// Load implements the DB interface. It restores a backup created by Backup.
+// Existing keys are overwritten.
+func (bdb *BadgerDB) Load(r io.Reader) error {
+	return bdb.db.Load(r, 256)
+}
 
 // runGC triggers the garbage collection for the BadgerDB backend database. It
 // should be run in a goroutine.
@@ -197,13 +334,17 @@ func (bdb *BadgerDB) runGC() {
 		select {
 		case <-ticker.C:
 			err := bdb.db.RunValueLogGC(badgerDiscardRatio)
+			gcRuns.Add(1)
 			if err != nil {
 				// don't report error when GC didn't result in any cleanup
 				if err == badger.ErrNoRewrite {
-					log.Printf("no BadgerDB GC occurred: %v", err)
+					slog.Debug("no BadgerDB GC occurred", "error", err)
 				} else {
-					log.Printf("failed to GC BadgerDB: %v", err)
+					gcFailures.Add(1)
+					slog.Error("failed to GC BadgerDB", "error", err)
 				}
+			} else {
+				gcRewrites.Add(1)
 			}
 
 		case <-bdb.ctx.Done():
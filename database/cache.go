@@ -3,4 +3,44 @@ package database
 var (
 	DB               DBInstance
 	RequestNamespace = []byte("requestNamespace")
+	// LogsNamespace holds the finalized logs of each eth_getLogs filter, by
+	// block number.
+	LogsNamespace = []byte("logs")
+	// LogRangesNamespace holds the block ranges stored in LogsNamespace for
+	// each eth_getLogs filter.
+	LogRangesNamespace = []byte("logRanges")
+	// BlocksNamespace holds blocks with their transaction objects by chain
+	// and hash.
+	BlocksNamespace = []byte("blocks")
+	// BlockNumbersNamespace holds the hash of the canonical block of each
+	// chain and number.
+	BlockNumbersNamespace = []byte("blockNumbers")
+	// TransactionsNamespace holds the hash of the block of each transaction
+	// of the canonical blocks stored, by chain and transaction hash.
+	TransactionsNamespace = []byte("transactions")
+	// BlockRefsNamespace links the cache entries of blocks not final yet to
+	// the number and hash of their block, so they are removed on reorgs.
+	BlockRefsNamespace = []byte("blockRefs")
+	// WarmJobsNamespace holds the warm jobs and their progress, by id.
+	WarmJobsNamespace = []byte("warmJobs")
+	// ReorgsNamespace holds the reorgs seen, by time.
+	ReorgsNamespace = []byte("reorgs")
+	// APIKeysNamespace holds the API keys created through the admin
+	// endpoints, by the hash of the key.
+	APIKeysNamespace = []byte("apiKeys")
+	// APIKeyUsageNamespace holds the number of calls of each API key by day
+	// and method.
+	APIKeyUsageNamespace = []byte("apiKeyUsage")
 )
+
+// CacheNamespaces are the namespaces holding cached upstream data, which can
+// be dropped without losing keys, usage counters or warm jobs.
+var CacheNamespaces = [][]byte{
+	RequestNamespace,
+	LogsNamespace,
+	LogRangesNamespace,
+	BlocksNamespace,
+	BlockNumbersNamespace,
+	TransactionsNamespace,
+	BlockRefsNamespace,
+}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"context"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/jeffprestes/sjrpc/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedDB records a span of every key operation, child of the span of ctx.
+type tracedDB struct {
+	DBInstance
+	ctx context.Context
+}
+
+// Traced returns DB recording a span of every key operation as a child of
+// the span of ctx. It returns DB itself when ctx is not traced.
+func Traced(ctx context.Context) DBInstance {
+	if !trace.SpanFromContext(ctx).IsRecording() {
+		return DB
+	}
+	return &tracedDB{DBInstance: DB, ctx: ctx}
+}
+
+func (db *tracedDB) start(operation string, namespace []byte) trace.Span {
+	_, span := tracing.Start(db.ctx, "badger "+operation, trace.SpanKindClient,
+		attribute.String("db.system", "badger"),
+		attribute.String("db.operation", operation),
+		attribute.String("db.namespace", string(namespace)),
+	)
+	return span
+}
+
+// end ends the span of an operation. Keys not found are not errors.
+func end(span trace.Span, err error) {
+	if err == badger.ErrKeyNotFound {
+		span.SetAttributes(attribute.Bool("db.found", false))
+	} else {
+		tracing.RecordError(span, err)
+	}
+	span.End()
+}
+
+func (db *tracedDB) Get(namespace, key []byte) (value string, err error) {
+	span := db.start("Get", namespace)
+	defer func() { end(span, err) }()
+	value, err = db.DBInstance.Get(namespace, key)
+	return
+}
+
+func (db *tracedDB) Update(namespace, key, value []byte) (err error) {
+	span := db.start("Update", namespace)
+	defer func() { end(span, err) }()
+	err = db.DBInstance.Update(namespace, key, value)
+	return
+}
+
+func (db *tracedDB) Insert(namespace, key, value []byte) (err error) {
+	span := db.start("Insert", namespace)
+	defer func() { end(span, err) }()
+	err = db.DBInstance.Insert(namespace, key, value)
+	return
+}
+
+func (db *tracedDB) Has(namespace, key []byte) (ok bool, err error) {
+	span := db.start("Has", namespace)
+	defer func() { end(span, err) }()
+	ok, err = db.DBInstance.Has(namespace, key)
+	return
+}
+
+func (db *tracedDB) Delete(namespace, key []byte) (err error) {
+	span := db.start("Delete", namespace)
+	defer func() { end(span, err) }()
+	err = db.DBInstance.Delete(namespace, key)
+	return
+}
+
+func (db *tracedDB) UpdateMany(namespace []byte, items []Item) (err error) {
+	span := db.start("UpdateMany", namespace)
+	defer func() { end(span, err) }()
+	span.SetAttributes(attribute.Int("db.items", len(items)))
+	err = db.DBInstance.UpdateMany(namespace, items)
+	return
+}
+
+func (db *tracedDB) DeleteMany(namespace []byte, keys [][]byte) (err error) {
+	span := db.start("DeleteMany", namespace)
+	defer func() { end(span, err) }()
+	span.SetAttributes(attribute.Int("db.items", len(keys)))
+	err = db.DBInstance.DeleteMany(namespace, keys)
+	return
+}
+
+func (db *tracedDB) Scan(namespace, start, stop []byte, fn func(key, value []byte) error) (err error) {
+	span := db.start("Scan", namespace)
+	defer func() { end(span, err) }()
+	err = db.DBInstance.Scan(namespace, start, stop, fn)
+	return
+}
@@ -0,0 +1,207 @@
+package handler
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeffprestes/sjrpc/apikey"
+	"github.com/jeffprestes/sjrpc/config"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/time/rate"
+)
+
+// HeaderAPIKey is the request header carrying the client API key.
+const HeaderAPIKey = "X-Api-Key"
+
+// ParamAPIKey is the route path parameter carrying the client API key, as in
+// /key/<key>/sepolia, for clients that cannot set headers.
+const ParamAPIKey = "apiKey"
+
+// ContextKeyAPIKey is the echo context key holding the authenticated API key
+// name.
+const ContextKeyAPIKey = "sjrpc.apiKey"
+
+// contextKeyKey is the echo context key holding the authenticated apikey.Key.
+const contextKeyKey = "sjrpc.key"
+
+// AuthMiddleware rejects requests without a valid API key when there are API
+// keys. The key is taken from the path, the X-Api-Key header or a bearer
+// Authorization header. Keys are looked up on every request, so they can be
+// changed by reloading the configuration file or through the admin endpoints.
+func AuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(echoCtx echo.Context) error {
+		if !apikey.Enabled() {
+			return next(echoCtx)
+		}
+		key, ok := apikey.Find(requestAPIKey(echoCtx))
+		if !ok {
+			return echo.NewHTTPError(http.StatusUnauthorized, "invalid or missing API key")
+		}
+		echoCtx.Set(ContextKeyAPIKey, key.Name)
+		echoCtx.Set(contextKeyKey, key)
+		return next(echoCtx)
+	}
+}
+
+// AdminMiddleware protects the admin endpoints. Requests sent by browsers,
+// which carry an Origin header, are refused so web pages cannot reach them.
+// When there are API keys an admin key is required. Otherwise only clients
+// connected through a unix socket are accepted, and clients from a loopback
+// address when auth.localAdmin is set: behind a reverse proxy every request
+// comes from a loopback address. It must run after AuthMiddleware.
+func AdminMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(echoCtx echo.Context) error {
+		if len(echoCtx.Request().Header.Get(echo.HeaderOrigin)) > 0 {
+			return echo.NewHTTPError(http.StatusForbidden, "admin endpoints cannot be called from browsers")
+		}
+		if !apikey.Enabled() {
+			req := echoCtx.Request()
+			if !isUnixSocketClient(req) && !(config.Get().Auth.LocalAdmin && isLoopbackClient(req)) {
+				return echo.NewHTTPError(http.StatusForbidden, "admin endpoints need an admin API key or a unix socket connection")
+			}
+			return next(echoCtx)
+		}
+		key, ok := echoCtx.Get(contextKeyKey).(apikey.Key)
+		if !ok || !key.Admin {
+			return echo.NewHTTPError(http.StatusForbidden, "admin API key required")
+		}
+		return next(echoCtx)
+	}
+}
+
+// isUnixSocketClient reports if the request came through a unix socket, whose
+// connections have no host and port.
+func isUnixSocketClient(req *http.Request) bool {
+	return req.RemoteAddr == "" || req.RemoteAddr == "@"
+}
+
+// isLoopbackClient reports if the request came from a loopback address. The
+// connection address is used, not the forwarding headers, which clients can
+// set.
+func isLoopbackClient(req *http.Request) bool {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// requestAPIKey returns the API key sent by the client, if any.
+func requestAPIKey(echoCtx echo.Context) string {
+	if key := echoCtx.Param(ParamAPIKey); len(key) > 0 {
+		return key
+	}
+	if key := echoCtx.Request().Header.Get(HeaderAPIKey); len(key) > 0 {
+		return key
+	}
+	bearer, found := strings.CutPrefix(echoCtx.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+	if found {
+		return strings.TrimSpace(bearer)
+	}
+	return ""
+}
+
+// checkKeyChain returns an error when the API key of the request cannot use
+// the chain served to it.
+func checkKeyChain(echoCtx echo.Context, chainId *int, rpcUrl string) error {
+	key, ok := echoCtx.Get(contextKeyKey).(apikey.Key)
+	if !ok || len(key.Chains) < 1 {
+		return nil
+	}
+	name := routeChainName(echoCtx, chainId, rpcUrl)
+	// the default route has no name and is only allowed to keys without
+	// chains
+	for _, item := range key.Chains {
+		chain, found := config.Get().ChainByName(item)
+		if found && len(name) > 0 && chain.Name == name {
+			return nil
+		}
+	}
+	return echo.NewHTTPError(http.StatusForbidden, "API key not allowed on this chain")
+}
+
+// limiterIdleTimeout is how long the limiter of a client is kept after its
+// last request. Limiters idle for longer are removed once their bucket is
+// full again, so the clients get the same limit when they come back.
+const limiterIdleTimeout = 10 * time.Minute
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	limit    config.RateLimit
+	lastSeen time.Time
+}
+
+var (
+	limitersMutex sync.Mutex
+	limiters      = make(map[string]*clientLimiter)
+	limitersPrune time.Time
+)
+
+// errRateLimited answers the requests of clients over their rate limit.
+var errRateLimited = echo.NewHTTPError(http.StatusTooManyRequests, "rate limit exceeded")
+
+// RateLimitMiddleware limits the requests per client, identified by its API
+// key name or IP address. Every request spends a token, which pays for its
+// first JSON-RPC call; the other calls of a batch and the messages of a
+// websocket are charged by the firewall. A client limiter is rebuilt when the
+// configured rate limit changes.
+func RateLimitMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(echoCtx echo.Context) error {
+		if !allowRequest(clientName(echoCtx), clientRateLimit(echoCtx), 1, time.Now()) {
+			return errRateLimited
+		}
+		return next(echoCtx)
+	}
+}
+
+// clientRateLimit returns the rate limit of the API key of the request, or
+// the one of the server.
+func clientRateLimit(echoCtx echo.Context) config.RateLimit {
+	key, ok := echoCtx.Get(contextKeyKey).(apikey.Key)
+	if ok && key.RateLimit != nil {
+		return *key.RateLimit
+	}
+	return config.Get().RateLimit
+}
+
+// allowRequest spends calls tokens of the limiter of client. Clients are not
+// limited when the rate is not positive.
+func allowRequest(client string, limit config.RateLimit, calls int, now time.Time) bool {
+	if limit.RequestsPerSecond <= 0 {
+		return true
+	}
+	limitersMutex.Lock()
+	defer limitersMutex.Unlock()
+	if now.Sub(limitersPrune) >= limiterIdleTimeout {
+		pruneLimiters(now)
+		limitersPrune = now
+	}
+	item, ok := limiters[client]
+	if !ok || item.limit != limit {
+		burst := limit.Burst
+		if burst < 1 {
+			burst = int(limit.RequestsPerSecond) + 1
+		}
+		item = &clientLimiter{
+			limiter: rate.NewLimiter(rate.Limit(limit.RequestsPerSecond), burst),
+			limit:   limit,
+		}
+		limiters[client] = item
+	}
+	item.lastSeen = now
+	return item.limiter.AllowN(now, calls)
+}
+
+// pruneLimiters removes the limiters idle for limiterIdleTimeout whose bucket
+// is full. It must be called holding limitersMutex.
+func pruneLimiters(now time.Time) {
+	for client, item := range limiters {
+		if now.Sub(item.lastSeen) >= limiterIdleTimeout && item.limiter.TokensAt(now) >= float64(item.limiter.Burst()) {
+			delete(limiters, client)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeffprestes/sjrpc/config"
+	"github.com/labstack/echo/v4"
+)
+
+func TestPruneIdleLimiters(t *testing.T) {
+	limitersMutex.Lock()
+	limiters = make(map[string]*clientLimiter)
+	limitersPrune = time.Time{}
+	limitersMutex.Unlock()
+
+	start := time.Now()
+	fast := config.RateLimit{RequestsPerSecond: 10, Burst: 2}
+	// a bucket this slow is still empty after the idle timeout
+	slow := config.RateLimit{RequestsPerSecond: 0.0001, Burst: 1}
+	allowRequest("idle", fast, 1, start)
+	allowRequest("drained", slow, 1, start)
+	allowRequest("active", fast, 1, start.Add(limiterIdleTimeout-time.Second))
+
+	allowRequest("new", fast, 1, start.Add(limiterIdleTimeout+time.Second))
+
+	limitersMutex.Lock()
+	defer limitersMutex.Unlock()
+	if _, ok := limiters["idle"]; ok {
+		t.Error("idle limiter with a full bucket was kept")
+	}
+	for _, client := range []string{"drained", "active", "new"} {
+		if _, ok := limiters[client]; !ok {
+			t.Errorf("limiter of %s was removed", client)
+		}
+	}
+}
+
+func TestAllowRequestLimits(t *testing.T) {
+	limitersMutex.Lock()
+	limiters = make(map[string]*clientLimiter)
+	limitersMutex.Unlock()
+
+	now := time.Now()
+	limit := config.RateLimit{RequestsPerSecond: 1, Burst: 2}
+	for i, want := range []bool{true, true, false} {
+		if got := allowRequest("client", limit, 1, now); got != want {
+			t.Errorf("request %d allowed = %v, want %v", i, got, want)
+		}
+	}
+	if !allowRequest("client", limit, 1, now.Add(time.Second)) {
+		t.Error("request after the refill was refused")
+	}
+}
+
+// postBatch posts a batch of calls eth_chainId calls through the rate limit.
+func postBatch(t *testing.T, rpcUrl string, calls int) int {
+	entries := make([]string, calls)
+	for i := range entries {
+		entries[i] = fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"eth_chainId","params":[]}`, i+1)
+	}
+	request := httptest.NewRequest(http.MethodPost, "/?rpcUrl="+url.QueryEscape(rpcUrl), strings.NewReader("["+strings.Join(entries, ",")+"]"))
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	recorder := httptest.NewRecorder()
+	echoCtx := echo.New().NewContext(request, recorder)
+	echoCtx.SetPath("/")
+	err := RateLimitMiddleware(PostHandler)(echoCtx)
+	if httpErr, ok := err.(*echo.HTTPError); ok {
+		return httpErr.Code
+	} else if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	return recorder.Code
+}
+
+func TestBatchCallsRateLimited(t *testing.T) {
+	useTestDB(t)
+	upstream := newFakeUpstream(t, 100)
+	cfg := config.Default()
+	cfg.RPCURL.Allow = []string{upstream.URL}
+	cfg.RateLimit = config.RateLimit{RequestsPerSecond: 0.001, Burst: 4}
+	config.Set(cfg)
+	defer config.Set(config.Default())
+	limitersMutex.Lock()
+	limiters = make(map[string]*clientLimiter)
+	limitersMutex.Unlock()
+
+	if code := postBatch(t, upstream.URL, 5); code != http.StatusTooManyRequests {
+		t.Errorf("batch of 5 calls with a burst of 4: status %d, want 429", code)
+	}
+	limitersMutex.Lock()
+	limiters = make(map[string]*clientLimiter)
+	limitersMutex.Unlock()
+	if code := postBatch(t, upstream.URL, 4); code != http.StatusOK {
+		t.Errorf("batch of 4 calls with a burst of 4: status %d, want 200", code)
+	}
+	if code := postBatch(t, upstream.URL, 1); code != http.StatusTooManyRequests {
+		t.Errorf("call after the burst was spent: status %d, want 429", code)
+	}
+}
+
+func TestAdminAccess(t *testing.T) {
+	admin := AuthMiddleware(AdminMiddleware(func(echoCtx echo.Context) error {
+		return echoCtx.NoContent(http.StatusOK)
+	}))
+	status := func(remoteAddr, secret string) int {
+		request := httptest.NewRequest(http.MethodDelete, "/admin/cache", nil)
+		request.RemoteAddr = remoteAddr
+		if len(secret) > 0 {
+			request.Header.Set(HeaderAPIKey, secret)
+		}
+		recorder := httptest.NewRecorder()
+		err := admin(echo.New().NewContext(request, recorder))
+		if httpErr, ok := err.(*echo.HTTPError); ok {
+			return httpErr.Code
+		}
+		return recorder.Code
+	}
+	useTestKeys(t)
+	defer config.Set(config.Default())
+
+	cfg := config.Default()
+	config.Set(cfg)
+	for _, tc := range []struct {
+		remoteAddr string
+		want       int
+	}{
+		{"@", http.StatusOK},
+		{"127.0.0.1:1234", http.StatusForbidden},
+		{"[::1]:1234", http.StatusForbidden},
+		{"192.0.2.1:1234", http.StatusForbidden},
+	} {
+		if got := status(tc.remoteAddr, ""); got != tc.want {
+			t.Errorf("without keys %s: status %d, want %d", tc.remoteAddr, got, tc.want)
+		}
+	}
+
+	cfg = config.Default()
+	cfg.Auth.LocalAdmin = true
+	config.Set(cfg)
+	for _, tc := range []struct {
+		remoteAddr string
+		want       int
+	}{
+		{"127.0.0.1:1234", http.StatusOK},
+		{"[::1]:1234", http.StatusOK},
+		{"192.0.2.1:1234", http.StatusForbidden},
+	} {
+		if got := status(tc.remoteAddr, ""); got != tc.want {
+			t.Errorf("localAdmin without keys %s: status %d, want %d", tc.remoteAddr, got, tc.want)
+		}
+	}
+
+	cfg.Auth.Keys = []config.APIKey{
+		{Name: "ops", Key: "ops-secret", Admin: true},
+		{Name: "indexer", Key: "indexer-secret"},
+	}
+	config.Set(cfg)
+	for _, tc := range []struct {
+		remoteAddr string
+		secret     string
+		want       int
+	}{
+		{"127.0.0.1:1234", "", http.StatusUnauthorized},
+		{"@", "", http.StatusUnauthorized},
+		{"127.0.0.1:1234", "indexer-secret", http.StatusForbidden},
+		{"127.0.0.1:1234", "ops-secret", http.StatusOK},
+		{"192.0.2.1:1234", "ops-secret", http.StatusOK},
+	} {
+		if got := status(tc.remoteAddr, tc.secret); got != tc.want {
+			t.Errorf("with keys %s %q: status %d, want %d", tc.remoteAddr, tc.secret, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/jeffprestes/sjrpc/blockstore"
+	"github.com/jeffprestes/sjrpc/database"
+	"github.com/jeffprestes/sjrpc/model"
+	"github.com/jeffprestes/sjrpc/tracker"
+)
+
+// blockRef is the block a block method refers to, by number or by hash.
+type blockRef struct {
+	Number uint64
+	Hash   string
+}
+
+// ProcessBlockRequest answers the block methods from the block store. Blocks
+// are fetched once with their transaction objects, whatever the method and
+// the fullTx parameter. Requests for block tags other than earliest follow the
+// chain head and are not cached.
+func ProcessBlockRequest(ctx context.Context, request *model.RPCRequest, chainId *int, rpcUrl string) (resp string, cacheUsed bool, err error) {
+	ref, ok := blockParam(request)
+	if !ok {
+		resp, err = PerformRemoteCall(ctx, request, rpcUrl)
+		return
+	}
+	block, cacheUsed, resp, err := loadBlock(ctx, request, chainId, rpcUrl, ref)
+	if err != nil || block == nil {
+		return
+	}
+
+	var result json.RawMessage
+	switch request.Method {
+	case "eth_getBlockByNumber", "eth_getBlockByHash":
+		fullTx := len(request.Params) > 1 && request.Params[1] == true
+		result, err = block.Result(fullTx)
+		if err != nil {
+			return
+		}
+	case "eth_getBlockTransactionCountByNumber", "eth_getBlockTransactionCountByHash":
+		result = block.TransactionCount()
+	case "eth_getTransactionByBlockNumberAndIndex", "eth_getTransactionByBlockHashAndIndex":
+		index, errIndex := indexParam(request)
+		if errIndex != nil {
+			respObj := model.NewErrorResponse(request.ID, model.ErrCodeInvalidParams, errIndex.Error())
+			respObj.Jsonrpc = request.JsonRpcVersion
+			resp = respObj.ToString()
+			return
+		}
+		result = block.Transaction(index)
+	default:
+		err = fmt.Errorf("%s is not a block method", request.Method)
+		return
+	}
+	respObj := model.RPCResponse{Jsonrpc: request.JsonRpcVersion, ID: request.ID, Result: result}
+	resp = respObj.ToString()
+	return
+}
+
+// loadBlock returns the block from the store, fetching it from upstream when
+// missing. When upstream does not return a block, block is nil and resp is
+// the upstream response.
+func loadBlock(ctx context.Context, request *model.RPCRequest, chainId *int, rpcUrl string, ref blockRef) (block *blockstore.Block, cacheUsed bool, resp string, err error) {
+	lookupCtx, lookup := startLookup(ctx, tierBlocks)
+	if len(ref.Hash) > 0 {
+		block, cacheUsed, err = blockstore.ByHash(lookupCtx, chainId, ref.Hash)
+	} else {
+		block, cacheUsed, err = blockstore.ByNumber(lookupCtx, chainId, ref.Number)
+	}
+	endLookup(lookup, cacheUsed, err)
+	if err != nil || cacheUsed {
+		return
+	}
+
+	var blockRequest model.RPCRequest
+	blockRequest.JsonRpcVersion = request.JsonRpcVersion
+	blockRequest.ID = request.ID
+	if len(ref.Hash) > 0 {
+		blockRequest.Method = "eth_getBlockByHash"
+		blockRequest.Params = append(blockRequest.Params, ref.Hash, true)
+	} else {
+		blockRequest.Method = "eth_getBlockByNumber"
+		blockRequest.Params = append(blockRequest.Params, fmt.Sprintf("0x%x", ref.Number), true)
+	}
+	resp, err = PerformRemoteCall(ctx, &blockRequest, rpcUrl)
+	if err != nil {
+		return
+	}
+	var respObj model.RPCResponse
+	err = json.Unmarshal([]byte(resp), &respObj)
+	if err != nil || respObj.Error != nil || model.IsNullResult(respObj.Result) {
+		err = nil
+		return
+	}
+	block, err = blockstore.Parse(respObj.Result)
+	if err != nil {
+		return
+	}
+	err = StoreBlock(ctx, chainId, rpcUrl, block, len(ref.Hash) < 1)
+	if err != nil {
+		return
+	}
+	prefetchReceipts(ctx, chainId, rpcUrl, block)
+	return
+}
+
+// StoreBlock saves a block fetched from rpcUrl. canonical is true for blocks
+// fetched by number, which become the block of their number; when the block is
+// not final yet, the number is linked to it so a reorg removes it.
+func StoreBlock(ctx context.Context, chainId *int, rpcUrl string, block *blockstore.Block, canonical bool) (err error) {
+	err = blockstore.Store(ctx, chainId, block, canonical)
+	if err != nil || !canonical {
+		return
+	}
+	linkToBlock(ctx, rpcUrl, database.BlockNumbersNamespace, blockstore.NumberKey(chainId, block.Number), block.Number, block.Hash)
+	return
+}
+
+// transactionFromBlocks answers eth_getTransactionByHash from the canonical
+// blocks stored. cacheUsed is false when the transaction is not found.
+func transactionFromBlocks(ctx context.Context, request *model.RPCRequest, chainId *int) (resp string, cacheUsed bool, err error) {
+	if len(request.Params) < 1 {
+		return
+	}
+	hash, ok := request.Params[0].(string)
+	if !ok {
+		return
+	}
+	ctx, lookup := startLookup(ctx, tierAfterFinal)
+	tx, cacheUsed, err := blockstore.TransactionByHash(ctx, chainId, hash)
+	endLookup(lookup, cacheUsed, err)
+	if err != nil || !cacheUsed {
+		return
+	}
+	respObj := model.RPCResponse{Jsonrpc: request.JsonRpcVersion, ID: request.ID, Result: tx}
+	resp = respObj.ToString()
+	return
+}
+
+// blockParam returns the block of the first parameter. ok is false for tags
+// following the chain head and invalid parameters.
+func blockParam(request *model.RPCRequest) (ref blockRef, ok bool) {
+	if len(request.Params) < 1 {
+		return
+	}
+	param, isString := request.Params[0].(string)
+	if !isString {
+		return
+	}
+	if strings.HasSuffix(request.Method, "ByHash") || strings.HasPrefix(request.Method, "eth_getTransactionByBlockHash") {
+		ok = len(param) == 66 && strings.HasPrefix(param, "0x")
+		ref.Hash = strings.ToLower(param)
+		return
+	}
+	if strings.ToLower(param) == "earliest" {
+		ok = true
+		return
+	}
+	number, err := tracker.ParseHexUint64(param)
+	if err != nil {
+		return
+	}
+	ref.Number = number
+	ok = true
+	return
+}
+
+// indexParam returns the transaction index of the second parameter.
+func indexParam(request *model.RPCRequest) (index uint64, err error) {
+	if len(request.Params) < 2 {
+		err = fmt.Errorf("missing transaction index")
+		return
+	}
+	param, ok := request.Params[1].(string)
+	if !ok {
+		err = fmt.Errorf("invalid transaction index")
+		return
+	}
+	index, err = tracker.ParseHexUint64(param)
+	if err != nil {
+		err = fmt.Errorf("invalid transaction index: %s", param)
+	}
+	return
+}
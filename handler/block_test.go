@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/jeffprestes/sjrpc/blockstore"
+	"github.com/jeffprestes/sjrpc/model"
+)
+
+// storeTestBlock stores a canonical block of the upstream holding a
+// transaction for each of txHashes.
+func storeTestBlock(t *testing.T, chainId *int, rpcUrl string, number uint64, hash string, txHashes ...string) {
+	txs := make([]json.RawMessage, 0, len(txHashes))
+	for _, txHash := range txHashes {
+		txs = append(txs, json.RawMessage(fmt.Sprintf(`{"hash":%q,"blockHash":%q,"blockNumber":"0x%x"}`, txHash, hash, number)))
+	}
+	result, _ := json.Marshal(map[string]any{"number": fmt.Sprintf("0x%x", number), "hash": hash, "transactions": txs})
+	block, err := blockstore.Parse(result)
+	if err != nil {
+		t.Fatalf("cannot parse block: %v", err)
+	}
+	if err = StoreBlock(context.Background(), chainId, rpcUrl, block, true); err != nil {
+		t.Fatalf("cannot store block: %v", err)
+	}
+}
+
+func TestTransactionByHashFromBlocks(t *testing.T) {
+	useTestDB(t)
+	upstream := newFakeUpstream(t, 100)
+	chainId := 1
+	txHash := fmt.Sprintf("0x%064x", 0xabc)
+	storeTestBlock(t, &chainId, upstream.URL, 80, fmt.Sprintf("0x%064x", 80), txHash)
+
+	lookup := func(hash string) (resp string, cacheUsed bool) {
+		request := &model.RPCRequest{JsonRpcVersion: "2.0", ID: 1, Method: "eth_getTransactionByHash", Params: []any{hash}}
+		resp, cacheUsed, err := processAfterFinalRequest(context.Background(), request, &chainId, upstream.URL)
+		if err != nil {
+			t.Fatalf("lookup %s: %v", hash, err)
+		}
+		return
+	}
+
+	resp, cacheUsed := lookup(txHash)
+	var response struct {
+		Result struct {
+			Hash      string `json:"hash"`
+			BlockHash string `json:"blockHash"`
+		} `json:"result"`
+	}
+	json.Unmarshal([]byte(resp), &response)
+	if !cacheUsed || response.Result.Hash != txHash || len(upstream.calls("eth_getTransactionByHash")) != 0 {
+		t.Fatalf("transaction of a stored block not answered from the index: %s, %v", resp, cacheUsed)
+	}
+
+	if _, cacheUsed = lookup(fmt.Sprintf("0x%064x", 0xdef)); cacheUsed || len(upstream.calls("eth_getTransactionByHash")) != 1 {
+		t.Errorf("missing transaction not fetched upstream")
+	}
+
+	storeTestBlock(t, &chainId, upstream.URL, 80, fmt.Sprintf("0x%064x", 0x80ff))
+	if _, cacheUsed = lookup(txHash); cacheUsed || len(upstream.calls("eth_getTransactionByHash")) != 2 {
+		t.Errorf("reorged out transaction not fetched upstream")
+	}
+}
@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jeffprestes/sjrpc/database"
+	"github.com/jeffprestes/sjrpc/logging"
+	"github.com/jeffprestes/sjrpc/model"
+	"github.com/jeffprestes/sjrpc/tracker"
+)
+
+var (
+	// index of the block parameter of the block bound methods
+	blockParamIndex = map[string]int{
+		"eth_getBlockReceipts":              0,
+		"eth_getProof":                      2,
+		"eth_getUncleByBlockHashAndIndex":   0,
+		"eth_getUncleByBlockNumberAndIndex": 0,
+		"eth_getUncleCountByBlockHash":      0,
+		"eth_getUncleCountByBlockNumber":    0,
+		"debug_traceBlockByNumber":          0,
+		"debug_traceBlockByHash":            0,
+		"trace_block":                       0,
+	}
+
+	// index of the transaction hash parameter of the block bound methods
+	transactionParamIndex = map[string]int{
+		"debug_traceTransaction": 0,
+		"trace_transaction":      0,
+	}
+)
+
+// processBlockBoundRequest answers methods whose response is fixed once the
+// block they depend on is final. Requests by block hash are stored at once,
+// as the block with a hash does not change. Requests for block tags following
+// the chain head, for pending transactions and for methods whose block
+// parameter is unknown are not cached.
+func processBlockBoundRequest(ctx context.Context, request *model.RPCRequest, chainId *int, rpcUrl string) (resp string, cacheUsed bool, err error) {
+	block, ok, err := requestBlock(ctx, request, chainId, rpcUrl)
+	if err != nil {
+		return
+	}
+	if !ok {
+		resp, err = PerformRemoteCall(ctx, request, rpcUrl)
+		return
+	}
+	resp, cacheUsed, err = cachedAfterFinal(ctx, request, chainId, rpcUrl)
+	if err != nil || cacheUsed {
+		return
+	}
+
+	resp, err = PerformRemoteCall(ctx, request, rpcUrl)
+	if err != nil || model.IsResultNull(resp) {
+		return
+	}
+	if len(block.Hash) > 0 && block.Number == 0 {
+		// only the hash is known
+		database.Traced(ctx).Insert(database.RequestNamespace, request.Hash(chainId), []byte(resp))
+		return
+	}
+	finalized, errFinalized := HeadTracker(rpcUrl).Finalized(ctx)
+	if errFinalized != nil {
+		logging.FromContext(ctx).Warn("error getting the finalized block", "upstream", upstreamHost(rpcUrl), "error", errFinalized)
+		return
+	}
+	cacheAfterFinal(ctx, request, chainId, rpcUrl, resp, block, finalized)
+	return
+}
+
+// requestBlock returns the block a block bound request depends on. Requests
+// on a transaction depend on the block including it, found with a cached
+// eth_getTransactionByHash. ok is false when the block is not fixed.
+func requestBlock(ctx context.Context, request *model.RPCRequest, chainId *int, rpcUrl string) (block model.ResultBlock, ok bool, err error) {
+	index, isTransaction := transactionParamIndex[request.Method]
+	if isTransaction {
+		if len(request.Params) <= index {
+			return
+		}
+		hash, isString := request.Params[index].(string)
+		if !isString {
+			return
+		}
+		var txRequest model.RPCRequest
+		txRequest.JsonRpcVersion = "2.0"
+		txRequest.Method = "eth_getTransactionByHash"
+		txRequest.ID = 1
+		txRequest.Params = append(txRequest.Params, hash)
+		var resp string
+		resp, _, err = processAfterFinalRequest(ctx, &txRequest, chainId, rpcUrl)
+		if err != nil {
+			return
+		}
+		block, ok = txRequest.ResultBlock(resp)
+		return
+	}
+
+	index, isBlock := blockParamIndex[request.Method]
+	if !isBlock || len(request.Params) <= index {
+		return
+	}
+	param := request.Params[index]
+	// EIP-1898 block parameter
+	object, isObject := param.(map[string]any)
+	if isObject {
+		param = object["blockHash"]
+		if param == nil {
+			param = object["blockNumber"]
+		}
+	}
+	tag, isString := param.(string)
+	if !isString {
+		return
+	}
+	if len(tag) == 66 && strings.HasPrefix(tag, "0x") {
+		block.Hash = strings.ToLower(tag)
+		ok = true
+		return
+	}
+	if strings.ToLower(tag) == "earliest" {
+		ok = true
+		return
+	}
+	block.Number, err = tracker.ParseHexUint64(tag)
+	// other tags follow the chain head
+	ok = err == nil
+	err = nil
+	return
+}
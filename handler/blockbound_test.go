@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/jeffprestes/sjrpc/model"
+)
+
+func TestRequestBlock(t *testing.T) {
+	// block hashes are compared in lower case
+	hash := fmt.Sprintf("0x%064X", 0xabc)
+	byHash := model.ResultBlock{Hash: fmt.Sprintf("0x%064x", 0xabc)}
+	for _, tc := range []struct {
+		name   string
+		method string
+		params []any
+		ok     bool
+		want   model.ResultBlock
+	}{
+		{"number", "eth_getBlockReceipts", []any{"0x64"}, true, model.ResultBlock{Number: 100}},
+		{"hash", "eth_getBlockReceipts", []any{hash}, true, byHash},
+		{"earliest", "eth_getBlockReceipts", []any{"earliest"}, true, model.ResultBlock{}},
+		{"latest", "eth_getBlockReceipts", []any{"latest"}, false, model.ResultBlock{}},
+		{"safe", "eth_getBlockReceipts", []any{"safe"}, false, model.ResultBlock{}},
+		{"finalized", "eth_getBlockReceipts", []any{"finalized"}, false, model.ResultBlock{}},
+		{"pending", "eth_getBlockReceipts", []any{"pending"}, false, model.ResultBlock{}},
+		{"EIP-1898 block hash", "eth_getProof", []any{"0x01", []any{}, map[string]any{"blockHash": hash}}, true, byHash},
+		{"EIP-1898 canonical block hash", "eth_getProof", []any{"0x01", []any{}, map[string]any{"blockHash": hash, "requireCanonical": true}}, true, byHash},
+		{"EIP-1898 block number", "eth_getProof", []any{"0x01", []any{}, map[string]any{"blockNumber": "0x64"}}, true, model.ResultBlock{Number: 100}},
+		{"EIP-1898 block tag", "eth_getProof", []any{"0x01", []any{}, map[string]any{"blockNumber": "latest"}}, false, model.ResultBlock{}},
+		{"EIP-1898 empty", "eth_getProof", []any{"0x01", []any{}, map[string]any{}}, false, model.ResultBlock{}},
+		{"missing block", "eth_getProof", []any{"0x01", []any{}}, false, model.ResultBlock{}},
+		{"invalid number", "eth_getBlockReceipts", []any{"0xzz"}, false, model.ResultBlock{}},
+		{"not a string", "eth_getBlockReceipts", []any{100}, false, model.ResultBlock{}},
+		{"unknown method", "eth_call", []any{map[string]any{}, "0x64"}, false, model.ResultBlock{}},
+	} {
+		request := &model.RPCRequest{JsonRpcVersion: "2.0", ID: 1, Method: tc.method, Params: tc.params}
+		block, ok, err := requestBlock(context.Background(), request, nil, "")
+		if err != nil || ok != tc.ok || block != tc.want {
+			t.Errorf("%s: block %+v, ok %v, error %v, want %+v, ok %v", tc.name, block, ok, err, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestRequestBlockOfTransaction(t *testing.T) {
+	useTestDB(t)
+	upstream := newFakeUpstream(t, 100)
+	chainId := 1
+	txHash := fmt.Sprintf("0x%064x", 0xabc)
+	storeTestBlock(t, &chainId, upstream.URL, 80, fmt.Sprintf("0x%064x", 80), txHash)
+
+	request := &model.RPCRequest{JsonRpcVersion: "2.0", ID: 1, Method: "debug_traceTransaction", Params: []any{txHash}}
+	block, ok, err := requestBlock(context.Background(), request, &chainId, upstream.URL)
+	if err != nil || !ok || block.Number != 80 || block.Hash != fmt.Sprintf("0x%064x", 80) {
+		t.Errorf("block of a stored transaction %+v, ok %v, error %v", block, ok, err)
+	}
+
+	// the fake upstream does not know other transactions
+	request.Params = []any{fmt.Sprintf("0x%064x", 0xdef)}
+	if block, ok, err = requestBlock(context.Background(), request, &chainId, upstream.URL); ok || err != nil {
+		t.Errorf("block of an unknown transaction %+v, ok %v, error %v", block, ok, err)
+	}
+}
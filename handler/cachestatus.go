@@ -0,0 +1,204 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeffprestes/sjrpc/config"
+	"github.com/jeffprestes/sjrpc/upstream"
+	"github.com/labstack/echo/v4"
+)
+
+// Response headers telling clients how their requests were answered. Batches
+// get one comma separated value per request, in the order of the batch, and
+// the number of requests of each cache status in HeaderCacheSummary. Batches
+// longer than the configured length only get the summary. HeaderUpstream is
+// only sent when enabled in the configuration.
+const (
+	HeaderCache        = "X-Sjrpc-Cache"
+	HeaderTier         = "X-Sjrpc-Tier"
+	HeaderUpstream     = "X-Sjrpc-Upstream"
+	HeaderAge          = "X-Sjrpc-Age"
+	HeaderCacheSummary = "X-Sjrpc-Cache-Summary"
+)
+
+// HeaderBypass is the request header asking for fresh upstream responses, as
+// Cache-Control: no-cache does.
+const HeaderBypass = "X-Sjrpc-Bypass"
+
+// Cache statuses of a response.
+const (
+	// CacheHit is a response from the cache.
+	CacheHit = "HIT"
+	// CacheMiss is a response from upstream, cached when its tier caches it.
+	CacheMiss = "MISS"
+	// CacheStale is a cached response older than the chain head, served
+	// while it is within the TTL of its tier.
+	CacheStale = "STALE"
+	// CacheBypass is a response from upstream the client asked for, not
+	// cached.
+	CacheBypass = "BYPASS"
+)
+
+// CacheStatus tells how a request was answered.
+type CacheStatus struct {
+	// Cache is the cache status, empty for requests not processed, as the
+	// ones blocked by the firewall.
+	Cache string
+	Tier  string
+	// Upstream is the provider called, empty when the cache answered.
+	Upstream string
+	// Fetched is when the response came from upstream, zero when unknown.
+	Fetched time.Time
+
+	stale   bool
+	latency time.Duration
+}
+
+type cacheStatusKey struct{}
+
+type cacheBypassKey struct{}
+
+func withCacheStatus(ctx context.Context, status *CacheStatus) context.Context {
+	return context.WithValue(ctx, cacheStatusKey{}, status)
+}
+
+func withCacheBypass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheBypassKey{}, true)
+}
+
+func isBypassed(ctx context.Context) bool {
+	bypass, _ := ctx.Value(cacheBypassKey{}).(bool)
+	return bypass
+}
+
+// noteCached records when a response answered from the cache came from
+// upstream, and if it is older than the chain head.
+func noteCached(ctx context.Context, fetched time.Time, stale bool) {
+	status, ok := ctx.Value(cacheStatusKey{}).(*CacheStatus)
+	if !ok {
+		return
+	}
+	status.Fetched = fetched
+	status.stale = stale
+}
+
+// noteUpstreamLatency adds the duration of an upstream call to the request
+// answered.
+func noteUpstreamLatency(ctx context.Context, latency time.Duration) {
+	status, ok := ctx.Value(cacheStatusKey{}).(*CacheStatus)
+	if !ok {
+		return
+	}
+	status.latency += latency
+}
+
+// recordCacheStatus sets the cache status of a request answered by the tier
+// given.
+func recordCacheStatus(ctx context.Context, tier string, rpcUrl string, cacheUsed bool, bypass bool) {
+	status, ok := ctx.Value(cacheStatusKey{}).(*CacheStatus)
+	if !ok {
+		return
+	}
+	status.Tier = tier
+	switch {
+	case bypass:
+		status.Cache = CacheBypass
+	case cacheUsed && status.stale:
+		status.Cache = CacheStale
+	case cacheUsed:
+		status.Cache = CacheHit
+	default:
+		status.Cache = CacheMiss
+	}
+	if !cacheUsed {
+		status.Upstream = upstream.Provider(rpcUrl)
+		status.Fetched = time.Now()
+	}
+}
+
+// requestsBypass reports if the client asked for fresh upstream responses.
+func requestsBypass(echoCtx echo.Context) bool {
+	header := echoCtx.Request().Header
+	bypass, err := strconv.ParseBool(header.Get(HeaderBypass))
+	if err == nil && bypass {
+		return true
+	}
+	for _, directive := range strings.Split(header.Get(echo.HeaderCacheControl), ",") {
+		if strings.EqualFold(strings.TrimSpace(directive), "no-cache") {
+			return true
+		}
+	}
+	return false
+}
+
+// setCacheHeaders tells the client how the requests of its body were
+// answered.
+func setCacheHeaders(echoCtx echo.Context, statuses []CacheStatus) {
+	settings := config.Get().CacheHeaders
+	header := echoCtx.Response().Header()
+	if len(statuses) == 1 {
+		status := statuses[0]
+		if len(status.Cache) < 1 {
+			return
+		}
+		header.Set(HeaderCache, status.Cache)
+		header.Set(HeaderTier, status.Tier)
+		if settings.Upstream && len(status.Upstream) > 0 {
+			header.Set(HeaderUpstream, status.Upstream)
+		}
+		if !status.Fetched.IsZero() {
+			header.Set(HeaderAge, age(status.Fetched))
+		}
+		return
+	}
+	if len(statuses) < 1 {
+		return
+	}
+	listed := len(statuses) <= settings.MaxBatchLength
+	caches := make([]string, len(statuses))
+	tiers := make([]string, len(statuses))
+	ages := make([]string, len(statuses))
+	var upstreams []string
+	counts := make(map[string]int)
+	for i, status := range statuses {
+		caches[i], tiers[i], ages[i] = "-", "-", "-"
+		if len(status.Cache) < 1 {
+			continue
+		}
+		caches[i], tiers[i] = status.Cache, status.Tier
+		counts[status.Cache]++
+		if !status.Fetched.IsZero() {
+			ages[i] = age(status.Fetched)
+		}
+		if len(status.Upstream) > 0 && !slices.Contains(upstreams, status.Upstream) {
+			upstreams = append(upstreams, status.Upstream)
+		}
+	}
+	if listed {
+		header.Set(HeaderCache, strings.Join(caches, ", "))
+		header.Set(HeaderTier, strings.Join(tiers, ", "))
+		header.Set(HeaderAge, strings.Join(ages, ", "))
+	}
+	if settings.Upstream && len(upstreams) > 0 {
+		header.Set(HeaderUpstream, strings.Join(upstreams, ", "))
+	}
+	var summary []string
+	for _, cache := range []string{CacheHit, CacheStale, CacheMiss, CacheBypass} {
+		if counts[cache] > 0 {
+			summary = append(summary, fmt.Sprintf("%s=%d", cache, counts[cache]))
+		}
+	}
+	if len(summary) > 0 {
+		header.Set(HeaderCacheSummary, strings.Join(summary, ", "))
+	}
+}
+
+// age returns the seconds since fetched.
+func age(fetched time.Time) string {
+	return strconv.FormatInt(int64(time.Since(fetched)/time.Second), 10)
+}
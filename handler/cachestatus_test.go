@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jeffprestes/sjrpc/config"
+	"github.com/labstack/echo/v4"
+)
+
+func cacheHeaders(statuses []CacheStatus) http.Header {
+	recorder := httptest.NewRecorder()
+	echoCtx := echo.New().NewContext(httptest.NewRequest(http.MethodPost, "/", nil), recorder)
+	setCacheHeaders(echoCtx, statuses)
+	return recorder.Header()
+}
+
+func TestCacheHeaders(t *testing.T) {
+	fetched := time.Now().Add(-3 * time.Second)
+	statuses := []CacheStatus{
+		{Cache: CacheHit, Tier: tierPermanent, Fetched: fetched},
+		{},
+		{Cache: CacheMiss, Tier: tierTimely, Upstream: "eth.example.com", Fetched: time.Now()},
+	}
+	header := cacheHeaders(statuses)
+	want := map[string]string{
+		HeaderCache:        "HIT, -, MISS",
+		HeaderTier:         "permanent, -, timely",
+		HeaderAge:          "3, -, 0",
+		HeaderCacheSummary: "HIT=1, MISS=1",
+		HeaderUpstream:     "",
+	}
+	for name, value := range want {
+		if got := header.Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+
+	single := cacheHeaders(statuses[2:])
+	if single.Get(HeaderCache) != CacheMiss || single.Get(HeaderUpstream) != "" || single.Get(HeaderCacheSummary) != "" {
+		t.Errorf("single request headers %v", single)
+	}
+}
+
+func TestCacheHeadersUpstream(t *testing.T) {
+	cfg := config.Default()
+	cfg.CacheHeaders.Upstream = true
+	config.Set(cfg)
+	defer config.Set(config.Default())
+
+	status := CacheStatus{Cache: CacheMiss, Tier: tierTimely, Upstream: "eth.example.com"}
+	if got := cacheHeaders([]CacheStatus{status}).Get(HeaderUpstream); got != "eth.example.com" {
+		t.Errorf("upstream header %q", got)
+	}
+	other := status
+	other.Upstream = "backup.example.com"
+	if got := cacheHeaders([]CacheStatus{status, status, other}).Get(HeaderUpstream); got != "eth.example.com, backup.example.com" {
+		t.Errorf("batch upstream header %q", got)
+	}
+}
+
+func TestCacheHeadersLargeBatch(t *testing.T) {
+	length := config.Get().CacheHeaders.MaxBatchLength
+	statuses := make([]CacheStatus, length+1)
+	for i := range statuses {
+		statuses[i] = CacheStatus{Cache: CacheHit, Tier: tierPermanent, Fetched: time.Now()}
+	}
+	header := cacheHeaders(statuses)
+	for _, name := range []string{HeaderCache, HeaderTier, HeaderAge} {
+		if header.Get(name) != "" {
+			t.Errorf("%s sent for a batch of %d requests", name, len(statuses))
+		}
+	}
+	if got, want := header.Get(HeaderCacheSummary), fmt.Sprintf("HIT=%d", length+1); got != want {
+		t.Errorf("summary %q, want %q", got, want)
+	}
+	if header := cacheHeaders(statuses[:length]); header.Get(HeaderCache) == "" {
+		t.Errorf("no %s for a batch of %d requests", HeaderCache, length)
+	}
+}
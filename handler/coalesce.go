@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/jeffprestes/sjrpc/model"
+	"github.com/jeffprestes/sjrpc/upstream"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// upstream calls in progress, by upstream and request
+var inflightCalls sync.Map
+
+type inflightCall struct {
+	done chan struct{}
+	resp string
+	err  error
+}
+
+// isCoalescable reports if identical requests sent at the same time can share
+// an upstream call: requests of a cache tier, whose responses are shared
+// anyway once cached. Filters and transactions change upstream state.
+func isCoalescable(request *model.RPCRequest) bool {
+	return isUpstreamCached(cacheTier(request))
+}
+
+// coalescedCall calls upstream unless an identical call is in progress, whose
+// response is then shared. Responses keep the id of the request that called
+// upstream, it is replaced before answering the clients. A shared call
+// canceled by its client is made again for the others.
+func coalescedCall(ctx context.Context, request *model.RPCRequest, rpcUrl string) (resp string, err error) {
+	key := rpcUrl + "\xff" + string(request.Hash(nil))
+	call := &inflightCall{done: make(chan struct{})}
+	tmp, loaded := inflightCalls.LoadOrStore(key, call)
+	if !loaded {
+		call.resp, call.err = upstream.For(rpcUrl).Call(ctx, request)
+		inflightCalls.Delete(key)
+		close(call.done)
+		return call.resp, call.err
+	}
+
+	coalescedCalls.WithLabelValues(upstream.ProviderLabel(rpcUrl)).Inc()
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("sjrpc.coalesced", true))
+	shared := tmp.(*inflightCall)
+	select {
+	case <-shared.done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		return
+	}
+	if errors.Is(shared.err, context.Canceled) || errors.Is(shared.err, context.DeadlineExceeded) {
+		return upstream.For(rpcUrl).Call(ctx, request)
+	}
+	return shared.resp, shared.err
+}
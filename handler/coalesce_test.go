@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jeffprestes/sjrpc/model"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// gatedUpstream holds every call until release is closed, and counts them.
+type gatedUpstream struct {
+	*httptest.Server
+	calls   atomic.Int32
+	arrived chan struct{}
+	release chan struct{}
+}
+
+func newGatedUpstream(t *testing.T) *gatedUpstream {
+	upstream := &gatedUpstream{arrived: make(chan struct{}, 16), release: make(chan struct{})}
+	upstream.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream.calls.Add(1)
+		upstream.arrived <- struct{}{}
+		select {
+		case <-upstream.release:
+		case <-r.Context().Done():
+			return
+		}
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+func coalescableRequest() *model.RPCRequest {
+	return &model.RPCRequest{JsonRpcVersion: "2.0", ID: 1, Method: "eth_getBlockByNumber", Params: []any{"0x10", false}}
+}
+
+// waitCoalesced waits until n calls wait for the shared call.
+func waitCoalesced(t *testing.T, before float64, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(coalescedCalls.WithLabelValues("other"))-before < float64(n) {
+		if time.Now().After(deadline) {
+			t.Fatal("calls not coalesced")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCoalescedCallShared(t *testing.T) {
+	upstream := newGatedUpstream(t)
+	before := testutil.ToFloat64(coalescedCalls.WithLabelValues("other"))
+
+	const clients = 5
+	var wg sync.WaitGroup
+	resps := make([]string, clients)
+	errs := make([]error, clients)
+	call := func(i int) {
+		defer wg.Done()
+		resps[i], errs[i] = coalescedCall(context.Background(), coalescableRequest(), upstream.URL)
+	}
+	wg.Add(1)
+	go call(0)
+	<-upstream.arrived
+	for i := 1; i < clients; i++ {
+		wg.Add(1)
+		go call(i)
+	}
+	waitCoalesced(t, before, clients-1)
+	close(upstream.release)
+	wg.Wait()
+
+	if n := upstream.calls.Load(); n != 1 {
+		t.Errorf("%d upstream calls, want 1", n)
+	}
+	for i := range resps {
+		if errs[i] != nil || resps[i] != `{"jsonrpc":"2.0","id":1,"result":"0x1"}` {
+			t.Errorf("client %d got %q, %v", i, resps[i], errs[i])
+		}
+	}
+}
+
+func TestCoalescedCallCanceled(t *testing.T) {
+	upstream := newGatedUpstream(t)
+	before := testutil.ToFloat64(coalescedCalls.WithLabelValues("other"))
+
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, err := coalescedCall(leaderCtx, coalescableRequest(), upstream.URL)
+		leaderErr <- err
+	}()
+	<-upstream.arrived
+
+	followerCtx, cancelFollower := context.WithCancel(context.Background())
+	followerErr := make(chan error, 1)
+	go func() {
+		_, err := coalescedCall(followerCtx, coalescableRequest(), upstream.URL)
+		followerErr <- err
+	}()
+	var resp string
+	var err error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		resp, err = coalescedCall(context.Background(), coalescableRequest(), upstream.URL)
+	}()
+	waitCoalesced(t, before, 2)
+
+	// a canceled client waiting for the shared call gives up alone
+	cancelFollower()
+	if err := <-followerErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled client error %v", err)
+	}
+	// the call is made again when the client that made it is canceled
+	cancelLeader()
+	if err := <-leaderErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled leader error %v", err)
+	}
+	<-upstream.arrived
+	close(upstream.release)
+	<-done
+	if err != nil || resp != `{"jsonrpc":"2.0","id":1,"result":"0x1"}` {
+		t.Errorf("remaining client got %q, %v", resp, err)
+	}
+	if n := upstream.calls.Load(); n != 2 {
+		t.Errorf("%d upstream calls, want 2", n)
+	}
+}
@@ -2,32 +2,27 @@ package handler
 
 import (
 	"net/http"
-	"os"
-	"path/filepath"
+	"sync"
 
 	"github.com/jeffprestes/sjrpc/database"
+	"github.com/jeffprestes/sjrpc/localcache"
 	"github.com/labstack/echo/v4"
 )
 
+// DbCleanHandler removes the cached upstream data. API keys, their usage and
+// warm jobs are kept.
 func DbCleanHandler(echoCtx echo.Context) error {
-	var err error
-	err = database.DB.Close()
-	if err != nil {
-		return err
+	for _, namespace := range database.CacheNamespaces {
+		err := database.DB.DropNamespace(namespace)
+		if err != nil {
+			return err
+		}
 	}
-	whereAmI, err := os.Getwd()
-	if err != nil {
-		return err
+	for _, cache := range []*sync.Map{&localcache.TimelyRequests, &localcache.UnfinalizedRequests} {
+		cache.Range(func(key, _ any) bool {
+			cache.Delete(key)
+			return true
+		})
 	}
-	dbPath := filepath.Join(whereAmI, "database", "data")
-	err = os.RemoveAll(dbPath)
-	if err != nil {
-		return err
-	}
-	database.DB, err = database.NewBadgerDB(dbPath)
-	if err != nil {
-		return err
-	}
-	echoCtx.Response().Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSONCharsetUTF8)
-	return echoCtx.String(http.StatusOK, "{'status':'ok'}")
+	return echoCtx.JSON(http.StatusOK, map[string]string{"status": "ok"})
 }
@@ -0,0 +1,312 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/jeffprestes/sjrpc/localcache"
+	"github.com/jeffprestes/sjrpc/model"
+	"github.com/jeffprestes/sjrpc/subscription"
+	"github.com/jeffprestes/sjrpc/tracker"
+)
+
+const (
+	// Filters not polled for this long are removed, as geth does.
+	filterTimeout = 5 * time.Minute
+
+	// Maximum number of blocks returned by a single poll of a block filter.
+	maxBlockFilterChanges = 256
+
+	// JSON-RPC error code used by geth for unknown filters.
+	errCodeFilterNotFound = -32000
+)
+
+// ProcessFilterRequest answers the stateful filter methods locally, so they
+// work when upstream servers are load-balanced. Filter changes are computed
+// from the head tracker and the cached eth_getLogs.
+func ProcessFilterRequest(ctx context.Context, request *model.RPCRequest, chainId *int, rpcUrl string) (resp string, err error) {
+	removeExpiredFilters()
+
+	var result any
+	var rpcErr *model.RPCError
+	switch request.Method {
+	case "eth_newFilter":
+		result, rpcErr, err = newFilter(ctx, request, chainId, rpcUrl, model.LogFilter)
+	case "eth_newBlockFilter":
+		result, rpcErr, err = newFilter(ctx, request, chainId, rpcUrl, model.BlockFilter)
+	case "eth_getFilterChanges":
+		result, rpcErr, err = getFilterChanges(ctx, request, chainId, rpcUrl)
+	case "eth_getFilterLogs":
+		result, rpcErr, err = getFilterLogs(ctx, request, chainId, rpcUrl)
+	case "eth_uninstallFilter":
+		result, rpcErr = uninstallFilter(request, chainId, rpcUrl)
+	default:
+		err = fmt.Errorf("%s is not a filter method", request.Method)
+	}
+	if err != nil {
+		return
+	}
+
+	var respObj model.RPCResponse
+	if rpcErr != nil {
+		respObj = model.NewErrorResponse(request.ID, rpcErr.Code, rpcErr.Message)
+	} else {
+		respObj = model.NewResultResponse(request.ID, result)
+	}
+	respObj.Jsonrpc = request.JsonRpcVersion
+	resp = respObj.ToString()
+	return
+}
+
+func newFilter(ctx context.Context, request *model.RPCRequest, chainId *int, rpcUrl string, kind string) (result any, rpcErr *model.RPCError, err error) {
+	filter := &model.Filter{
+		Kind:    kind,
+		RpcUrl:  rpcUrl,
+		ChainId: chainId,
+	}
+	filter.Poll()
+	if kind == model.LogFilter {
+		filter.Query, err = filterQueryParam(request)
+		if err != nil {
+			rpcErr = &model.RPCError{Code: model.ErrCodeInvalidParams, Message: err.Error()}
+			err = nil
+			return
+		}
+		if len(filter.Query.BlockHash) > 0 {
+			rpcErr = &model.RPCError{Code: model.ErrCodeInvalidParams, Message: "blockHash is not supported by eth_newFilter"}
+			return
+		}
+	}
+
+	latest, err := LatestHead(ctx, rpcUrl)
+	if err != nil {
+		return
+	}
+	filter.LastBlock = latest.Number
+
+	filter.ID, err = subscription.NewSubscriptionID()
+	if err != nil {
+		return
+	}
+	localcache.Filters.Store(filter.ID, filter)
+	result = filter.ID
+	return
+}
+
+func getFilterChanges(ctx context.Context, request *model.RPCRequest, chainId *int, rpcUrl string) (result any, rpcErr *model.RPCError, err error) {
+	filter, rpcErr := lookupFilter(request, chainId, rpcUrl)
+	if rpcErr != nil {
+		return
+	}
+	filter.Mutex.Lock()
+	defer filter.Mutex.Unlock()
+	filter.Poll()
+
+	latest, err := LatestHead(ctx, rpcUrl)
+	if err != nil {
+		return
+	}
+
+	if filter.Kind == model.BlockFilter {
+		from := filter.LastBlock + 1
+		if latest.Number >= maxBlockFilterChanges && from < latest.Number-maxBlockFilterChanges+1 {
+			from = latest.Number - maxBlockFilterChanges + 1
+		}
+		hashes := make([]string, 0)
+		for number := from; number <= latest.Number; number++ {
+			var hash string
+			hash, err = blockHashAt(ctx, rpcUrl, number)
+			if err != nil {
+				return
+			}
+			hashes = append(hashes, hash)
+			filter.LastBlock = number
+		}
+		result = hashes
+		return
+	}
+
+	from := filter.LastBlock + 1
+	to := latest.Number
+	if number, open, errTag := blockTagNumber(filter.Query.ToBlock, latest.Number); errTag == nil && !open && number < to {
+		to = number
+	}
+	if number, open, errTag := blockTagNumber(filter.Query.FromBlock, latest.Number); errTag == nil && !open && number > from {
+		from = number
+	}
+	if from > to {
+		result = []any{}
+		return
+	}
+	// fetch at most the range eth_getLogs requests from upstream at once;
+	// the next poll continues where this one stopped
+	if chunkSize := model.GetCachePolicy().LogsChunkSize; to-from >= chunkSize {
+		to = from + chunkSize - 1
+	}
+	query := filter.Query
+	query.FromBlock = fmt.Sprintf("0x%x", from)
+	query.ToBlock = fmt.Sprintf("0x%x", to)
+	result, rpcErr, err = getLogs(ctx, request, query, chainId, rpcUrl)
+	if err != nil || rpcErr != nil {
+		return
+	}
+	filter.LastBlock = to
+	return
+}
+
+func getFilterLogs(ctx context.Context, request *model.RPCRequest, chainId *int, rpcUrl string) (result any, rpcErr *model.RPCError, err error) {
+	filter, rpcErr := lookupFilter(request, chainId, rpcUrl)
+	if rpcErr != nil {
+		return
+	}
+	if filter.Kind != model.LogFilter {
+		rpcErr = &model.RPCError{Code: errCodeFilterNotFound, Message: "filter not found"}
+		return
+	}
+	filter.Poll()
+	filter.Mutex.Lock()
+	query := filter.Query
+	filter.Mutex.Unlock()
+	result, rpcErr, err = getLogs(ctx, request, query, chainId, rpcUrl)
+	return
+}
+
+func uninstallFilter(request *model.RPCRequest, chainId *int, rpcUrl string) (result any, rpcErr *model.RPCError) {
+	filter, rpcErr := lookupFilter(request, chainId, rpcUrl)
+	if rpcErr != nil {
+		rpcErr = nil
+		result = false
+		return
+	}
+	localcache.Filters.Delete(filter.ID)
+	result = true
+	return
+}
+
+// getLogs runs eth_getLogs through the cache and returns its raw result.
+func getLogs(ctx context.Context, request *model.RPCRequest, query model.FilterQuery, chainId *int, rpcUrl string) (result any, rpcErr *model.RPCError, err error) {
+	var logsRequest model.RPCRequest
+	logsRequest.JsonRpcVersion = "2.0"
+	logsRequest.Method = "eth_getLogs"
+	logsRequest.ID = request.ID
+	logsRequest.Params = append(logsRequest.Params, query)
+	// round trip the params so they hash like a client eth_getLogs request
+	tmp, err := json.Marshal(logsRequest)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(tmp, &logsRequest)
+	if err != nil {
+		return
+	}
+
+	resp, _, err := ProcessRequest(ctx, &logsRequest, chainId, rpcUrl)
+	if err != nil {
+		return
+	}
+	var respObj model.RPCResponse
+	err = json.Unmarshal([]byte(resp), &respObj)
+	if err != nil {
+		return
+	}
+	if respObj.Error != nil {
+		rpcErr = respObj.Error
+		return
+	}
+	result = respObj.Result
+	return
+}
+
+func lookupFilter(request *model.RPCRequest, chainId *int, rpcUrl string) (filter *model.Filter, rpcErr *model.RPCError) {
+	var id string
+	if len(request.Params) > 0 {
+		id, _ = request.Params[0].(string)
+	}
+	tmp, ok := localcache.Filters.Load(strings.ToLower(id))
+	if ok {
+		filter = tmp.(*model.Filter)
+		if filter.BelongsTo(rpcUrl, chainId) && !filter.IsExpired(filterTimeout) {
+			return
+		}
+	}
+	filter = nil
+	rpcErr = &model.RPCError{Code: errCodeFilterNotFound, Message: "filter not found"}
+	return
+}
+
+func removeExpiredFilters() {
+	localcache.Filters.Range(func(key, value any) bool {
+		if value.(*model.Filter).IsExpired(filterTimeout) {
+			localcache.Filters.Delete(key)
+		}
+		return true
+	})
+}
+
+// blockHashAt returns the hash of the block at number, from the head tracker
+// when it saw the block or from upstream otherwise.
+func blockHashAt(ctx context.Context, rpcUrl string, number uint64) (hash string, err error) {
+	hash, ok := HeadTracker(rpcUrl).HashAt(number)
+	if ok {
+		return
+	}
+	var request model.RPCRequest
+	request.JsonRpcVersion = "2.0"
+	request.Method = "eth_getBlockByNumber"
+	request.ID = 1
+	request.Params = append(request.Params, fmt.Sprintf("0x%x", number), false)
+	resp, err := PerformRemoteCall(ctx, &request, rpcUrl)
+	if err != nil {
+		return
+	}
+	var blockResp model.BlockHeaderResponse
+	err = json.Unmarshal([]byte(resp), &blockResp)
+	if err != nil {
+		return
+	}
+	if blockResp.Result == nil {
+		err = fmt.Errorf("block %d not found", number)
+		return
+	}
+	hash = blockResp.Result.Hash
+	return
+}
+
+// filterQueryParam decodes the filter object of the first request param.
+func filterQueryParam(request *model.RPCRequest) (query model.FilterQuery, err error) {
+	if len(request.Params) < 1 {
+		err = fmt.Errorf("missing filter object")
+		return
+	}
+	tmp, err := json.Marshal(request.Params[0])
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(tmp, &query)
+	if err != nil {
+		err = fmt.Errorf("invalid filter object: %w", err)
+	}
+	return
+}
+
+// blockTagNumber converts a block number or tag of a filter. open is true for
+// tags that follow the chain head.
+func blockTagNumber(tag string, latest uint64) (number uint64, open bool, err error) {
+	switch strings.ToLower(tag) {
+	case "", "latest", "pending", "safe", "finalized":
+		open = true
+		number = latest
+		return
+	case "earliest":
+		return
+	}
+	number, err = tracker.ParseHexUint64(tag)
+	if err != nil {
+		slog.Debug("invalid block number in filter", "block", tag)
+	}
+	return
+}
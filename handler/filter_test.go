@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/jeffprestes/sjrpc/localcache"
+	"github.com/jeffprestes/sjrpc/model"
+)
+
+func TestLogFilterPollRange(t *testing.T) {
+	useTestDB(t)
+	upstream := newFakeUpstream(t, 10000)
+	chunkSize := model.GetCachePolicy().LogsChunkSize
+
+	filter := &model.Filter{ID: "0xtestlogfilter", Kind: model.LogFilter, RpcUrl: upstream.URL, LastBlock: 1000}
+	filter.Poll()
+	localcache.Filters.Store(filter.ID, filter)
+	defer localcache.Filters.Delete(filter.ID)
+
+	request := &model.RPCRequest{JsonRpcVersion: "2.0", ID: 1, Method: "eth_getFilterChanges", Params: []any{filter.ID}}
+	_, rpcErr, err := getFilterChanges(context.Background(), request, nil, upstream.URL)
+	if err != nil || rpcErr != nil {
+		t.Fatalf("poll failed: %v %v", err, rpcErr)
+	}
+	if filter.LastBlock != 1000+chunkSize {
+		t.Errorf("last block = %d, want %d", filter.LastBlock, 1000+chunkSize)
+	}
+	calls := upstream.calls("eth_getLogs")
+	if len(calls) != 1 {
+		t.Fatalf("%d eth_getLogs calls, want 1", len(calls))
+	}
+	query := calls[0].Params[0].(map[string]any)
+	wantTo := fmt.Sprintf("0x%x", 1000+chunkSize)
+	if query["fromBlock"] != "0x3e9" || query["toBlock"] != wantTo {
+		t.Errorf("requested %v - %v, want 0x3e9 - %s", query["fromBlock"], query["toBlock"], wantTo)
+	}
+}
+
+func TestFilterExpiryDuringPoll(t *testing.T) {
+	filter := &model.Filter{ID: "0xtestpolledfilter", Kind: model.BlockFilter}
+	filter.Poll()
+	localcache.Filters.Store(filter.ID, filter)
+	defer localcache.Filters.Delete(filter.ID)
+
+	filter.Mutex.Lock()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		filter.Poll()
+	}()
+	removeExpiredFilters()
+	wg.Wait()
+	filter.Mutex.Unlock()
+
+	if _, ok := localcache.Filters.Load(filter.ID); !ok {
+		t.Error("filter polled just now was removed")
+	}
+}
@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/jeffprestes/sjrpc/database"
+	"github.com/jeffprestes/sjrpc/localcache"
+	"github.com/jeffprestes/sjrpc/logging"
+	"github.com/jeffprestes/sjrpc/model"
+	"github.com/jeffprestes/sjrpc/tracker"
+)
+
+// processAfterFinalRequest answers transactions and receipts. Responses of
+// blocks after the finalized block are kept in memory, where reorgs remove
+// them, and are moved to the database once their block is final.
+func processAfterFinalRequest(ctx context.Context, request *model.RPCRequest, chainId *int, rpcUrl string) (resp string, cacheUsed bool, err error) {
+	resp, cacheUsed, err = cachedAfterFinal(ctx, request, chainId, rpcUrl)
+	if err != nil || cacheUsed {
+		return
+	}
+	headTracker := HeadTracker(rpcUrl)
+	if request.Method == "eth_getTransactionByHash" {
+		resp, cacheUsed, err = transactionFromBlocks(ctx, request, chainId)
+		if err != nil || cacheUsed {
+			return
+		}
+	}
+
+	// transactions sent through sjrpc are polled upstream once per head
+	resp, cacheUsed = pendingResponse(ctx, request, chainId, rpcUrl)
+	if cacheUsed {
+		return
+	}
+
+	resp, err = PerformRemoteCall(ctx, request, rpcUrl)
+	if err != nil {
+		return
+	}
+	block, included := request.ResultBlock(resp)
+	recordPoll(ctx, request, chainId, rpcUrl, resp, included)
+	if !included {
+		return
+	}
+	finalized, errFinalized := headTracker.Finalized(ctx)
+	if errFinalized != nil {
+		logging.FromContext(ctx).Warn("error getting the finalized block", "upstream", upstreamHost(rpcUrl), "error", errFinalized)
+		return
+	}
+	cacheAfterFinal(ctx, request, chainId, rpcUrl, resp, block, finalized)
+	return
+}
+
+// cachedAfterFinal returns the response cached by cacheAfterFinal, from the
+// database or from memory.
+func cachedAfterFinal(ctx context.Context, request *model.RPCRequest, chainId *int, rpcUrl string) (resp string, cacheUsed bool, err error) {
+	ctx, lookup := startLookup(ctx, cacheTier(request))
+	defer func() {
+		endLookup(lookup, cacheUsed, err)
+	}()
+	resp, err = database.Traced(ctx).Get(database.RequestNamespace, request.Hash(chainId))
+	if err == nil {
+		cacheUsed = true
+		return
+	}
+	if err != badger.ErrKeyNotFound {
+		return
+	}
+	err = nil
+
+	tmp, ok := localcache.UnfinalizedRequests.Load(request.Base64Hash(chainId))
+	if !ok {
+		return
+	}
+	entry := tmp.(model.UnfinalizedRequest)
+	finalized, errFinalized := HeadTracker(rpcUrl).Finalized(ctx)
+	if errFinalized == nil && entry.Block.Number <= finalized {
+		promote(request.Base64Hash(chainId), entry)
+	}
+	resp = entry.Response
+	cacheUsed = true
+	noteCached(ctx, entry.Fetched, false)
+	return
+}
+
+// cacheAfterFinal stores the response in the database when its block is
+// final, and in memory otherwise. Responses of blocks not final yet are not
+// cached when the head of rpcUrl is not followed, as their reorgs would be
+// missed.
+func cacheAfterFinal(ctx context.Context, request *model.RPCRequest, chainId *int, rpcUrl string, resp string, block model.ResultBlock, finalized uint64) {
+	key := request.Hash(chainId)
+	if block.Number <= finalized {
+		database.Traced(ctx).Insert(database.RequestNamespace, key, []byte(resp))
+		return
+	}
+	if !followsHead(rpcUrl) {
+		return
+	}
+	localcache.UnfinalizedRequests.Store(request.Base64Hash(chainId), model.UnfinalizedRequest{
+		Key:      key,
+		Response: resp,
+		Block:    block,
+		RpcUrl:   rpcUrl,
+		Fetched:  time.Now(),
+	})
+	HeadTracker(rpcUrl).Observe(block.Number, block.Hash)
+}
+
+// promote moves a response whose block became final to the database.
+func promote(hash any, entry model.UnfinalizedRequest) {
+	err := database.DB.Insert(database.RequestNamespace, entry.Key, []byte(entry.Response))
+	if err != nil {
+		slog.Error("error storing finalized response", "error", err)
+		return
+	}
+	localcache.UnfinalizedRequests.Delete(hash)
+}
+
+// promoteFinalized moves the responses of the upstream whose block became
+// final to the database.
+func promoteFinalized(headTracker *tracker.Tracker, finalized uint64) {
+	rpcUrl := headTracker.URL()
+	localcache.UnfinalizedRequests.Range(func(hash, value any) bool {
+		entry := value.(model.UnfinalizedRequest)
+		if entry.RpcUrl == rpcUrl && entry.Block.Number <= finalized {
+			promote(hash, entry)
+		}
+		return true
+	})
+}
+
+// dropUnfinalized removes the responses of the upstream for blocks after
+// fork and returns how many were removed.
+func dropUnfinalized(rpcUrl string, fork uint64) (removed int) {
+	localcache.UnfinalizedRequests.Range(func(hash, value any) bool {
+		entry := value.(model.UnfinalizedRequest)
+		if entry.RpcUrl == rpcUrl && entry.Block.Number > fork {
+			localcache.UnfinalizedRequests.Delete(hash)
+			removed++
+		}
+		return true
+	})
+	return
+}
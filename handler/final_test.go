@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/jeffprestes/sjrpc/database"
+	"github.com/jeffprestes/sjrpc/localcache"
+	"github.com/jeffprestes/sjrpc/model"
+	"github.com/jeffprestes/sjrpc/tracker"
+)
+
+// receiptRequest fetches the receipt of a transaction the fake upstream
+// includes in block number.
+func receiptRequest(t *testing.T, upstream *fakeUpstream, chainId *int, number uint64) *model.RPCRequest {
+	request := &model.RPCRequest{
+		JsonRpcVersion: "2.0",
+		ID:             1,
+		Method:         "eth_getTransactionReceipt",
+		Params:         []any{fmt.Sprintf("0x%064x", 0x1000+number)},
+	}
+	upstream.results[request.Method] = map[string]string{
+		"transactionHash": request.Params[0].(string),
+		"blockNumber":     fmt.Sprintf("0x%x", number),
+		"blockHash":       fmt.Sprintf("0x%064x", number),
+	}
+	_, cacheUsed, err := processAfterFinalRequest(context.Background(), request, chainId, upstream.URL)
+	if err != nil || cacheUsed {
+		t.Fatalf("receipt of block %d: %v, cached %v", number, err, cacheUsed)
+	}
+	t.Cleanup(func() { localcache.UnfinalizedRequests.Delete(request.Base64Hash(chainId)) })
+	return request
+}
+
+// cachedIn returns where the response of the request is cached.
+func cachedIn(t *testing.T, request *model.RPCRequest, chainId *int) (inDatabase, inMemory bool) {
+	ok, err := database.DB.Has(database.RequestNamespace, request.Hash(chainId))
+	if err != nil {
+		t.Fatalf("cannot read entry: %v", err)
+	}
+	_, inMemory = localcache.UnfinalizedRequests.Load(request.Base64Hash(chainId))
+	return ok, inMemory
+}
+
+func TestAfterFinalPromotedOnceFinal(t *testing.T) {
+	useTestDB(t)
+	upstream := newFakeUpstream(t, 100)
+	upstream.follow(t)
+	chainId := 1
+
+	// the fake upstream finalizes 10 blocks behind the head
+	final := receiptRequest(t, upstream, &chainId, 85)
+	if inDatabase, inMemory := cachedIn(t, final, &chainId); !inDatabase || inMemory {
+		t.Errorf("response of a final block in database %v, in memory %v", inDatabase, inMemory)
+	}
+	recent := receiptRequest(t, upstream, &chainId, 95)
+	if inDatabase, inMemory := cachedIn(t, recent, &chainId); inDatabase || !inMemory {
+		t.Errorf("response of a recent block in database %v, in memory %v", inDatabase, inMemory)
+	}
+
+	resp, cacheUsed, err := processAfterFinalRequest(context.Background(), recent, &chainId, upstream.URL)
+	if err != nil || !cacheUsed || len(resp) < 1 || len(upstream.calls("eth_getTransactionReceipt")) != 2 {
+		t.Errorf("recent response not answered from memory: %v, cached %v", err, cacheUsed)
+	}
+
+	promoteFinalized(HeadTracker(upstream.URL), 95)
+	if inDatabase, inMemory := cachedIn(t, recent, &chainId); !inDatabase || inMemory {
+		t.Errorf("finalized response in database %v, in memory %v", inDatabase, inMemory)
+	}
+}
+
+func TestAfterFinalWithoutFinalizedTag(t *testing.T) {
+	useTestDB(t)
+	upstream := newFakeUpstream(t, 100)
+	upstream.noFinalized = true
+	upstream.follow(t)
+	chainId := 1
+
+	finalized, err := HeadTracker(upstream.URL).Finalized(context.Background())
+	if err != nil || finalized != 36 {
+		t.Fatalf("finalized %d, %v, want 64 blocks behind the head", finalized, err)
+	}
+	final := receiptRequest(t, upstream, &chainId, 36)
+	if inDatabase, _ := cachedIn(t, final, &chainId); !inDatabase {
+		t.Error("response 64 blocks behind the head not stored in the database")
+	}
+	recent := receiptRequest(t, upstream, &chainId, 37)
+	if inDatabase, inMemory := cachedIn(t, recent, &chainId); inDatabase || !inMemory {
+		t.Errorf("response 63 blocks behind the head in database %v, in memory %v", inDatabase, inMemory)
+	}
+}
+
+func TestAfterFinalDroppedOnReorg(t *testing.T) {
+	useTestDB(t)
+	upstream := newFakeUpstream(t, 100)
+	upstream.follow(t)
+	chainId := 1
+
+	kept := receiptRequest(t, upstream, &chainId, 96)
+	reorged := receiptRequest(t, upstream, &chainId, 98)
+	rollbackCache(upstream.URL, tracker.Reorg{
+		Fork:     97,
+		Depth:    2,
+		OldHead:  tracker.Head{Number: 99},
+		Orphaned: map[uint64]string{98: "0x98", 99: "0x99"},
+	})
+
+	if _, inMemory := cachedIn(t, kept, &chainId); !inMemory {
+		t.Error("response before the fork removed")
+	}
+	if inDatabase, inMemory := cachedIn(t, reorged, &chainId); inDatabase || inMemory {
+		t.Errorf("response of a reorged block in database %v, in memory %v", inDatabase, inMemory)
+	}
+	if _, cacheUsed, _ := processAfterFinalRequest(context.Background(), reorged, &chainId, upstream.URL); cacheUsed {
+		t.Error("response of a reorged block answered from the cache")
+	}
+}
@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jeffprestes/sjrpc/apikey"
+	"github.com/jeffprestes/sjrpc/config"
+	"github.com/jeffprestes/sjrpc/model"
+	"github.com/labstack/echo/v4"
+)
+
+// Firewall checks the methods called by a client on a route against the
+// firewall configured for the route and the methods of the client API key.
+// The configuration is read on every check, so long lived WebSocket
+// connections follow reloads.
+type Firewall struct {
+	// Chain is the name of the chain served, empty for the default route.
+	Chain  string
+	Client string
+	// APIKey is the name of the client API key, if any.
+	APIKey string
+	// keyRateLimit is the rate limit of the client API key, if it has one.
+	keyRateLimit *config.RateLimit
+}
+
+// NewFirewall returns the firewall of the request route.
+func NewFirewall(echoCtx echo.Context, chainId *int, rpcUrl string) (firewall *Firewall) {
+	firewall = &Firewall{
+		Chain:  routeChainName(echoCtx, chainId, rpcUrl),
+		Client: clientName(echoCtx),
+	}
+	firewall.APIKey, _ = echoCtx.Get(ContextKeyAPIKey).(string)
+	if key, ok := echoCtx.Get(contextKeyKey).(apikey.Key); ok {
+		firewall.keyRateLimit = key.RateLimit
+	}
+	return
+}
+
+// allowCalls spends the rate limit tokens of calls JSON-RPC calls of the
+// client. It allows every call of clients without a firewall.
+func (firewall *Firewall) allowCalls(calls int) bool {
+	if firewall == nil || calls < 1 {
+		return true
+	}
+	limit := config.Get().RateLimit
+	if firewall.keyRateLimit != nil {
+		limit = *firewall.keyRateLimit
+	}
+	return allowRequest(firewall.Client, limit, calls, time.Now())
+}
+
+// routeChainName returns the name of the configured chain served to the
+// request, empty for the default upstream. Requests to the default route
+// selecting a configured chain with the chainId query parameter are served
+// that chain.
+func routeChainName(echoCtx echo.Context, chainId *int, rpcUrl string) (name string) {
+	chain, routed, ok := RouteChain(echoCtx)
+	if routed {
+		if ok {
+			name = chain.Name
+		}
+		return
+	}
+	if chainId != nil {
+		chain, ok = config.Get().ChainByID(*chainId)
+		if ok && chain.Upstreams[0] == rpcUrl {
+			name = chain.Name
+		}
+	}
+	return
+}
+
+// Blocks returns the error response to a request for a method the firewall
+// does not allow, logging the attempt. A nil firewall allows every method.
+func (firewall *Firewall) Blocks(request *model.RPCRequest) (resp string, blocked bool) {
+	if firewall == nil {
+		return
+	}
+	allowed := config.Get().FirewallFor(firewall.Chain).Allows(request.Method)
+	if allowed && len(firewall.APIKey) > 0 {
+		key, ok := apikey.ByName(firewall.APIKey)
+		allowed = ok && key.Methods.Allows(request.Method)
+	}
+	if allowed {
+		return
+	}
+	route := "/"
+	if len(firewall.Chain) > 0 {
+		route += firewall.Chain
+	}
+	slog.Info("method blocked", "rpc_method", request.Method, "route", route, "client", firewall.Client)
+	respObj := model.NewErrorResponse(request.ID, model.ErrCodeMethodNotFound, fmt.Sprintf("the method %s does not exist/is not available", request.Method))
+	resp = respObj.ToString()
+	blocked = true
+	return
+}
+
+// admit counts the call in the usage of the client API key and checks it
+// against the firewall. Blocked calls are counted in the request metrics, as
+// they are not dispatched.
+func admit(request *model.RPCRequest, chainId *int, firewall *Firewall) (resp string, blocked bool) {
+	if firewall != nil && len(firewall.APIKey) > 0 {
+		apikey.Count(firewall.APIKey, request.Method)
+	}
+	resp, blocked = firewall.Blocks(request)
+	if blocked {
+		countRequest(request.Method, chainId, tierBlocked, false, nil)
+	}
+	return
+}
+
+// clientName identifies the client by its API key name or IP address.
+func clientName(echoCtx echo.Context) string {
+	client, ok := echoCtx.Get(ContextKeyAPIKey).(string)
+	if !ok {
+		client = "ip:" + echoCtx.RealIP()
+	}
+	return client
+}
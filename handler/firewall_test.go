@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeffprestes/sjrpc/apikey"
+	"github.com/jeffprestes/sjrpc/config"
+	"github.com/jeffprestes/sjrpc/model"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestBlockedRequestsCounted(t *testing.T) {
+	useTestDB(t)
+	upstream := newFakeUpstream(t, 100)
+	cfg := config.Default()
+	cfg.Auth.Keys = []config.APIKey{{Name: "tester", Key: "secret", Methods: config.Firewall{Allow: []string{"eth_*"}}}}
+	config.Set(cfg)
+	defer config.Set(config.Default())
+
+	today := time.Now().UTC().Format(time.DateOnly)
+	usage, err := apikey.UsageOf("tester")
+	if err != nil {
+		t.Fatalf("UsageOf failed: %v", err)
+	}
+	before := usage[today]
+	blockedRequests := requestsTotal.WithLabelValues("default", "personal_sign", tierBlocked)
+	blockedBefore := testutil.ToFloat64(blockedRequests)
+
+	firewall := &Firewall{Client: "tester", APIKey: "tester"}
+	body := []byte(`[{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]},{"jsonrpc":"2.0","id":2,"method":"personal_sign","params":[]}]`)
+	response, _, err := ProcessRequests(context.Background(), body, nil, upstream.URL, firewall)
+	if err != nil {
+		t.Fatalf("ProcessRequests failed: %v", err)
+	}
+	if !strings.Contains(response, `"result":"0x1"`) || !strings.Contains(response, `"code":-32601`) {
+		t.Errorf("response %s, want the chain id and a method not found error", response)
+	}
+	if n := len(upstream.calls("personal_sign")); n != 0 {
+		t.Errorf("blocked method sent upstream %d times", n)
+	}
+
+	usage, err = apikey.UsageOf("tester")
+	if err != nil {
+		t.Fatalf("UsageOf failed: %v", err)
+	}
+	for _, method := range []string{"eth_chainId", "personal_sign"} {
+		if calls := usage[today][method] - before[method]; calls != 1 {
+			t.Errorf("%d calls of %s counted, want 1", calls, method)
+		}
+	}
+	if blocked := testutil.ToFloat64(blockedRequests) - blockedBefore; blocked != 1 {
+		t.Errorf("%v blocked requests counted, want 1", blocked)
+	}
+}
+
+func TestBlocksIsPure(t *testing.T) {
+	useTestDB(t)
+	firewall := &Firewall{Client: "pure", APIKey: "pure"}
+	cfg := config.Default()
+	cfg.Auth.Keys = []config.APIKey{{Name: "pure", Key: "secret"}}
+	config.Set(cfg)
+	defer config.Set(config.Default())
+
+	request := model.RPCRequest{JsonRpcVersion: "2.0", ID: 1, Method: "eth_call"}
+	if _, blocked := firewall.Blocks(&request); blocked {
+		t.Error("eth_call blocked")
+	}
+	usage, err := apikey.UsageOf("pure")
+	if err != nil || len(usage) != 0 {
+		t.Errorf("Blocks counted usage %v (%v)", usage, err)
+	}
+}
@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/jeffprestes/sjrpc/apikey"
+	"github.com/jeffprestes/sjrpc/config"
+	"github.com/labstack/echo/v4"
+)
+
+// ParamKeyName is the route path parameter of the admin endpoints holding an
+// API key name.
+const ParamKeyName = "name"
+
+// createdKey is the response to a key creation, the only one holding the
+// key itself.
+type createdKey struct {
+	apikey.Key
+	Secret string `json:"key"`
+}
+
+// keyRequest is the body of a key creation: the settings clients choose.
+// The source, hash and creation time are set by the server.
+type keyRequest struct {
+	Name      string            `json:"name"`
+	RateLimit *config.RateLimit `json:"rateLimit"`
+	Methods   config.Firewall   `json:"methods"`
+	Chains    []string          `json:"chains"`
+	Admin     bool              `json:"admin"`
+}
+
+// KeyCreateHandler creates an API key with the name, rate limit, methods,
+// chains and admin flag of the request body.
+func KeyCreateHandler(echoCtx echo.Context) error {
+	var request keyRequest
+	decoder := json.NewDecoder(echoCtx.Request().Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&request)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid API key: %v", err))
+	}
+	created, secret, err := apikey.Create(apikey.Key{
+		Name:      request.Name,
+		RateLimit: request.RateLimit,
+		Methods:   request.Methods,
+		Chains:    request.Chains,
+		Admin:     request.Admin,
+	})
+	if err == apikey.ErrExists {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return echoCtx.JSON(http.StatusCreated, createdKey{Key: created, Secret: secret})
+}
+
+// KeyListHandler returns every API key, without the keys themselves.
+func KeyListHandler(echoCtx echo.Context) error {
+	return echoCtx.JSON(http.StatusOK, apikey.List())
+}
+
+// KeyRevokeHandler removes an API key created through KeyCreateHandler.
+func KeyRevokeHandler(echoCtx echo.Context) error {
+	err := apikey.Revoke(echoCtx.Param(ParamKeyName))
+	switch err {
+	case nil:
+		return echoCtx.NoContent(http.StatusNoContent)
+	case apikey.ErrNotFound:
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case apikey.ErrConfigKey:
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	return err
+}
+
+// KeyUsageHandler returns the calls of an API key by day and method.
+func KeyUsageHandler(echoCtx echo.Context) error {
+	name := echoCtx.Param(ParamKeyName)
+	usage, err := apikey.UsageOf(name)
+	if err != nil {
+		return err
+	}
+	return echoCtx.JSON(http.StatusOK, usage)
+}
@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jeffprestes/sjrpc/apikey"
+	"github.com/labstack/echo/v4"
+)
+
+// useTestKeys replaces the stored keys with the ones of an empty database.
+func useTestKeys(t *testing.T) {
+	useTestDB(t)
+	if err := apikey.Load(); err != nil {
+		t.Fatalf("cannot load keys: %v", err)
+	}
+}
+
+func createKey(body string) (recorder *httptest.ResponseRecorder, err error) {
+	request := httptest.NewRequest(http.MethodPost, "/admin/keys", strings.NewReader(body))
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	recorder = httptest.NewRecorder()
+	err = KeyCreateHandler(echo.New().NewContext(request, recorder))
+	return
+}
+
+func TestKeyCreate(t *testing.T) {
+	useTestKeys(t)
+	recorder, err := createKey(`{"name": "dashboard", "methods": {"allow": ["eth_*"]}}`)
+	if err != nil || recorder.Code != http.StatusCreated {
+		t.Fatalf("create failed: %v %d %s", err, recorder.Code, recorder.Body)
+	}
+	var created createdKey
+	json.Unmarshal(recorder.Body.Bytes(), &created)
+	if len(created.Secret) < 1 || created.Source != apikey.SourceDatabase || created.Created == nil {
+		t.Errorf("created key %+v", created)
+	}
+	key, ok := apikey.Find(created.Secret)
+	if !ok || key.Name != "dashboard" || !key.Methods.Allows("eth_call") || key.Methods.Allows("debug_traceTransaction") {
+		t.Errorf("stored key %+v", key)
+	}
+}
+
+func TestKeyCreateServerFields(t *testing.T) {
+	useTestKeys(t)
+	for _, body := range []string{
+		`{"name": "forged", "source": "config"}`,
+		`{"name": "forged", "created": "2020-01-01T00:00:00Z"}`,
+		`{"name": "forged", "hash": "00"}`,
+	} {
+		_, err := createKey(body)
+		httpErr, ok := err.(*echo.HTTPError)
+		if !ok || httpErr.Code != http.StatusBadRequest {
+			t.Errorf("%s: error %v, want bad request", body, err)
+		}
+	}
+	if _, ok := apikey.ByName("forged"); ok {
+		t.Error("key with server fields created")
+	}
+}
@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+
+	"github.com/jeffprestes/sjrpc/config"
+	"github.com/jeffprestes/sjrpc/model"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+var (
+	corsMutex sync.Mutex
+	corsUsed  config.CORS
+	corsFunc  echo.MiddlewareFunc
+)
+
+// CORSMiddleware answers the browser CORS checks with the origins and headers
+// of the configuration in use. The CORS middleware is rebuilt when they
+// change.
+func CORSMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(echoCtx echo.Context) error {
+		return corsMiddleware(config.Get().CORS)(next)(echoCtx)
+	}
+}
+
+func corsMiddleware(cors config.CORS) echo.MiddlewareFunc {
+	corsMutex.Lock()
+	defer corsMutex.Unlock()
+	if corsFunc == nil || !slices.Equal(cors.AllowOrigins, corsUsed.AllowOrigins) || !slices.Equal(cors.AllowHeaders, corsUsed.AllowHeaders) {
+		corsFunc = middleware.CORSWithConfig(middleware.CORSConfig{
+			AllowOrigins:  cors.AllowOrigins,
+			AllowHeaders:  cors.AllowHeaders,
+			ExposeHeaders: []string{HeaderCache, HeaderTier, HeaderUpstream, HeaderAge, HeaderCacheSummary},
+		})
+		corsUsed = cors
+	}
+	return corsFunc
+}
+
+// readBody reads a request body of up to the maximum body size. tooLarge
+// reports if the body is larger, in which case it is not read entirely.
+func readBody(body io.Reader) (data []byte, tooLarge bool, err error) {
+	maxSize := config.Get().Limits.MaxBodySize
+	if maxSize < 1 {
+		data, err = io.ReadAll(body)
+		return
+	}
+	data, err = io.ReadAll(io.LimitReader(body, maxSize+1))
+	tooLarge = int64(len(data)) > maxSize
+	return
+}
+
+// bodyTooLarge returns the error response to a body larger than the maximum
+// body size.
+func bodyTooLarge() string {
+	return model.NewRawIDErrorResponse(nil, model.ErrCodeInvalidRequest, fmt.Sprintf("request larger than %d bytes", config.Get().Limits.MaxBodySize))
+}
+
+// checkLimits returns the error response to a request or batch exceeding the
+// limits of the configuration, which is not processed. The response has the
+// id of a single request, and a null id for batches.
+func checkLimits(body []byte) (resp string, exceeded bool) {
+	limits := config.Get().Limits
+	body = bytes.TrimSpace(body)
+	isBatch := len(body) > 0 && body[0] == '['
+	fail := func(message string, args ...any) (string, bool) {
+		var id json.RawMessage
+		if !isBatch {
+			id = rawRequestID(body)
+		}
+		return model.NewRawIDErrorResponse(id, model.ErrCodeInvalidRequest, fmt.Sprintf(message, args...)), true
+	}
+	if limits.MaxJSONDepth > 0 {
+		maxDepth := limits.MaxJSONDepth
+		if isBatch {
+			maxDepth++
+		}
+		if jsonDepth(body) > maxDepth {
+			return fail("request nested deeper than %d levels", limits.MaxJSONDepth)
+		}
+	}
+	if !isBatch {
+		if limits.MaxRequestSize > 0 && len(body) > limits.MaxRequestSize {
+			return fail("request larger than %d bytes", limits.MaxRequestSize)
+		}
+		return
+	}
+	var items []json.RawMessage
+	if json.Unmarshal(body, &items) != nil {
+		// reported when the batch is decoded
+		return
+	}
+	if limits.MaxBatchLength > 0 && len(items) > limits.MaxBatchLength {
+		return fail("batch of %d requests, the limit is %d", len(items), limits.MaxBatchLength)
+	}
+	for _, item := range items {
+		if limits.MaxRequestSize > 0 && len(item) > limits.MaxRequestSize {
+			return fail("request larger than %d bytes", limits.MaxRequestSize)
+		}
+	}
+	return
+}
+
+// rawRequestID returns the id of the request in body when it is a number or
+// a string, and nil otherwise.
+func rawRequestID(body []byte) (id json.RawMessage) {
+	var request struct {
+		ID json.RawMessage `json:"id"`
+	}
+	if json.Unmarshal(body, &request) != nil || len(request.ID) < 1 {
+		return
+	}
+	switch c := request.ID[0]; {
+	case c == '"', c == '-', c >= '0' && c <= '9':
+		id = request.ID
+	}
+	return
+}
+
+// jsonDepth returns the deepest nesting of objects and arrays in data. It
+// does not validate data.
+func jsonDepth(data []byte) (maxDepth int) {
+	depth := 0
+	inString := false
+	escaped := false
+	for _, c := range data {
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+			if depth > maxDepth {
+				maxDepth = depth
+			}
+		case '}', ']':
+			depth--
+		}
+	}
+	return
+}
@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/jeffprestes/sjrpc/config"
+	"github.com/jeffprestes/sjrpc/model"
+	"github.com/labstack/echo/v4"
+)
+
+func TestJSONDepth(t *testing.T) {
+	tests := map[string]int{
+		`1`:                            0,
+		`{}`:                           1,
+		`{"a":[1,{"b":2}]}`:            3,
+		`[{"a":1},{"b":[[]]}]`:         4,
+		`{"a":"[[[{{{"}`:               1,
+		`{"a":"\"[[[","b":["\\"]}`:     2,
+		`{"a":"\\\"{{{"}`:              1,
+		`[[[[[[[[[[`:                   10,
+		`{"params":[{"topics":[[]]}]}`: 5,
+	}
+	for data, want := range tests {
+		if got := jsonDepth([]byte(data)); got != want {
+			t.Errorf("jsonDepth(%s) = %d, want %d", data, got, want)
+		}
+	}
+}
+
+// responseID returns the raw id of the JSON-RPC error response resp.
+func responseID(t *testing.T, resp string) string {
+	var respObj struct {
+		ID    json.RawMessage `json:"id"`
+		Error *struct {
+			Code int `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(resp), &respObj); err != nil || respObj.Error == nil {
+		t.Fatalf("invalid error response %s: %v", resp, err)
+	}
+	return string(respObj.ID)
+}
+
+func TestCheckLimits(t *testing.T) {
+	cfg := config.Default()
+	cfg.Limits = config.Limits{MaxBatchLength: 2, MaxRequestSize: 100, MaxJSONDepth: 3}
+	config.Set(cfg)
+	defer config.Set(config.Default())
+
+	deep := `{"jsonrpc":"2.0","id":7,"method":"eth_call","params":[{"a":[{}]}]}`
+	large := `{"jsonrpc":"2.0","id":"abc","method":"eth_call","params":["` + strings.Repeat("0", 100) + `"]}`
+	tests := []struct {
+		body     string
+		exceeded bool
+		id       string
+	}{
+		{`{"jsonrpc":"2.0","id":1,"method":"eth_call","params":[{"to":"0x0"}]}`, false, ""},
+		{`[{"jsonrpc":"2.0","id":1,"method":"eth_call","params":[{}]}]`, false, ""},
+		{deep, true, "7"},
+		{large, true, `"abc"`},
+		{`{"jsonrpc":"2.0","id":{"a":1},"method":"eth_call","params":[{"a":[{}]}]}`, true, "null"},
+		{`{"jsonrpc":"2.0","method":"eth_call","params":[{"a":[{}]}]}`, true, "null"},
+		{`[` + deep + `]`, true, "null"},
+		{`[` + large + `]`, true, "null"},
+		{`[{"id":1},{"id":2},{"id":3}]`, true, "null"},
+	}
+	for _, test := range tests {
+		resp, exceeded := checkLimits([]byte(test.body))
+		if exceeded != test.exceeded {
+			t.Errorf("%s: exceeded = %v, want %v", test.body, exceeded, test.exceeded)
+			continue
+		}
+		if exceeded {
+			if id := responseID(t, resp); id != test.id {
+				t.Errorf("%s: response id %s, want %s", test.body, id, test.id)
+			}
+		}
+	}
+}
+
+func TestBodyTooLarge(t *testing.T) {
+	if id := responseID(t, bodyTooLarge()); id != "null" {
+		t.Errorf("response id %s, want null", id)
+	}
+}
+
+func TestPostParseError(t *testing.T) {
+	upstream := newFakeUpstream(t, 100)
+	upstream.follow(t)
+
+	request := httptest.NewRequest(http.MethodPost, "/?rpcUrl="+url.QueryEscape(upstream.URL), strings.NewReader(`{"jsonrpc":`))
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	recorder := httptest.NewRecorder()
+	echoCtx := echo.New().NewContext(request, recorder)
+	echoCtx.SetPath("/")
+	if err := PostHandler(echoCtx); err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	if recorder.Code != http.StatusOK {
+		t.Errorf("status %d, want 200", recorder.Code)
+	}
+	var resp model.RPCResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &resp); err != nil || resp.Error == nil || resp.Error.Code != model.ErrCodeParse {
+		t.Fatalf("response %s, want a parse error: %v", recorder.Body, err)
+	}
+	if id := responseID(t, recorder.Body.String()); id != "null" {
+		t.Errorf("response id %s, want null", id)
+	}
+}
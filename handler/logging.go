@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/jeffprestes/sjrpc/logging"
+	"github.com/jeffprestes/sjrpc/model"
+	"github.com/labstack/echo/v4"
+)
+
+// LogMiddleware gives every request a logger with its id, set in the
+// X-Request-Id header by the RequestID middleware, and logs the request once
+// answered. API keys in the URI are redacted.
+func LogMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(echoCtx echo.Context) (err error) {
+		start := time.Now()
+		req := echoCtx.Request()
+		ctx := logging.With(req.Context(), "request_id", echoCtx.Response().Header().Get(echo.HeaderXRequestID))
+		echoCtx.SetRequest(req.WithContext(ctx))
+		err = next(echoCtx)
+		if err != nil {
+			// answers the error now so its status is logged
+			echoCtx.Error(err)
+		}
+		status := echoCtx.Response().Status
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		attrs := []slog.Attr{
+			slog.String("method", req.Method),
+			slog.String("uri", logging.RedactURL(req.RequestURI)),
+			slog.Int("status", status),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("remote_ip", echoCtx.RealIP()),
+			slog.Int64("bytes_out", echoCtx.Response().Size),
+		}
+		if err != nil {
+			attrs = append(attrs, slog.Any("error", err))
+		}
+		logging.FromContext(ctx).LogAttrs(ctx, level, "http request", attrs...)
+		return
+	}
+}
+
+// logRequest logs a JSON-RPC request answered by ProcessRequest started at
+// start: at debug level with its params and response, or as a warning when
+// it failed.
+func logRequest(ctx context.Context, request *model.RPCRequest, chainId *int, tier string, resp string, start time.Time, err error) {
+	logger := logging.FromContext(ctx)
+	level := slog.LevelDebug
+	if err != nil {
+		level = slog.LevelWarn
+	}
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.String("rpc_method", request.Method),
+		slog.String("chain", chainLabel(chainId)),
+		slog.String("tier", tier),
+		slog.Duration("duration", time.Since(start)),
+	}
+	if status, ok := ctx.Value(cacheStatusKey{}).(*CacheStatus); ok {
+		attrs = append(attrs, slog.String("cache", status.Cache))
+		if len(status.Upstream) > 0 {
+			attrs = append(attrs, slog.String("upstream", status.Upstream), slog.Duration("upstream_latency", status.latency))
+		}
+	}
+	if err != nil {
+		attrs = append(attrs, slog.Any("error", err))
+	}
+	// bodies can be large and hold private data, they are only logged at
+	// debug level
+	if logger.Enabled(ctx, slog.LevelDebug) {
+		attrs = append(attrs, slog.Any("id", request.ID), slog.Any("params", request.Params), slog.String("response", RestoreOriginalId(request, resp)))
+	}
+	logger.LogAttrs(ctx, level, "rpc request", attrs...)
+}
@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"context"
+	"time"
+
+	"github.com/jeffprestes/sjrpc/logging"
+	"github.com/jeffprestes/sjrpc/logstore"
+	"github.com/jeffprestes/sjrpc/model"
+	"github.com/jeffprestes/sjrpc/upstream"
+)
+
+// ProcessLogsRequest answers eth_getLogs from the log store. Queries by block
+// hash, which are not indexed by range, use the timely cache.
+func ProcessLogsRequest(ctx context.Context, request *model.RPCRequest, chainId *int, rpcUrl string) (resp string, cacheUsed bool, err error) {
+	query, errQuery := filterQueryParam(request)
+	if errQuery != nil || len(query.BlockHash) > 0 {
+		resp, cacheUsed, err = processTimelyRequest(ctx, request, chainId, rpcUrl)
+		return
+	}
+
+	headTracker := HeadTracker(rpcUrl)
+	latest, err := headTracker.Latest(ctx)
+	if err != nil {
+		return
+	}
+	finalized, err := headTracker.Finalized(ctx)
+	if err != nil {
+		return
+	}
+	blocks := logstore.Blocks{Latest: latest.Number, Finalized: finalized}
+	start := time.Now()
+	logs, fetched, rpcErr, err := logstore.GetLogs(ctx, upstream.For(rpcUrl), chainId, query, blocks, model.GetCachePolicy().LogsChunkSize)
+	if err != nil {
+		return
+	}
+	cacheUsed = !fetched
+	if fetched {
+		noteUpstreamLatency(ctx, time.Since(start))
+	}
+
+	var respObj model.RPCResponse
+	if rpcErr != nil {
+		respObj = model.NewErrorResponse(request.ID, rpcErr.Code, rpcErr.Message)
+		respObj.Error.Data = rpcErr.Data
+	} else {
+		respObj = model.NewResultResponse(request.ID, logs)
+	}
+	respObj.Jsonrpc = request.JsonRpcVersion
+	resp = respObj.ToString()
+	logging.FromContext(ctx).Debug("eth_getLogs answered", "logs", len(logs), "latest", latest.Number, "finalized", finalized, "cache_used", cacheUsed)
+	return
+}
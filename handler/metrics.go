@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"sync"
+
+	"github.com/jeffprestes/sjrpc/config"
+	"github.com/jeffprestes/sjrpc/database"
+	"github.com/jeffprestes/sjrpc/localcache"
+	"github.com/jeffprestes/sjrpc/tracing"
+	"github.com/jeffprestes/sjrpc/tracker"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Most method names used as metric labels. Methods outside the cache policy
+// are counted as otherMethod once there are as many, so clients cannot grow
+// the metrics without bounds.
+const (
+	maxMethodLabels = 256
+	otherMethod     = "other"
+)
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sjrpc_requests_total",
+		Help: "JSON-RPC requests answered by chain, method and cache tier.",
+	}, []string{"chain", "method", "tier"})
+	requestErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sjrpc_request_errors_total",
+		Help: "JSON-RPC requests that failed by chain and cache tier.",
+	}, []string{"chain", "tier"})
+	cacheHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sjrpc_cache_hits_total",
+		Help: "Requests answered from the cache by chain and cache tier.",
+	}, []string{"chain", "tier"})
+	cacheMisses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sjrpc_cache_misses_total",
+		Help: "Requests of a cache tier answered by upstream by chain and cache tier.",
+	}, []string{"chain", "tier"})
+	coalescedCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sjrpc_upstream_coalesced_total",
+		Help: "Upstream calls saved by sharing the response of an identical call in progress, by provider.",
+	}, []string{"provider"})
+
+	methodLabelsMutex sync.Mutex
+	methodLabels      = make(map[string]bool)
+
+	metricsHandler = echo.WrapHandler(promhttp.Handler())
+)
+
+func init() {
+	databaseSize := func(kind string, size func(lsm, vlog int64) int64) {
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "sjrpc_database_size_bytes",
+			Help:        "Size of the cache database files by kind: lsm or vlog.",
+			ConstLabels: prometheus.Labels{"kind": kind},
+		}, func() float64 {
+			if database.DB == nil {
+				return 0
+			}
+			return float64(size(database.DB.Size()))
+		})
+	}
+	databaseSize("lsm", func(lsm, _ int64) int64 { return lsm })
+	databaseSize("vlog", func(_, vlog int64) int64 { return vlog })
+
+	gcRuns := func(result string, runs func(gc database.GCMetrics) uint64) {
+		promauto.NewCounterFunc(prometheus.CounterOpts{
+			Name:        "sjrpc_database_gc_runs_total",
+			Help:        "Value log garbage collections of the cache database by result: rewrite, none or failure.",
+			ConstLabels: prometheus.Labels{"result": result},
+		}, func() float64 {
+			return float64(runs(database.GCStats()))
+		})
+	}
+	gcRuns("rewrite", func(gc database.GCMetrics) uint64 { return gc.Rewrites })
+	gcRuns("none", func(gc database.GCMetrics) uint64 { return gc.Runs - gc.Rewrites - gc.Failures })
+	gcRuns("failure", func(gc database.GCMetrics) uint64 { return gc.Failures })
+
+	caches := map[string]*sync.Map{
+		"timely":      &localcache.TimelyRequests,
+		"unfinalized": &localcache.UnfinalizedRequests,
+		"filters":     &localcache.Filters,
+		"pending":     &localcache.PendingTransactions,
+	}
+	for name, cache := range caches {
+		cache := cache
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "sjrpc_localcache_entries",
+			Help:        "Entries of the in-memory caches.",
+			ConstLabels: prometheus.Labels{"cache": name},
+		}, func() float64 {
+			count := 0
+			cache.Range(func(_, _ any) bool {
+				count++
+				return true
+			})
+			return float64(count)
+		})
+	}
+
+	promauto.NewCounterFunc(prometheus.CounterOpts{Name: "sjrpc_reorgs_total", Help: "Reorgs seen by the head trackers."}, func() float64 {
+		return float64(tracker.Metrics().Reorgs)
+	})
+	promauto.NewCounterFunc(prometheus.CounterOpts{Name: "sjrpc_orphaned_blocks_total", Help: "Blocks replaced by reorgs."}, func() float64 {
+		return float64(tracker.Metrics().OrphanedBlocks)
+	})
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{Name: "sjrpc_deepest_reorg_blocks", Help: "Depth of the deepest reorg seen."}, func() float64 {
+		return float64(tracker.Metrics().DeepestReorg)
+	})
+	promauto.NewCounterFunc(prometheus.CounterOpts{Name: "sjrpc_invalidated_entries_total", Help: "Cache entries removed by reorgs."}, func() float64 {
+		return float64(InvalidatedEntries())
+	})
+	promauto.NewCounterFunc(prometheus.CounterOpts{Name: "sjrpc_prefetched_receipts_total", Help: "Receipts cached ahead of the requests for them."}, func() float64 {
+		return float64(PrefetchedReceipts())
+	})
+	promauto.NewCounterFunc(prometheus.CounterOpts{Name: "sjrpc_tracing_dropped_spans_total", Help: "Spans not exported because the export failed."}, func() float64 {
+		return float64(tracing.Dropped())
+	})
+}
+
+// MetricsHandler writes the metrics in the Prometheus text format.
+func MetricsHandler(echoCtx echo.Context) error {
+	return metricsHandler(echoCtx)
+}
+
+// countRequest records a request answered from the cache tier given.
+func countRequest(method string, chainId *int, tier string, cacheUsed bool, err error) {
+	chain := chainLabel(chainId)
+	requestsTotal.WithLabelValues(chain, methodLabel(method, tier), tier).Inc()
+	if err != nil {
+		requestErrors.WithLabelValues(chain, tier).Inc()
+		return
+	}
+	switch tier {
+	case tierFilter, tierTransaction, tierNone, tierBlocked:
+		return
+	}
+	if cacheUsed {
+		cacheHits.WithLabelValues(chain, tier).Inc()
+	} else {
+		cacheMisses.WithLabelValues(chain, tier).Inc()
+	}
+}
+
+// chainLabel names the chain of a request by its configured name. Requests
+// without chainId are on the default upstream and other chains are counted
+// together, as clients choose their chainId.
+func chainLabel(chainId *int) string {
+	if chainId == nil {
+		return "default"
+	}
+	chain, ok := config.Get().ChainByID(*chainId)
+	if ok {
+		return chain.Name
+	}
+	return "other"
+}
+
+func methodLabel(method, tier string) string {
+	if tier != tierNone && tier != tierBlocked {
+		return method
+	}
+	methodLabelsMutex.Lock()
+	defer methodLabelsMutex.Unlock()
+	if methodLabels[method] {
+		return method
+	}
+	if len(methodLabels) >= maxMethodLabels || len(method) > 64 {
+		return otherMethod
+	}
+	methodLabels[method] = true
+	return method
+}
@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jeffprestes/sjrpc/blockstore"
+	"github.com/jeffprestes/sjrpc/database"
+	"github.com/jeffprestes/sjrpc/model"
+)
+
+const (
+	// Most receipt prefetches running at once. Blocks cached while every
+	// slot is busy are not prefetched.
+	maxPrefetches = 4
+
+	// Timeout of a receipt prefetch.
+	prefetchTimeout = 30 * time.Second
+)
+
+var (
+	// blocks whose receipts are being prefetched
+	prefetching   sync.Map
+	prefetchSlots = make(chan struct{}, maxPrefetches)
+
+	prefetchedReceipts atomic.Uint64
+)
+
+// PrefetchedReceipts returns the number of receipts cached by prefetching.
+func PrefetchedReceipts() uint64 {
+	return prefetchedReceipts.Load()
+}
+
+// prefetchReceipts caches the receipts of a finalized block in background when
+// enabled by the cache policy, so the receipt requests that usually follow a
+// block request are answered from cache.
+func prefetchReceipts(ctx context.Context, chainId *int, rpcUrl string, block *blockstore.Block) {
+	if !model.GetCachePolicy().PrefetchReceipts {
+		return
+	}
+	hashes := block.TransactionHashes()
+	if len(hashes) < 1 {
+		return
+	}
+	finalized, err := HeadTracker(rpcUrl).Finalized(ctx)
+	if err != nil || block.Number > finalized {
+		return
+	}
+	// receipts are cached a whole block at a time, by a prefetch or a warm job
+	if receiptCached(chainId, hashes[0]) {
+		return
+	}
+	key := string(blockstore.HashKey(chainId, block.Hash))
+	_, running := prefetching.LoadOrStore(key, true)
+	if running {
+		return
+	}
+	select {
+	case prefetchSlots <- struct{}{}:
+	default:
+		prefetching.Delete(key)
+		return
+	}
+
+	go func() {
+		defer func() {
+			<-prefetchSlots
+			prefetching.Delete(key)
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), prefetchTimeout)
+		defer cancel()
+		stored, err := StoreBlockReceipts(ctx, chainId, rpcUrl, block.Number)
+		if err != nil {
+			slog.Warn("error prefetching receipts", "upstream", upstreamHost(rpcUrl), "block", block.Number, "error", err)
+			return
+		}
+		prefetchedReceipts.Add(uint64(stored))
+	}()
+}
+
+// receiptCached reports whether the receipt of the transaction is in the
+// database.
+func receiptCached(chainId *int, txHash string) bool {
+	var request model.RPCRequest
+	request.JsonRpcVersion = "2.0"
+	request.Method = "eth_getTransactionReceipt"
+	request.ID = 1
+	request.Params = append(request.Params, txHash)
+	ok, err := database.DB.Has(database.RequestNamespace, request.Hash(chainId))
+	return err == nil && ok
+}
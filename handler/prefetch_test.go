@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jeffprestes/sjrpc/blockstore"
+	"github.com/jeffprestes/sjrpc/model"
+)
+
+// usePrefetch enables receipt prefetching for the test.
+func usePrefetch(t *testing.T) {
+	previous := model.GetCachePolicy()
+	policy := *previous
+	policy.PrefetchReceipts = true
+	model.SetCachePolicy(&policy)
+	t.Cleanup(func() { model.SetCachePolicy(previous) })
+}
+
+// waitPrefetches waits for the receipt prefetches running to end.
+func waitPrefetches(t *testing.T) {
+	deadline := time.Now().Add(5 * time.Second)
+	for len(prefetchSlots) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("receipt prefetch still running")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// fetchBlock requests block number with its transactions.
+func fetchBlock(t *testing.T, upstream *fakeUpstream, chainId *int, number uint64) (resp string) {
+	request := &model.RPCRequest{JsonRpcVersion: "2.0", ID: 1, Method: "eth_getBlockByNumber", Params: []any{fmt.Sprintf("0x%x", number), true}}
+	resp, _, err := ProcessBlockRequest(context.Background(), request, chainId, upstream.URL)
+	if err != nil {
+		t.Fatalf("block %d: %v", number, err)
+	}
+	return
+}
+
+func TestPrefetchReceipts(t *testing.T) {
+	useTestDB(t)
+	usePrefetch(t)
+	upstream := newFakeUpstream(t, 100)
+	chainId := 1
+	hashes := []string{fmt.Sprintf("0x%064x", 0xa1), fmt.Sprintf("0x%064x", 0xa2)}
+	upstream.transactions[80] = hashes
+	var receipts []map[string]string
+	for _, hash := range hashes {
+		receipts = append(receipts, map[string]string{"transactionHash": hash, "blockNumber": "0x50", "blockHash": fmt.Sprintf("0x%064x", 80)})
+	}
+	upstream.results["eth_getBlockReceipts"] = receipts
+
+	before := PrefetchedReceipts()
+	fetchBlock(t, upstream, &chainId, 80)
+	waitPrefetches(t)
+
+	if calls := upstream.calls("eth_getBlockReceipts"); len(calls) != 1 || calls[0].Params[0] != "0x50" {
+		t.Fatalf("receipt requests %+v", calls)
+	}
+	for _, hash := range hashes {
+		if !receiptCached(&chainId, hash) {
+			t.Errorf("receipt of %s not stored", hash)
+		}
+	}
+	if prefetched := PrefetchedReceipts() - before; prefetched != 2 {
+		t.Errorf("%d receipts prefetched, want 2", prefetched)
+	}
+}
+
+func TestPrefetchFailureKeepsBlock(t *testing.T) {
+	useTestDB(t)
+	usePrefetch(t)
+	upstream := newFakeUpstream(t, 100)
+	chainId := 1
+	hash := fmt.Sprintf("0x%064x", 0xb1)
+	upstream.transactions[80] = []string{hash}
+	upstream.results["eth_getBlockReceipts"] = nil
+
+	resp := fetchBlock(t, upstream, &chainId, 80)
+	waitPrefetches(t)
+
+	if len(upstream.calls("eth_getBlockReceipts")) != 1 {
+		t.Fatal("receipts not prefetched")
+	}
+	if model.IsResultNull(resp) || receiptCached(&chainId, hash) {
+		t.Errorf("block response %s, receipt cached %v", resp, receiptCached(&chainId, hash))
+	}
+	block, ok, err := blockstore.ByNumber(context.Background(), &chainId, 80)
+	if err != nil || !ok || len(block.Transactions) != 1 {
+		t.Errorf("block not stored after the prefetch failed: %v, %v", ok, err)
+	}
+}
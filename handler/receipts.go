@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jeffprestes/sjrpc/model"
+)
+
+// StoreBlockReceipts fetches eth_getBlockReceipts for the block and caches the
+// receipt of each transaction as its eth_getTransactionReceipt response. It
+// returns the number of receipts cached.
+func StoreBlockReceipts(ctx context.Context, chainId *int, rpcUrl string, number uint64) (stored int, err error) {
+	var request model.RPCRequest
+	request.JsonRpcVersion = "2.0"
+	request.Method = "eth_getBlockReceipts"
+	request.ID = 1
+	request.Params = append(request.Params, fmt.Sprintf("0x%x", number))
+	resp, err := PerformRemoteCall(ctx, &request, rpcUrl)
+	if err != nil {
+		return
+	}
+	var respObj model.RPCResponse
+	err = json.Unmarshal([]byte(resp), &respObj)
+	if err != nil {
+		return
+	}
+	if respObj.Error != nil {
+		err = fmt.Errorf("eth_getBlockReceipts of block %d: %s", number, respObj.Error.Message)
+		return
+	}
+	if model.IsNullResult(respObj.Result) {
+		err = fmt.Errorf("receipts of block %d are not available", number)
+		return
+	}
+	var receipts []json.RawMessage
+	err = json.Unmarshal(respObj.Result, &receipts)
+	if err != nil {
+		return
+	}
+	finalized, err := HeadTracker(rpcUrl).Finalized(ctx)
+	if err != nil {
+		return
+	}
+
+	for _, receipt := range receipts {
+		var fields struct {
+			TransactionHash string `json:"transactionHash"`
+		}
+		err = json.Unmarshal(receipt, &fields)
+		if err != nil {
+			return
+		}
+		var receiptRequest model.RPCRequest
+		receiptRequest.JsonRpcVersion = "2.0"
+		receiptRequest.Method = "eth_getTransactionReceipt"
+		receiptRequest.ID = 1
+		receiptRequest.Params = append(receiptRequest.Params, fields.TransactionHash)
+		receiptResp := model.NewResultResponse(receiptRequest.ID, receipt)
+		tmp := receiptResp.ToString()
+		block, ok := receiptRequest.ResultBlock(tmp)
+		if !ok {
+			continue
+		}
+		cacheAfterFinal(ctx, &receiptRequest, chainId, rpcUrl, tmp, block, finalized)
+		stored++
+	}
+	return
+}
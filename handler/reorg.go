@@ -0,0 +1,273 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jeffprestes/sjrpc/database"
+	"github.com/jeffprestes/sjrpc/localcache"
+	"github.com/jeffprestes/sjrpc/logging"
+	"github.com/jeffprestes/sjrpc/model"
+	"github.com/jeffprestes/sjrpc/tracker"
+	"golang.org/x/crypto/blake2b"
+)
+
+// ReorgEvent is the record of a reorg kept in the database.
+type ReorgEvent struct {
+	Upstream    string            `json:"upstream"`
+	Fork        uint64            `json:"fork"`
+	Depth       uint64            `json:"depth"`
+	OldHead     string            `json:"oldHead"`
+	NewHead     string            `json:"newHead"`
+	Orphaned    map[uint64]string `json:"orphaned"`
+	Invalidated int               `json:"invalidated"`
+	When        time.Time         `json:"when"`
+}
+
+var (
+	// trackers watched for reorgs
+	watchedTrackers sync.Map
+	// settling holds the upstreams whose finalized entries are being
+	// settled
+	settling sync.Map
+
+	invalidatedEntries atomic.Uint64
+
+	// lastKey sorts after every namespace in block references
+	lastKey = []byte{0xff}
+)
+
+// InvalidatedEntries returns the number of cache entries removed because
+// their block was reorged out.
+func InvalidatedEntries() uint64 {
+	return invalidatedEntries.Load()
+}
+
+// watchReorgs makes the cache follow the reorgs seen by the tracker.
+func watchReorgs(headTracker *tracker.Tracker) {
+	_, loaded := watchedTrackers.LoadOrStore(headTracker, true)
+	if loaded {
+		return
+	}
+	rpcUrl := headTracker.URL()
+	headTracker.OnReorg(func(event tracker.Reorg) {
+		rollbackCache(rpcUrl, event)
+	})
+	headTracker.OnHead(func(tracker.Head) {
+		go settleFinalized(headTracker)
+	})
+}
+
+// trackCachedBlock links the cached response of the request to its block
+// when the block is not final yet, so it can be removed if the block is
+// reorged out.
+func trackCachedBlock(ctx context.Context, request *model.RPCRequest, chainId *int, rpcUrl string, resp string) {
+	number, hash, ok := blockOfResponse(request, resp)
+	if !ok {
+		return
+	}
+	linkToBlock(ctx, rpcUrl, database.RequestNamespace, request.Hash(chainId), number, hash)
+}
+
+// linkToBlock links the entry key of the namespace to the block when the
+// block is not final yet, so the entry is removed if the block is reorged
+// out. When the head of rpcUrl is not followed, reorgs would be missed and
+// the entry is removed instead.
+func linkToBlock(ctx context.Context, rpcUrl string, namespace, key []byte, number uint64, hash string) {
+	headTracker := HeadTracker(rpcUrl)
+	finalized, err := headTracker.Finalized(ctx)
+	if err != nil {
+		logging.FromContext(ctx).Warn("error getting the finalized block", "upstream", upstreamHost(rpcUrl), "error", err)
+	} else if number <= finalized {
+		return
+	}
+	if !followsHead(rpcUrl) {
+		err = database.Traced(ctx).Delete(namespace, key)
+		if err != nil {
+			logging.FromContext(ctx).Error("error removing cache entry of a block not final", "block", number, "error", err)
+		}
+		return
+	}
+	headTracker.Observe(number, hash)
+	err = database.Traced(ctx).Update(database.BlockRefsNamespace, blockRefKey(rpcUrl, number, namespace, key), []byte(hash))
+	if err != nil {
+		logging.FromContext(ctx).Error("error linking cache entry to block", "block", number, "error", err)
+	}
+}
+
+// blockOfResponse returns the block a response depends on. Responses to
+// requests by block hash are not affected by reorgs: the block with that hash
+// does not change.
+func blockOfResponse(request *model.RPCRequest, resp string) (number uint64, hash string, ok bool) {
+	switch request.Method {
+	case "eth_getBlockByHash", "eth_getBlockTransactionCountByHash", "eth_getTransactionByBlockHashAndIndex":
+		return
+	}
+	var respObj model.RPCResponse
+	err := json.Unmarshal([]byte(resp), &respObj)
+	if err != nil || respObj.Error != nil {
+		return
+	}
+	var result struct {
+		Number      string `json:"number"`
+		Hash        string `json:"hash"`
+		BlockNumber string `json:"blockNumber"`
+		BlockHash   string `json:"blockHash"`
+	}
+	if json.Unmarshal(respObj.Result, &result) == nil {
+		if len(result.BlockNumber) > 0 {
+			number, err = tracker.ParseHexUint64(result.BlockNumber)
+			hash = result.BlockHash
+			ok = err == nil
+			return
+		}
+		if len(result.Number) > 0 {
+			number, err = tracker.ParseHexUint64(result.Number)
+			hash = result.Hash
+			ok = err == nil
+			return
+		}
+	}
+	// results without block fields, as transaction counts, depend on the
+	// block number requested
+	switch request.Method {
+	case "eth_getBlockByNumber", "eth_getBlockTransactionCountByNumber", "eth_getTransactionByBlockNumberAndIndex":
+		if len(request.Params) > 0 {
+			tag, isString := request.Params[0].(string)
+			if isString {
+				number, err = tracker.ParseHexUint64(tag)
+				ok = err == nil
+			}
+		}
+	}
+	return
+}
+
+// rollbackCache removes the cache entries of the blocks replaced by the
+// reorg and records it.
+func rollbackCache(rpcUrl string, event tracker.Reorg) {
+	// heads seen by polling skip blocks and entries can be linked to blocks
+	// after the old head, so every entry after the fork is removed, not only
+	// the ones of the orphaned blocks known
+	entryKeys := make(map[string][][]byte)
+	var refKeys [][]byte
+	err := database.DB.Scan(database.BlockRefsNamespace, blockRefKey(rpcUrl, event.Fork+1, nil, nil), blockRefKey(rpcUrl, math.MaxUint64, lastKey, nil), func(key, _ []byte) error {
+		namespace, entryKey, found := bytes.Cut(key[16:], []byte("/"))
+		if found {
+			entryKeys[string(namespace)] = append(entryKeys[string(namespace)], bytes.Clone(entryKey))
+		}
+		refKeys = append(refKeys, bytes.Clone(key))
+		return nil
+	})
+	if err != nil {
+		slog.Error("error looking for cache entries of reorged blocks", "error", err)
+	}
+	removed := 0
+	for namespace, keys := range entryKeys {
+		err = database.DB.DeleteMany([]byte(namespace), keys)
+		if err != nil {
+			slog.Error("error removing cache entries of reorged blocks", "error", err)
+			continue
+		}
+		removed += len(keys)
+	}
+	if len(refKeys) > 0 {
+		err = database.DB.DeleteMany(database.BlockRefsNamespace, refKeys)
+		if err != nil {
+			slog.Error("error removing links of reorged blocks", "error", err)
+		}
+	}
+	invalidated := removed + dropUnfinalized(rpcUrl, event.Fork)
+	invalidatedEntries.Add(uint64(invalidated))
+
+	// timely responses of the old chain
+	localcache.TimelyRequests.Range(func(key, value any) bool {
+		respObj, ok := value.(model.EphemeralRequest)
+		if ok && respObj.BlockNumber > event.Fork {
+			localcache.TimelyRequests.Delete(key)
+		}
+		return true
+	})
+
+	record := ReorgEvent{
+		Upstream:    upstreamHost(rpcUrl),
+		Fork:        event.Fork,
+		Depth:       event.Depth,
+		OldHead:     event.OldHead.Hash,
+		NewHead:     event.NewHead.Hash,
+		Orphaned:    event.Orphaned,
+		Invalidated: invalidated,
+		When:        event.When,
+	}
+	value, err := json.Marshal(record)
+	if err == nil {
+		key := binary.BigEndian.AppendUint64(nil, uint64(event.When.UnixNano()))
+		err = database.DB.Update(database.ReorgsNamespace, key, value)
+	}
+	if err != nil {
+		slog.Error("error recording reorg", "error", err)
+	}
+	slog.Info("reorg cache entries removed", "upstream", record.Upstream, "fork", event.Fork, "invalidated", invalidated)
+}
+
+// settleFinalized moves the responses whose block became final to the
+// database and forgets the links of cache entries whose block became final.
+func settleFinalized(headTracker *tracker.Tracker) {
+	rpcUrl := headTracker.URL()
+	_, running := settling.LoadOrStore(rpcUrl, true)
+	if running {
+		return
+	}
+	defer settling.Delete(rpcUrl)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	finalized, err := headTracker.Finalized(ctx)
+	if err != nil {
+		return
+	}
+	promoteFinalized(headTracker, finalized)
+
+	var keys [][]byte
+	err = database.DB.Scan(database.BlockRefsNamespace, blockRefKey(rpcUrl, 0, nil, nil), blockRefKey(rpcUrl, finalized, lastKey, nil), func(key, _ []byte) error {
+		keys = append(keys, bytes.Clone(key))
+		return nil
+	})
+	if err == nil && len(keys) > 0 {
+		err = database.DB.DeleteMany(database.BlockRefsNamespace, keys)
+	}
+	if err != nil {
+		slog.Error("error pruning block references", "upstream", upstreamHost(rpcUrl), "error", err)
+	}
+}
+
+// blockRefKey is the upstream, the block number, the namespace and the key
+// of the cache entry.
+func blockRefKey(rpcUrl string, number uint64, namespace, key []byte) []byte {
+	upstreamKey := blake2b.Sum256([]byte(rpcUrl))
+	refKey := append([]byte{}, upstreamKey[:8]...)
+	refKey = binary.BigEndian.AppendUint64(refKey, number)
+	if len(namespace) > 0 {
+		refKey = append(refKey, namespace...)
+		refKey = append(refKey, '/')
+	}
+	return append(refKey, key...)
+}
+
+// upstreamHost returns the host of the upstream URL, leaving out paths and
+// query strings that often hold API keys.
+func upstreamHost(rpcUrl string) string {
+	tmp, err := url.Parse(rpcUrl)
+	if err != nil || len(tmp.Host) < 1 {
+		return "upstream"
+	}
+	return tmp.Host
+}
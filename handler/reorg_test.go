@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/jeffprestes/sjrpc/config"
+	"github.com/jeffprestes/sjrpc/database"
+	"github.com/jeffprestes/sjrpc/localcache"
+	"github.com/jeffprestes/sjrpc/model"
+	"github.com/jeffprestes/sjrpc/tracker"
+)
+
+// cacheAtBlock stores a cache entry and links it to the block.
+func cacheAtBlock(t *testing.T, rpcUrl string, number uint64) (key []byte) {
+	key = []byte(fmt.Sprintf("entry%d", number))
+	err := database.DB.Update(database.RequestNamespace, key, []byte("response"))
+	if err != nil {
+		t.Fatalf("cannot store entry: %v", err)
+	}
+	linkToBlock(context.Background(), rpcUrl, database.RequestNamespace, key, number, fmt.Sprintf("0x%064x", number))
+	return
+}
+
+func isCached(t *testing.T, key []byte) bool {
+	_, err := database.DB.Get(database.RequestNamespace, key)
+	if err != nil && err != badger.ErrKeyNotFound {
+		t.Fatalf("cannot read entry: %v", err)
+	}
+	return err == nil
+}
+
+func hasBlockRef(t *testing.T, rpcUrl string, number uint64, key []byte) bool {
+	ok, err := database.DB.Has(database.BlockRefsNamespace, blockRefKey(rpcUrl, number, database.RequestNamespace, key))
+	if err != nil {
+		t.Fatalf("cannot read block reference: %v", err)
+	}
+	return ok
+}
+
+func TestLinkToBlock(t *testing.T) {
+	useTestDB(t)
+	upstream := newFakeUpstream(t, 100)
+	upstream.follow(t)
+
+	// the fake upstream finalizes 10 blocks behind the head
+	final := cacheAtBlock(t, upstream.URL, 90)
+	recent := cacheAtBlock(t, upstream.URL, 95)
+	if !isCached(t, final) || hasBlockRef(t, upstream.URL, 90, final) {
+		t.Error("entry of a final block was linked or removed")
+	}
+	if !isCached(t, recent) || !hasBlockRef(t, upstream.URL, 95, recent) {
+		t.Error("entry of a recent block was not linked")
+	}
+}
+
+func TestLinkToBlockNotFollowed(t *testing.T) {
+	useTestDB(t)
+	upstream := newFakeUpstream(t, 100)
+
+	final := cacheAtBlock(t, upstream.URL, 90)
+	recent := cacheAtBlock(t, upstream.URL, 95)
+	if !isCached(t, final) {
+		t.Error("entry of a final block removed")
+	}
+	if isCached(t, recent) || hasBlockRef(t, upstream.URL, 95, recent) {
+		t.Error("entry of a recent block kept for an upstream whose head is not followed")
+	}
+}
+
+func TestRollbackCache(t *testing.T) {
+	useTestDB(t)
+	upstream := newFakeUpstream(t, 100)
+	upstream.follow(t)
+
+	keys := make(map[uint64][]byte)
+	for number := uint64(95); number <= 99; number++ {
+		keys[number] = cacheAtBlock(t, upstream.URL, number)
+	}
+	localcache.TimelyRequests.Store("kept", model.EphemeralRequest{BlockNumber: 97})
+	localcache.TimelyRequests.Store("reorged", model.EphemeralRequest{BlockNumber: 98})
+	defer localcache.TimelyRequests.Delete("kept")
+
+	before := InvalidatedEntries()
+	rollbackCache(upstream.URL, tracker.Reorg{
+		Fork:     97,
+		Depth:    2,
+		OldHead:  tracker.Head{Number: 99},
+		Orphaned: map[uint64]string{98: "0x98", 99: "0x99"},
+	})
+
+	for number, key := range keys {
+		orphaned := number > 97
+		if isCached(t, key) == orphaned {
+			t.Errorf("entry of block %d cached = %v", number, !orphaned)
+		}
+		if hasBlockRef(t, upstream.URL, number, key) == orphaned {
+			t.Errorf("block reference of %d kept = %v", number, !orphaned)
+		}
+	}
+	if invalidated := InvalidatedEntries() - before; invalidated != 2 {
+		t.Errorf("%d entries invalidated, want 2", invalidated)
+	}
+	if _, ok := localcache.TimelyRequests.Load("reorged"); ok {
+		t.Error("timely response of a reorged block kept")
+	}
+	if _, ok := localcache.TimelyRequests.Load("kept"); !ok {
+		t.Error("timely response before the fork removed")
+	}
+}
+
+func TestRollbackCacheAfterOldHead(t *testing.T) {
+	useTestDB(t)
+	upstream := newFakeUpstream(t, 100)
+	upstream.follow(t)
+	other := newFakeUpstream(t, 100)
+	cfg := config.Default()
+	cfg.RPCURL.Allow = []string{upstream.URL, other.URL}
+	config.Set(cfg)
+
+	// entries of blocks fetched by number ahead of the heads seen
+	keys := make(map[uint64][]byte)
+	for _, number := range []uint64{97, 98, 101, 105} {
+		keys[number] = cacheAtBlock(t, upstream.URL, number)
+	}
+	otherKey := cacheAtBlock(t, other.URL, 103)
+
+	rollbackCache(upstream.URL, tracker.Reorg{
+		Fork:     97,
+		Depth:    1,
+		OldHead:  tracker.Head{Number: 98},
+		Orphaned: map[uint64]string{98: "0x98"},
+	})
+
+	for number, key := range keys {
+		orphaned := number > 97
+		if isCached(t, key) == orphaned {
+			t.Errorf("entry of block %d cached = %v", number, !orphaned)
+		}
+		if hasBlockRef(t, upstream.URL, number, key) == orphaned {
+			t.Errorf("block reference of %d kept = %v", number, !orphaned)
+		}
+	}
+	if !isCached(t, otherKey) || !hasBlockRef(t, other.URL, 103, otherKey) {
+		t.Error("entry of another upstream removed")
+	}
+}
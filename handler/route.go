@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jeffprestes/sjrpc/config"
+	"github.com/jeffprestes/sjrpc/subscription"
+	"github.com/jeffprestes/sjrpc/tracker"
+	"github.com/jeffprestes/sjrpc/upstream"
+	"github.com/labstack/echo/v4"
+)
+
+// upstreamIdleTimeout is how long the head tracker, subscription hub and
+// transports of an upstream given by a client are kept unused.
+const upstreamIdleTimeout = 10 * time.Minute
+
+// Route path parameters used to select a configured chain.
+const (
+	ParamChainName = "chain"
+	ParamChainId   = "id"
+)
+
+// RouteChain returns the configured chain selected by the request path, as in
+// /sepolia or /chain/11155111. routed is false when the request path does not
+// select a chain, and ok is false when the selected chain is not configured.
+func RouteChain(echoCtx echo.Context) (chain config.Chain, routed bool, ok bool) {
+	cfg := config.Get()
+	if name := echoCtx.Param(ParamChainName); len(name) > 0 {
+		routed = true
+		chain, ok = cfg.ChainByName(name)
+		return
+	}
+	if id := echoCtx.Param(ParamChainId); len(id) > 0 {
+		routed = true
+		chainId, err := strconv.Atoi(id)
+		if err != nil {
+			return
+		}
+		chain, ok = cfg.ChainByID(chainId)
+	}
+	return
+}
+
+// checkUpstream returns an error when the upstream selected with the rpcUrl
+// query parameter is not allowed. In arbitrary mode, URLs not allowed are
+// accepted when their host resolves to public addresses, and connections to
+// them are restricted to public addresses. The restriction is renewed on
+// every check, so it is not evicted while the URL is in use.
+func checkUpstream(ctx context.Context, rpcUrl string) error {
+	cfg := config.Get()
+	if cfg.IsAllowedRPCURL(rpcUrl) {
+		return nil
+	}
+	if !cfg.RPCURL.Arbitrary {
+		return echo.NewHTTPError(http.StatusForbidden, "upstream not allowed, use a configured chain or an allowed rpcUrl")
+	}
+	if !upstream.IsRestricted(rpcUrl) {
+		err := upstream.CheckPublicURL(ctx, rpcUrl)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("upstream not allowed: %v", err))
+		}
+	}
+	upstream.Restrict(rpcUrl)
+	upstream.Restrict(config.WebSocketURL(rpcUrl))
+	return nil
+}
+
+// RunEviction removes every interval the head trackers, subscription hubs
+// and transports of the upstreams given by clients that were not used for
+// upstreamIdleTimeout, until ctx is done. The ones of configured upstreams
+// are kept.
+func RunEviction(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			evictIdleUpstreams()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func evictIdleUpstreams() {
+	keep := func(url string) bool {
+		return config.Get().IsConfiguredURL(url)
+	}
+	for _, headTracker := range tracker.EvictIdle(upstreamIdleTimeout, keep) {
+		watchedTrackers.Delete(headTracker)
+	}
+	subscription.EvictIdle(upstreamIdleTimeout, keep)
+	upstream.EvictIdle(upstreamIdleTimeout, keep)
+}
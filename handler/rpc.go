@@ -2,32 +2,58 @@ package handler
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
-	"log"
 	"math/big"
 	"net/http"
 	"os"
-	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
-	"github.com/carlmjohnson/requests"
 	"github.com/dgraph-io/badger/v4"
+	"github.com/jeffprestes/sjrpc/config"
 	"github.com/jeffprestes/sjrpc/database"
 	"github.com/jeffprestes/sjrpc/localcache"
+	"github.com/jeffprestes/sjrpc/logging"
 	"github.com/jeffprestes/sjrpc/model"
+	"github.com/jeffprestes/sjrpc/tracing"
+	"github.com/jeffprestes/sjrpc/tracker"
+	"github.com/jeffprestes/sjrpc/upstream"
 	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func PostHandler(echoCtx echo.Context) error {
-	var err error
+func PostHandler(echoCtx echo.Context) (err error) {
+	ctx, span := tracing.Start(tracing.Extract(echoCtx.Request().Context(), echoCtx.Request().Header), "POST "+echoCtx.Path(), trace.SpanKindServer,
+		attribute.String("http.request.method", http.MethodPost),
+		attribute.String("http.route", echoCtx.Path()),
+	)
+	defer func() {
+		endServerSpan(echoCtx, span, err)
+	}()
+	if traceId := span.SpanContext().TraceID(); traceId.IsValid() {
+		ctx = logging.With(ctx, "trace_id", traceId.String())
+	}
+	echoCtx.SetRequest(echoCtx.Request().WithContext(ctx))
 
 	// Function params
-	debug, userSelectedChainId, rpcUrl := CheckParams(echoCtx)
+	userSelectedChainId, rpcUrl := CheckParams(echoCtx)
+	if _, routed, ok := RouteChain(echoCtx); routed && !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "unknown chain")
+	}
 	if len(rpcUrl) < 5 {
-		err = fmt.Errorf("no SJRPC_URL server set in environment variable or query string")
+		err = fmt.Errorf("no upstream server set in command line, SJRPC_URL environment variable or query string")
+		return err
+	}
+	err = checkUpstream(echoCtx.Request().Context(), rpcUrl)
+	if err != nil {
+		return err
+	}
+	err = checkKeyChain(echoCtx, userSelectedChainId, rpcUrl)
+	if err != nil {
 		return err
 	}
 
@@ -35,137 +61,84 @@ func PostHandler(echoCtx echo.Context) error {
 
 	contentType := echoCtx.Request().Header["Content-Type"][0]
 	if len(contentType) < 10 || strings.ToLower(contentType) != "application/json" {
-		if debug {
-			log.Print("\n")
-			log.Println("********************************************")
-			log.Println("What is echoCtx.Request().Header[Content-Type] ?", echoCtx.Request().Header["Content-Type"][0])
-			log.Println("********************************************")
-			log.Print("\n")
-		}
 		err = fmt.Errorf("invalid content-type header: %s", contentType)
 		echoCtx.JSON(http.StatusBadRequest, err)
 		return err
 	}
 
-	body, errReadBytes := io.ReadAll(echoCtx.Request().Body)
+	body, tooLarge, errReadBytes := readBody(echoCtx.Request().Body)
 	if errReadBytes != nil {
-		log.Printf("error reading request bytes: %s\n", errReadBytes.Error())
+		logging.FromContext(ctx).Warn("error reading request body", "error", errReadBytes)
 		return errReadBytes
 	}
+	if tooLarge {
+		return echoCtx.String(http.StatusRequestEntityTooLarge, bodyTooLarge())
+	}
+	firewall := NewFirewall(echoCtx, userSelectedChainId, rpcUrl)
+	if requestsBypass(echoCtx) {
+		ctx = withCacheBypass(ctx)
+	}
+	response, statuses, err := ProcessRequests(ctx, body, userSelectedChainId, rpcUrl, firewall)
+	if err != nil {
+		return err
+	}
+	setCacheHeaders(echoCtx, statuses)
+	return echoCtx.String(http.StatusOK, response)
+}
+
+// ProcessRequests answers the JSON-RPC request or batch in body and returns
+// the response body. Requests for methods blocked by the firewall are
+// answered with an error and not dispatched, as bodies exceeding the request
+// limits. Bodies that cannot be decoded are answered with a parse error.
+func ProcessRequests(ctx context.Context, body []byte, chainId *int, rpcUrl string, firewall *Firewall) (response string, statuses []CacheStatus, err error) {
+	response, exceeded := checkLimits(body)
+	if exceeded {
+		return
+	}
 	var requests []model.RPCRequest
 	var request model.RPCRequest
 	errDecode := json.Unmarshal(body, &request)
 	if errDecode != nil {
-		errDecode = json.Unmarshal(body, &requests)
-		if errDecode != nil {
-			log.Printf("decoding request error: %s\n", errDecode.Error())
-			return errDecode
-		}
-		if debug {
-			log.Println("Number of requests: ", len(requests))
+		errBatch := json.Unmarshal(body, &requests)
+		if errBatch != nil {
+			// the error of a batch is only meaningful for bodies that are arrays
+			if bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+				errDecode = errBatch
+			}
+			logging.FromContext(ctx).Debug("invalid request body", "error", errDecode)
+			response = model.NewRawIDErrorResponse(nil, model.ErrCodeParse, errDecode.Error())
+			return
 		}
 	} else {
 		requests = append(requests, request)
 	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("sjrpc.batch.size", len(requests)))
+	// the first call is paid by the request or message
+	if !firewall.allowCalls(len(requests) - 1) {
+		err = errRateLimited
+		return
+	}
 
 	var respFinal strings.Builder
 	var resp string
-	cacheUsed := true
 
 	for i := 0; i < len(requests); i++ {
 		request = requests[i]
-		requestHash := request.Base64Hash(userSelectedChainId)
-
-		if cacheUsed && debug {
-			log.Print("\n\n")
-			log.Println(" +++ request: ", requestHash)
-			log.Printf("%+v", request)
-			log.Print("\n\n")
-		}
 
-		if request.IsCacheable() {
-			resp, err = database.DB.Get(database.RequestNamespace, request.Hash(userSelectedChainId))
-			if err == badger.ErrKeyNotFound {
-				resp, err = PerformRemoteCall(echoCtx, &request, rpcUrl)
-				if err != nil {
-					return err
-				}
-				database.DB.Insert(database.RequestNamespace, request.Hash(userSelectedChainId), []byte(resp))
-				cacheUsed = false
-			} else if err != nil {
-				return err
-			}
-		} else if request.IsAfterFinalCacheable() {
-			resp, err = database.DB.Get(database.RequestNamespace, request.Hash(userSelectedChainId))
-			if err == badger.ErrKeyNotFound {
-				resp, err = PerformRemoteCall(echoCtx, &request, rpcUrl)
-				if err != nil {
-					return err
-				}
-				if request.IsResultFinal(resp) {
-					database.DB.Insert(database.RequestNamespace, request.Hash(userSelectedChainId), []byte(resp))
-					cacheUsed = false
-				}
-			} else if err != nil {
-				return err
-			}
-		} else if request.IsEnvCacheable() {
-			if strings.ToLower(request.Method) == "eth_accounts" {
-				respJson := model.AccountResponse{}
-				respJson.ID = request.ID
-				respJson.Jsonrpc = request.JsonRpcVersion
-				respJson.Result = append(respJson.Result, os.Getenv("ETH_FROM"))
-				resp = respJson.ToString()
-			}
-		} else if request.IsTimelyCacheable() {
-			var respObj model.EphemeralRequest
-			tmpObj, ok := localcache.TimelyRequests.Load(request.Base64Hash(userSelectedChainId))
-			if !ok {
-				respObj, err = PerformRemoteCallForTimelyEndpoints(echoCtx, &request, rpcUrl)
-				if err != nil {
-					if debug {
-						log.Printf("request.IsTimelyCacheable - PerformRemoteCallForTimelyEndpoints: %s\n", err.Error())
-					}
-					return err
-				}
-				localcache.TimelyRequests.Store(request.Base64Hash(userSelectedChainId), respObj)
-				cacheUsed = false
-			} else {
-				if debug {
-					log.Println("Request base64hash: ", request.Base64Hash(userSelectedChainId))
-				}
-				respObj = tmpObj.(model.EphemeralRequest)
-				if !respObj.IsStillValid() {
-					respObj, err = PerformRemoteCallForTimelyEndpoints(echoCtx, &request, rpcUrl)
-					if err != nil {
-						return err
-					}
-					_, swapped := localcache.TimelyRequests.Swap(request.Base64Hash(userSelectedChainId), respObj)
-					if swapped {
-						if debug {
-							log.Println(request.Base64Hash(userSelectedChainId), " has been updated")
-						}
-					}
-					cacheUsed = false
-				}
-			}
-			resp = respObj.Response
-		} else {
-			resp, err = PerformRemoteCall(echoCtx, &request, rpcUrl)
+		var blocked bool
+		var status CacheStatus
+		resp, blocked = admit(&request, chainId, firewall)
+		if !blocked {
+			resp, _, err = ProcessRequest(withCacheStatus(ctx, &status), &request, chainId, rpcUrl)
 			if err != nil {
-				return err
+				return
 			}
-			cacheUsed = false
-		}
-
-		if cacheUsed && debug {
-			log.Print("\n\n")
-			log.Println(" *** cache was used for the request: ", requestHash)
-			log.Print("\n\n")
 		}
+		statuses = append(statuses, status)
 
+		// cached responses hold the id of the request that filled the cache
+		resp = RestoreOriginalId(&request, resp)
 		if len(requests) > 1 {
-			resp = RestoreOriginalId(&request, resp)
 			if i > 0 && i < len(requests) {
 				resp = "," + resp
 			} else if i == 0 {
@@ -175,42 +148,160 @@ func PostHandler(echoCtx echo.Context) error {
 
 		_, err = respFinal.WriteString(resp)
 		if err != nil {
-			log.Println("error writing response into response buffer: ", err.Error())
-			return err
-		}
-		if debug {
-			log.Println("resp added: ", resp, " - respFinal: ", respFinal.String())
+			return
 		}
 	}
 	if len(requests) > 1 {
 		respFinal.WriteString("]")
 	}
 
-	if debug {
-		log.Print("Response:\n", respFinal.String(), "\n\n")
+	response = respFinal.String()
+	return
+}
+
+// ProcessRequest answers a single JSON-RPC request from the cache tier of its
+// method, calling rpcUrl when the answer is not cached. cacheUsed reports if
+// the response came from the cache.
+func ProcessRequest(ctx context.Context, request *model.RPCRequest, chainId *int, rpcUrl string) (resp string, cacheUsed bool, err error) {
+	cacheUsed = true
+	tier := cacheTier(request)
+	bypass := isBypassed(ctx) && isUpstreamCached(tier)
+	ctx, span := tracing.Start(ctx, request.Method, trace.SpanKindInternal,
+		attribute.String("rpc.system", "jsonrpc"),
+		attribute.String("rpc.method", request.Method),
+		attribute.Int("rpc.jsonrpc.request_id", request.ID),
+		attribute.String("sjrpc.chain", chainLabel(chainId)),
+		attribute.String("sjrpc.cache.tier", tier),
+	)
+	defer func(start time.Time) {
+		countRequest(request.Method, chainId, tier, cacheUsed, err)
+		recordCacheStatus(ctx, tier, rpcUrl, cacheUsed, bypass)
+		logRequest(ctx, request, chainId, tier, resp, start, err)
+		if status, ok := ctx.Value(cacheStatusKey{}).(*CacheStatus); ok {
+			span.SetAttributes(attribute.String("sjrpc.cache", status.Cache))
+		}
+		tracing.RecordError(span, err)
+		span.End()
+	}(time.Now())
+	if bypass {
+		resp, err = PerformRemoteCall(ctx, request, rpcUrl)
+		cacheUsed = false
+		return
 	}
+	switch tier {
+	case tierFilter:
+		resp, err = ProcessFilterRequest(ctx, request, chainId, rpcUrl)
+	case tierTransaction:
+		resp, err = ProcessSendRawTransaction(ctx, request, chainId, rpcUrl)
+		cacheUsed = false
+	case tierBlocks:
+		resp, cacheUsed, err = ProcessBlockRequest(ctx, request, chainId, rpcUrl)
+	case tierPermanent:
+		lookupCtx, lookup := startLookup(ctx, tier)
+		resp, err = database.Traced(lookupCtx).Get(database.RequestNamespace, request.Hash(chainId))
+		endLookup(lookup, err == nil, err)
+		if err == badger.ErrKeyNotFound {
+			resp, err = PerformRemoteCall(ctx, request, rpcUrl)
+			if err != nil {
+				return
+			}
+			database.Traced(ctx).Insert(database.RequestNamespace, request.Hash(chainId), []byte(resp))
+			trackCachedBlock(ctx, request, chainId, rpcUrl, resp)
+			cacheUsed = false
+		} else if err != nil {
+			return
+		}
+	case tierAfterFinal:
+		resp, cacheUsed, err = processAfterFinalRequest(ctx, request, chainId, rpcUrl)
+	case tierBlockBound:
+		resp, cacheUsed, err = processBlockBoundRequest(ctx, request, chainId, rpcUrl)
+	case tierEnv:
+		if strings.ToLower(request.Method) == "eth_accounts" {
+			respJson := model.AccountResponse{}
+			respJson.ID = request.ID
+			respJson.Jsonrpc = request.JsonRpcVersion
+			respJson.Result = append(respJson.Result, os.Getenv("ETH_FROM"))
+			resp = respJson.ToString()
+		}
+	case tierLogs:
+		resp, cacheUsed, err = ProcessLogsRequest(ctx, request, chainId, rpcUrl)
+	case tierTimely:
+		resp, cacheUsed, err = processTimelyRequest(ctx, request, chainId, rpcUrl)
+	default:
+		resp, err = PerformRemoteCall(ctx, request, rpcUrl)
+		if err != nil {
+			return
+		}
+		cacheUsed = false
+	}
+	return
+}
 
-	return echoCtx.String(http.StatusOK, respFinal.String())
+// processTimelyRequest answers the request from the in-memory cache while the
+// chain head has not moved past the TTL of the method.
+func processTimelyRequest(ctx context.Context, request *model.RPCRequest, chainId *int, rpcUrl string) (resp string, cacheUsed bool, err error) {
+	cacheUsed = true
+	var respObj model.EphemeralRequest
+	_, lookup := startLookup(ctx, tierTimely)
+	tmpObj, ok := localcache.TimelyRequests.Load(request.Base64Hash(chainId))
+	endLookup(lookup, ok, nil)
+	if !ok {
+		respObj, err = PerformRemoteCallForTimelyEndpoints(ctx, request, rpcUrl)
+		if err != nil {
+			return
+		}
+		localcache.TimelyRequests.Store(request.Base64Hash(chainId), respObj)
+		cacheUsed = false
+	} else {
+		respObj = tmpObj.(model.EphemeralRequest)
+		if !respObj.IsStillValid() {
+			respObj, err = PerformRemoteCallForTimelyEndpoints(ctx, request, rpcUrl)
+			if err != nil {
+				return
+			}
+			localcache.TimelyRequests.Store(request.Base64Hash(chainId), respObj)
+			cacheUsed = false
+		} else {
+			// the response is kept for a while after new blocks arrive
+			head, errHead := LatestHead(ctx, rpcUrl)
+			noteCached(ctx, respObj.Fetched, errHead == nil && head.Number > respObj.BlockNumber)
+		}
+	}
+	resp = respObj.Response
+	return
 }
 
-func PerformRemoteCall(echoCtx echo.Context, request *model.RPCRequest, rpcUrl string) (resp string, err error) {
-	tmpResp := new(bytes.Buffer)
-	err = requests.URL(rpcUrl).BodyJSON(request).ContentType("application/json").ToBytesBuffer(tmpResp).Fetch(echoCtx.Request().Context())
-	if err != nil {
+func PerformRemoteCall(ctx context.Context, request *model.RPCRequest, rpcUrl string) (resp string, err error) {
+	ctx, span := tracing.Start(ctx, "upstream "+request.Method, trace.SpanKindClient,
+		attribute.String("rpc.system", "jsonrpc"),
+		attribute.String("rpc.method", request.Method),
+		attribute.String("server.address", upstream.Provider(rpcUrl)),
+	)
+	defer func(start time.Time) {
+		noteUpstreamLatency(ctx, time.Since(start))
+		tracing.RecordError(span, err)
+		span.End()
+	}(time.Now())
+	if !isCoalescable(request) {
+		resp, err = upstream.For(rpcUrl).Call(ctx, request)
 		return
 	}
-	resp = tmpResp.String()
+	resp, err = coalescedCall(ctx, request, rpcUrl)
 	return
 }
 
-func GetLatestBlockInfo(echoCtx echo.Context, rpcUrl string) (resp model.BlockByNumberResponse, err error) {
+func GetLatestBlockInfo(ctx context.Context, rpcUrl string) (resp model.BlockByNumberResponse, err error) {
 	var request model.RPCRequest
 	request.JsonRpcVersion = "2.0"
 	request.Method = "eth_blockNumber"
 	request.ID = 1
 
 	blockNumberResp := new(model.BlockNumberResponse)
-	err = requests.URL(rpcUrl).BodyJSON(request).ContentType("application/json").ToJSON(blockNumberResp).Fetch(echoCtx.Request().Context())
+	tmpResp, err := PerformRemoteCall(ctx, &request, rpcUrl)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal([]byte(tmpResp), blockNumberResp)
 	if err != nil {
 		return
 	}
@@ -221,7 +312,11 @@ func GetLatestBlockInfo(echoCtx echo.Context, rpcUrl string) (resp model.BlockBy
 	request.Params = append(request.Params, blockNumberResp.Result)
 	request.Params = append(request.Params, true)
 	blockByNumberResp := new(model.BlockByNumberResponse)
-	err = requests.URL(rpcUrl).BodyJSON(request).ContentType("application/json").ToJSON(blockByNumberResp).Fetch(echoCtx.Request().Context())
+	tmpResp, err = PerformRemoteCall(ctx, &request, rpcUrl)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal([]byte(tmpResp), blockByNumberResp)
 	if err != nil {
 		return
 	}
@@ -229,8 +324,8 @@ func GetLatestBlockInfo(echoCtx echo.Context, rpcUrl string) (resp model.BlockBy
 	return
 }
 
-func GetLatestBlockTimestamp(echoCtx echo.Context, rpcUrl string) (timestamp uint64, err error) {
-	block, err := GetLatestBlockInfo(echoCtx, rpcUrl)
+func GetLatestBlockTimestamp(ctx context.Context, rpcUrl string) (timestamp uint64, err error) {
+	block, err := GetLatestBlockInfo(ctx, rpcUrl)
 	if err != nil {
 		return
 	}
@@ -243,25 +338,49 @@ func GetLatestBlockTimestamp(echoCtx echo.Context, rpcUrl string) (timestamp uin
 	return
 }
 
-func PerformRemoteCallForTimelyEndpoints(echoCtx echo.Context, request *model.RPCRequest, rpcUrl string) (respObj model.EphemeralRequest, err error) {
-	var lastBlock model.BlockByNumberResponse
-	lastBlock, err = GetLatestBlockInfo(echoCtx, rpcUrl)
+// HeadTracker returns the head tracker of the upstream rpcUrl. Only the head
+// of configured and allowed upstreams is followed; the trackers of URLs given
+// by clients fetch the head when it is asked for and are evicted when idle.
+func HeadTracker(rpcUrl string) *tracker.Tracker {
+	headTracker := tracker.For(rpcUrl, config.Get().WSUpstreamFor(rpcUrl))
+	if followsHead(rpcUrl) {
+		watchReorgs(headTracker)
+		headTracker.Start()
+	}
+	return headTracker
+}
+
+// followsHead reports if the head of rpcUrl is followed, so the reorgs of
+// its chain are seen.
+func followsHead(rpcUrl string) bool {
+	return config.Get().IsAllowedRPCURL(rpcUrl)
+}
+
+// LatestHead returns the chain head of rpcUrl known by its head tracker.
+func LatestHead(ctx context.Context, rpcUrl string) (head tracker.Head, err error) {
+	head, err = HeadTracker(rpcUrl).Latest(ctx)
+	return
+}
+
+func PerformRemoteCallForTimelyEndpoints(ctx context.Context, request *model.RPCRequest, rpcUrl string) (respObj model.EphemeralRequest, err error) {
+	latest, err := LatestHead(ctx, rpcUrl)
 	if err != nil {
 		return
 	}
-	respObj.BlockNumber = ConvertStrRespToUInt64(lastBlock.Result.Number)
+	respObj.BlockNumber = latest.Number
+	respObj.Fetched = time.Now()
 	if respObj.BlockNumber < 1000 {
 		if !strings.Contains(rpcUrl, "localhost") && !strings.Contains(rpcUrl, "127.0.0.1") {
-			err = fmt.Errorf("could not convert block number to int: %s", lastBlock.Result.Number)
+			err = fmt.Errorf("could not convert block number to int: %d", latest.Number)
 			return
 		}
 	}
-	respObj.When = ConvertStrRespToInt64(lastBlock.Result.Timestamp)
+	respObj.When = latest.Timestamp
 	if respObj.When < 1000 {
-		err = fmt.Errorf("could not convert block number to timestamp: %s", lastBlock.Result.Timestamp)
+		err = fmt.Errorf("could not convert block number to timestamp: %d", latest.Timestamp)
 		return
 	}
-	newResp, err := PerformRemoteCall(echoCtx, request, rpcUrl)
+	newResp, err := PerformRemoteCall(ctx, request, rpcUrl)
 	if err != nil {
 		return
 	}
@@ -289,14 +408,18 @@ func ConvertStrRespToUInt64(strNumber string) (value uint64) {
 	if ok {
 		value = tmpInt.Uint64()
 	}
-	// log.Println("ConvertStrRespToUInt64 - strNumber: ", strNumber, " - found: ", found, " - tmpStr: ", tmpStr, " - ok: ", ok, " - tmpInt: ", tmpInt, " - value: ", value)
 	return
 }
 
-func CheckParams(echoCtx echo.Context) (debug bool, chainId *int, rpcUrl string) {
-	if echoCtx.QueryParam("debug") == "1" ||
-		strings.ToLower(echoCtx.QueryParam("debug")) == "true" {
-		debug = true
+func CheckParams(echoCtx echo.Context) (chainId *int, rpcUrl string) {
+	// Chains selected by the path never take the upstream from the query string
+	chain, routed, ok := RouteChain(echoCtx)
+	if routed {
+		if ok {
+			chainId = &chain.ChainID
+			rpcUrl = chain.Upstreams[0]
+		}
+		return
 	}
 
 	if echoCtx.Request().URL.Query().Has("rpcurl") ||
@@ -312,8 +435,10 @@ func CheckParams(echoCtx echo.Context) (debug bool, chainId *int, rpcUrl string)
 		} else if len(echoCtx.QueryParam("rpcUrl")) > 0 {
 			rpcUrl = echoCtx.QueryParam("rpcUrl")
 		}
-	} else {
-		rpcUrl = os.Getenv("SJRPC_URL")
+		aliased, ok := config.Get().RPCURL.Aliases[rpcUrl]
+		if ok {
+			rpcUrl = aliased
+		}
 	}
 
 	if echoCtx.Request().URL.Query().Has("chainId") ||
@@ -338,16 +463,47 @@ func CheckParams(echoCtx echo.Context) (debug bool, chainId *int, rpcUrl string)
 			}
 		}
 	}
+
+	if len(rpcUrl) < 1 {
+		rpcUrl = config.Get().Upstream()
+		if chainId != nil {
+			chain, ok := config.Get().ChainByID(*chainId)
+			if ok {
+				rpcUrl = chain.Upstreams[0]
+			}
+		}
+	}
 	return
 }
 
+// RestoreOriginalId sets the id of the request in the response, which holds
+// the id of the request that filled the cache. Only the numeric id of the
+// response object is replaced, not the ids found in its result.
 func RestoreOriginalId(request *model.RPCRequest, resp string) (newResp string) {
-	exp := "\"id\":[0-9]+"
-	re, err := regexp.CompilePOSIX(exp)
-	if err != nil {
+	newResp = resp
+	decoder := json.NewDecoder(strings.NewReader(resp))
+	token, err := decoder.Token()
+	if err != nil || token != json.Delim('{') {
+		return
+	}
+	for decoder.More() {
+		token, err = decoder.Token()
+		if err != nil {
+			return
+		}
+		var value json.RawMessage
+		err = decoder.Decode(&value)
+		if err != nil {
+			return
+		}
+		if token != "id" {
+			continue
+		}
+		if len(value) > 0 && value[0] >= '0' && value[0] <= '9' {
+			end := int(decoder.InputOffset())
+			newResp = resp[:end-len(value)] + strconv.Itoa(request.ID) + resp[end:]
+		}
 		return
 	}
-	tmpId := "\"id\":" + strconv.Itoa(request.ID)
-	newResp = re.ReplaceAllString(resp, tmpId)
 	return
 }
@@ -0,0 +1,27 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/jeffprestes/sjrpc/model"
+)
+
+func TestRestoreOriginalId(t *testing.T) {
+	request := &model.RPCRequest{JsonRpcVersion: "2.0", ID: 7, Method: "eth_getBlockByNumber"}
+	for _, tc := range []struct {
+		name, resp, want string
+	}{
+		{"result", `{"jsonrpc":"2.0","id":1,"result":"0x1"}`, `{"jsonrpc":"2.0","id":7,"result":"0x1"}`},
+		{"nested ids kept", `{"jsonrpc":"2.0","id":1,"result":{"id":2,"list":[{"id":3}]}}`, `{"jsonrpc":"2.0","id":7,"result":{"id":2,"list":[{"id":3}]}}`},
+		{"id after the result", `{"jsonrpc":"2.0","result":{"id":2},"id":1}`, `{"jsonrpc":"2.0","result":{"id":2},"id":7}`},
+		{"spaces", `{ "jsonrpc": "2.0", "id": 12, "result": "0x1" }`, `{ "jsonrpc": "2.0", "id": 7, "result": "0x1" }`},
+		{"id in a string", `{"jsonrpc":"2.0","id":1,"result":"\"id\":2"}`, `{"jsonrpc":"2.0","id":7,"result":"\"id\":2"}`},
+		{"null id", `{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"parse error"}}`, `{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"parse error"}}`},
+		{"not an object", `[{"id":1}]`, `[{"id":1}]`},
+		{"invalid", `{"id":`, `{"id":`},
+	} {
+		if got := RestoreOriginalId(request, tc.resp); got != tc.want {
+			t.Errorf("%s: %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package handler
+
+import "github.com/jeffprestes/sjrpc/model"
+
+// Cache tiers of the requests, named as in the cache policy.
+const (
+	tierFilter      = "filter"
+	tierTransaction = "transaction"
+	tierBlocks      = "blocks"
+	tierPermanent   = "permanent"
+	tierAfterFinal  = "afterFinal"
+	tierBlockBound  = "blockBound"
+	tierEnv         = "env"
+	tierLogs        = "logs"
+	tierTimely      = "timely"
+	tierNone        = "none"
+	// Requests refused by the firewall, counted in the metrics only.
+	tierBlocked = "blocked"
+)
+
+// cacheTier returns the tier answering the request. Methods in more than one
+// tier of the cache policy are answered by the first of this order.
+func cacheTier(request *model.RPCRequest) string {
+	switch {
+	case request.IsFilterMethod():
+		return tierFilter
+	case request.IsSendRawTransaction():
+		return tierTransaction
+	case request.IsBlockCacheable():
+		return tierBlocks
+	case request.IsCacheable():
+		return tierPermanent
+	case request.IsAfterFinalCacheable():
+		return tierAfterFinal
+	case request.IsBlockBoundCacheable():
+		return tierBlockBound
+	case request.IsEnvCacheable():
+		return tierEnv
+	case request.IsLogsCacheable():
+		return tierLogs
+	case request.IsTimelyCacheable():
+		return tierTimely
+	}
+	return tierNone
+}
+
+// isUpstreamCached reports if the tier caches upstream responses.
+func isUpstreamCached(tier string) bool {
+	switch tier {
+	case tierBlocks, tierPermanent, tierAfterFinal, tierBlockBound, tierLogs, tierTimely:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/jeffprestes/sjrpc/tracing"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracesHandler answers the spans kept by exporter in the OTLP/HTTP JSON
+// encoding.
+func TracesHandler(exporter *tracing.MemoryExporter) echo.HandlerFunc {
+	return func(echoCtx echo.Context) error {
+		tracing.Flush()
+		return echoCtx.JSON(http.StatusOK, exporter)
+	}
+}
+
+// endServerSpan ends the span of an HTTP request with its status code.
+// Server errors set the status of the span to error.
+func endServerSpan(echoCtx echo.Context, span trace.Span, err error) {
+	// err sets the status only when no response was written
+	status := echoCtx.Response().Status
+	if err != nil && !echoCtx.Response().Committed {
+		status = http.StatusInternalServerError
+		var httpErr *echo.HTTPError
+		if errors.As(err, &httpErr) {
+			status = httpErr.Code
+		}
+	}
+	span.SetAttributes(attribute.Int("http.response.status_code", status))
+	if status >= http.StatusInternalServerError {
+		message := http.StatusText(status)
+		if err != nil {
+			message = err.Error()
+		}
+		span.SetStatus(codes.Error, message)
+	}
+	span.End()
+}
+
+// startLookup starts the span of a lookup in the cache of tier.
+func startLookup(ctx context.Context, tier string) (context.Context, trace.Span) {
+	return tracing.Start(ctx, "cache lookup", trace.SpanKindInternal, attribute.String("sjrpc.cache.tier", tier))
+}
+
+// endLookup ends the span of a cache lookup, which found the response when hit
+// is true.
+func endLookup(span trace.Span, hit bool, err error) {
+	span.SetAttributes(attribute.Bool("sjrpc.cache.hit", hit))
+	if err != badger.ErrKeyNotFound {
+		tracing.RecordError(span, err)
+	}
+	span.End()
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/jeffprestes/sjrpc/tracing"
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// useTestTracing records every span of the test in memory.
+func useTestTracing(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	if err := tracing.Setup(exporter, "sjrpc", "test", 1); err != nil {
+		t.Fatalf("cannot set up tracing: %v", err)
+	}
+	t.Cleanup(tracing.Shutdown)
+	return exporter
+}
+
+// upstreamHeader returns the headers of the request received for method.
+func (u *fakeUpstream) upstreamHeader(method string) http.Header {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	for i, request := range u.requests {
+		if request.Method == method {
+			return u.headers[i]
+		}
+	}
+	return nil
+}
+
+func TestPostBatchSpans(t *testing.T) {
+	useTestDB(t)
+	exporter := useTestTracing(t)
+	upstream := newFakeUpstream(t, 100)
+	upstream.follow(t)
+
+	const clientTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	const clientSpanID = "00f067aa0ba902b7"
+	body := `[{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]},{"jsonrpc":"2.0","id":2,"method":"net_version","params":[]}]`
+	request := httptest.NewRequest(http.MethodPost, "/?rpcUrl="+url.QueryEscape(upstream.URL), strings.NewReader(body))
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	request.Header.Set("traceparent", "00-"+clientTraceID+"-"+clientSpanID+"-01")
+	recorder := httptest.NewRecorder()
+	echoCtx := echo.New().NewContext(request, recorder)
+	echoCtx.SetPath("/")
+	if err := PostHandler(echoCtx); err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	tracing.Flush()
+
+	spans := exporter.GetSpans()
+	children := func(parent trace.SpanContext) (names []string, byName map[string]tracetest.SpanStub) {
+		byName = map[string]tracetest.SpanStub{}
+		for _, span := range spans {
+			if span.Parent.SpanID() == parent.SpanID() {
+				names = append(names, span.Name)
+				byName[span.Name] = span
+			}
+		}
+		return
+	}
+
+	var server *tracetest.SpanStub
+	for i := range spans {
+		if spans[i].Name == "POST /" {
+			server = &spans[i]
+		}
+	}
+	if server == nil {
+		t.Fatalf("no server span in %d spans", len(spans))
+	}
+	if server.SpanKind != trace.SpanKindServer {
+		t.Errorf("server span kind %v", server.SpanKind)
+	}
+	if server.SpanContext.TraceID().String() != clientTraceID || server.Parent.SpanID().String() != clientSpanID {
+		t.Errorf("server span does not continue the client trace: trace %s, parent %s", server.SpanContext.TraceID(), server.Parent.SpanID())
+	}
+
+	names, entries := children(server.SpanContext)
+	if len(names) != 2 || entries["eth_chainId"].Name == "" || entries["net_version"].Name == "" {
+		t.Fatalf("batch entry spans %v, want eth_chainId and net_version", names)
+	}
+	for method, entry := range entries {
+		names, spansOf := children(entry.SpanContext)
+		if _, ok := spansOf["cache lookup"]; !ok {
+			t.Errorf("%s has no cache lookup span, got %v", method, names)
+		}
+		remote, ok := spansOf["upstream "+method]
+		if !ok {
+			t.Errorf("%s has no upstream span, got %v", method, names)
+			continue
+		}
+		if remote.SpanKind != trace.SpanKindClient {
+			t.Errorf("%s upstream span kind %v", method, remote.SpanKind)
+		}
+		want := fmt.Sprintf("00-%s-%s-01", clientTraceID, remote.SpanContext.SpanID())
+		if got := upstream.upstreamHeader(method).Get("traceparent"); got != want {
+			t.Errorf("%s upstream traceparent %q, want %q", method, got, want)
+		}
+	}
+}
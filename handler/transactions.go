@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeffprestes/sjrpc/config"
+	"github.com/jeffprestes/sjrpc/localcache"
+	"github.com/jeffprestes/sjrpc/logging"
+	"github.com/jeffprestes/sjrpc/model"
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	// How long a transaction sent through sjrpc is followed while pending.
+	pendingTimeout = 30 * time.Minute
+
+	// Polls answered by upstream, one per new head, before waiting longer
+	// between them.
+	pendingEagerChecks = 3
+
+	// Most heads between two upstream polls of a pending transaction.
+	pendingMaxWait = 4
+)
+
+// ProcessSendRawTransaction submits a signed transaction to upstream, or to
+// every upstream of the chain when broadcasting is enabled. Submissions are
+// never cached nor sent again on failure: upstream may have accepted the
+// transaction before failing. Accepted transactions are followed until they
+// are in a block, so polls for them do not reach upstream at every call.
+func ProcessSendRawTransaction(ctx context.Context, request *model.RPCRequest, chainId *int, rpcUrl string) (resp string, err error) {
+	rpcUrls := []string{rpcUrl}
+	if config.Get().Transactions.Broadcast {
+		rpcUrls = config.Get().UpstreamsFor(rpcUrl)
+	}
+	responses := make([]string, len(rpcUrls))
+	errs := make([]error, len(rpcUrls))
+	var wg sync.WaitGroup
+	for i, url := range rpcUrls {
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			responses[i], errs[i] = PerformRemoteCall(ctx, request, url)
+		}(i, url)
+	}
+	wg.Wait()
+
+	// the first upstream accepting the transaction answers
+	accepted := false
+	for i, url := range rpcUrls {
+		if errs[i] != nil {
+			logging.FromContext(ctx).Warn("error sending transaction", "upstream", upstreamHost(url), "error", errs[i])
+			continue
+		}
+		if !model.IsResultNull(responses[i]) {
+			resp = responses[i]
+			accepted = true
+			break
+		}
+		if len(resp) < 1 {
+			resp = responses[i]
+		}
+	}
+	if len(resp) < 1 {
+		err = errs[0]
+		return
+	}
+	if !accepted {
+		return
+	}
+
+	// the hash of blob transactions sent in their network form is not the
+	// hash of their encoding, so the one of upstream is followed
+	hash, ok := returnedHash(resp)
+	if !ok {
+		var errHash error
+		hash, errHash = rawTransactionHash(request)
+		if errHash != nil {
+			logging.FromContext(ctx).Warn("transaction sent is not followed", "error", errHash)
+			return
+		}
+	}
+	prunePending()
+	localcache.PendingTransactions.Store(pendingKey(chainId, hash), model.PendingTransaction{
+		Hash:      hash,
+		RpcUrl:    rpcUrl,
+		Submitted: time.Now(),
+		Responses: make(map[string]string),
+	})
+	return
+}
+
+// pendingResponse answers a poll for a transaction sent through sjrpc with the
+// last upstream response to the same method while no head that could include
+// the transaction arrived. ok is false when upstream must be polled.
+func pendingResponse(ctx context.Context, request *model.RPCRequest, chainId *int, rpcUrl string) (resp string, ok bool) {
+	key, isHash := pollKey(request, chainId)
+	if !isHash {
+		return
+	}
+	tmp, found := localcache.PendingTransactions.Load(key)
+	if !found {
+		return
+	}
+	pending := tmp.(model.PendingTransaction)
+	if time.Since(pending.Submitted) > pendingTimeout {
+		localcache.PendingTransactions.Delete(key)
+		return
+	}
+	last, polled := pending.Responses[request.Method]
+	if !polled {
+		return
+	}
+	head, err := HeadTracker(rpcUrl).Latest(ctx)
+	if err != nil || head.Number >= pending.NextCheck {
+		return
+	}
+	resp, ok = last, true
+	// the last response is repeated while no new block can change it
+	noteCached(ctx, time.Time{}, true)
+	return
+}
+
+// recordPoll keeps the upstream response to a poll for a transaction sent
+// through sjrpc and sets when upstream is polled again. The transaction is
+// no longer followed once it is in a block.
+func recordPoll(ctx context.Context, request *model.RPCRequest, chainId *int, rpcUrl string, resp string, included bool) {
+	key, isHash := pollKey(request, chainId)
+	if !isHash {
+		return
+	}
+	tmp, found := localcache.PendingTransactions.Load(key)
+	if !found {
+		return
+	}
+	if included {
+		localcache.PendingTransactions.Delete(key)
+		return
+	}
+	if model.IsResponseError(resp) {
+		return
+	}
+	head, err := HeadTracker(rpcUrl).Latest(ctx)
+	if err != nil {
+		return
+	}
+	pending := tmp.(model.PendingTransaction)
+	pending.Checks++
+	// one poll per head at first, then waiting twice as many heads each time
+	wait := uint64(1)
+	for i := pendingEagerChecks; i < pending.Checks && wait < pendingMaxWait; i++ {
+		wait *= 2
+	}
+	pending.NextCheck = head.Number + wait
+	pending.Responses = maps.Clone(pending.Responses)
+	pending.Responses[request.Method] = resp
+	localcache.PendingTransactions.Store(key, pending)
+}
+
+// prunePending stops following the transactions sent long ago, which may
+// never be in a block.
+func prunePending() {
+	localcache.PendingTransactions.Range(func(key, value any) bool {
+		if time.Since(value.(model.PendingTransaction).Submitted) > pendingTimeout {
+			localcache.PendingTransactions.Delete(key)
+		}
+		return true
+	})
+}
+
+// pollKey returns the pending transaction key of a request whose first
+// parameter is a transaction hash.
+func pollKey(request *model.RPCRequest, chainId *int) (key string, ok bool) {
+	if len(request.Params) < 1 {
+		return
+	}
+	hash, ok := request.Params[0].(string)
+	if !ok {
+		return
+	}
+	key = pendingKey(chainId, hash)
+	return
+}
+
+func pendingKey(chainId *int, hash string) string {
+	if chainId == nil {
+		return "1/" + strings.ToLower(hash)
+	}
+	return fmt.Sprintf("%d/%s", *chainId, strings.ToLower(hash))
+}
+
+// returnedHash returns the transaction hash of an eth_sendRawTransaction
+// response, ok is false when it is not a 32 byte hash.
+func returnedHash(resp string) (hash string, ok bool) {
+	var respObj model.RPCResponse
+	if json.Unmarshal([]byte(resp), &respObj) != nil || json.Unmarshal(respObj.Result, &hash) != nil {
+		return
+	}
+	tmp, found := strings.CutPrefix(hash, "0x")
+	if !found || len(tmp) != 64 {
+		return
+	}
+	_, err := hex.DecodeString(tmp)
+	ok = err == nil
+	return
+}
+
+// rawTransactionHash returns the hash of the signed transaction of an
+// eth_sendRawTransaction request, the Keccak-256 of its encoding.
+func rawTransactionHash(request *model.RPCRequest) (hash string, err error) {
+	if len(request.Params) < 1 {
+		err = fmt.Errorf("missing signed transaction")
+		return
+	}
+	param, ok := request.Params[0].(string)
+	if !ok {
+		err = fmt.Errorf("invalid signed transaction")
+		return
+	}
+	tmp, _ := strings.CutPrefix(param, "0x")
+	data, err := hex.DecodeString(tmp)
+	if err != nil || len(data) < 1 {
+		err = fmt.Errorf("invalid signed transaction: %v", err)
+		return
+	}
+	hasher := sha3.NewLegacyKeccak256()
+	hasher.Write(data)
+	hash = "0x" + hex.EncodeToString(hasher.Sum(nil))
+	return
+}
@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jeffprestes/sjrpc/localcache"
+	"github.com/jeffprestes/sjrpc/model"
+)
+
+// Signed transactions of the EIP-155 example, as legacy and as dynamic fee
+// transactions.
+const (
+	legacyTx     = "0xf86c098504a817c800825208943535353535353535353535353535353535353535880de0b6b3a76400008025a028ef61340bd939bc2195fe537567866003e1a15d3c71ff63e1590620aa636276a067cbe9d8997f761aecb703304b3800ccf555c9f3dc64214b297fb1966a3b6d83"
+	dynamicFeeTx = "0x02f8720109847735940084b2d05e00825208943535353535353535353535353535353535353535880de0b6b3a764000080c001a028ef61340bd939bc2195fe537567866003e1a15d3c71ff63e1590620aa636276a067cbe9d8997f761aecb703304b3800ccf555c9f3dc64214b297fb1966a3b6d83"
+)
+
+func TestRawTransactionHash(t *testing.T) {
+	tests := []struct {
+		param any
+		hash  string
+	}{
+		{legacyTx, "0x33469b22e9f636356c4160a87eb19df52b7412e8eac32a4a55ffe88ea8350788"},
+		// the type byte is part of the hashed encoding
+		{dynamicFeeTx, "0xb8fb8726789455a6eb6d491156097313e41ed56ac4452d0576b3e78f1dec02cc"},
+		{"0x", ""},
+		{"0xzz", ""},
+		{42, ""},
+	}
+	for _, test := range tests {
+		hash, err := rawTransactionHash(&model.RPCRequest{Method: "eth_sendRawTransaction", Params: []any{test.param}})
+		if len(test.hash) < 1 {
+			if err == nil {
+				t.Errorf("%v: hash %s, want an error", test.param, hash)
+			}
+			continue
+		}
+		if err != nil || hash != test.hash {
+			t.Errorf("%v: hash %s (%v), want %s", test.param, hash, err, test.hash)
+		}
+	}
+	if _, err := rawTransactionHash(&model.RPCRequest{Method: "eth_sendRawTransaction"}); err == nil {
+		t.Error("no error without params")
+	}
+}
+
+func TestSendRawTransactionFollowsReturnedHash(t *testing.T) {
+	upstream := newFakeUpstream(t, 100)
+	// blob transactions in their network form hash differently
+	returned := fmt.Sprintf("0x%064x", 0xb10b)
+	upstream.results["eth_sendRawTransaction"] = returned
+	chainId := 424242
+	request := &model.RPCRequest{JsonRpcVersion: "2.0", ID: 1, Method: "eth_sendRawTransaction", Params: []any{dynamicFeeTx}}
+	if _, err := ProcessSendRawTransaction(context.Background(), request, &chainId, upstream.URL); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	computed, _ := rawTransactionHash(request)
+	defer localcache.PendingTransactions.Delete(pendingKey(&chainId, returned))
+	defer localcache.PendingTransactions.Delete(pendingKey(&chainId, computed))
+	if _, ok := localcache.PendingTransactions.Load(pendingKey(&chainId, returned)); !ok {
+		t.Error("the hash returned by upstream is not followed")
+	}
+	if _, ok := localcache.PendingTransactions.Load(pendingKey(&chainId, computed)); ok {
+		t.Error("the computed hash is followed instead of the returned one")
+	}
+
+	// without a valid hash from upstream the computed one is followed
+	upstream.results["eth_sendRawTransaction"] = "0x1234"
+	if _, err := ProcessSendRawTransaction(context.Background(), request, &chainId, upstream.URL); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if _, ok := localcache.PendingTransactions.Load(pendingKey(&chainId, computed)); !ok {
+		t.Error("the computed hash is not followed when upstream returns no hash")
+	}
+}
+
+func TestPendingPollBackoff(t *testing.T) {
+	upstream := newFakeUpstream(t, 100)
+	chainId := 434343
+	hash := fmt.Sprintf("0x%064x", 0x7e57)
+	key := pendingKey(&chainId, hash)
+	localcache.PendingTransactions.Store(key, model.PendingTransaction{Hash: hash, RpcUrl: upstream.URL, Submitted: time.Now(), Responses: map[string]string{}})
+	defer localcache.PendingTransactions.Delete(key)
+
+	ctx := context.Background()
+	request := &model.RPCRequest{JsonRpcVersion: "2.0", ID: 1, Method: "eth_getTransactionReceipt", Params: []any{hash}}
+	if _, ok := pendingResponse(ctx, request, &chainId, upstream.URL); ok {
+		t.Fatal("answered before upstream was polled")
+	}
+	// one poll per head at first, then waiting up to pendingMaxWait heads
+	resp := `{"jsonrpc":"2.0","id":1,"result":null}`
+	for checks, wait := range []uint64{1, 1, 1, 2, 4, 4} {
+		recordPoll(ctx, request, &chainId, upstream.URL, resp, false)
+		tmp, _ := localcache.PendingTransactions.Load(key)
+		pending := tmp.(model.PendingTransaction)
+		if pending.Checks != checks+1 || pending.NextCheck != upstream.head+wait {
+			t.Errorf("poll %d: checks %d, next check %d, want %d", checks+1, pending.Checks, pending.NextCheck, upstream.head+wait)
+		}
+		if got, ok := pendingResponse(ctx, request, &chainId, upstream.URL); !ok || got != resp {
+			t.Errorf("poll %d: the last response is not repeated before the next check", checks+1)
+		}
+	}
+
+	// polls are answered by upstream again once the head reaches the next check
+	tmp, _ := localcache.PendingTransactions.Load(key)
+	pending := tmp.(model.PendingTransaction)
+	pending.NextCheck = upstream.head
+	localcache.PendingTransactions.Store(key, pending)
+	if _, ok := pendingResponse(ctx, request, &chainId, upstream.URL); ok {
+		t.Error("answered from the cache at the next check")
+	}
+
+	// errors are not repeated
+	recordPoll(ctx, request, &chainId, upstream.URL, `{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"busy"}}`, false)
+	if tmp, _ := localcache.PendingTransactions.Load(key); tmp.(model.PendingTransaction).Checks != pending.Checks {
+		t.Error("an error response was recorded")
+	}
+
+	recordPoll(ctx, request, &chainId, upstream.URL, `{"jsonrpc":"2.0","id":1,"result":{}}`, true)
+	if _, ok := localcache.PendingTransactions.Load(key); ok {
+		t.Error("an included transaction is still followed")
+	}
+}
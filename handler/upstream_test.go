@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/jeffprestes/sjrpc/config"
+	"github.com/jeffprestes/sjrpc/database"
+	"github.com/jeffprestes/sjrpc/model"
+	"github.com/jeffprestes/sjrpc/tracker"
+)
+
+// fakeUpstream is a JSON-RPC server for a chain whose head is at block head.
+// It records the requests and their headers.
+type fakeUpstream struct {
+	*httptest.Server
+	head uint64
+	// results replaces the result of the methods it holds.
+	results map[string]any
+	// noFinalized answers the finalized and safe tags with null, as servers
+	// without them.
+	noFinalized bool
+	// transactions holds the hashes of the transactions of blocks, returned
+	// as transaction objects when the block is fetched with them.
+	transactions map[uint64][]string
+
+	mutex    sync.Mutex
+	requests []model.RPCRequest
+	headers  []http.Header
+}
+
+func newFakeUpstream(t *testing.T, head uint64) *fakeUpstream {
+	upstream := &fakeUpstream{head: head, results: make(map[string]any), transactions: make(map[uint64][]string)}
+	upstream.Server = httptest.NewServer(http.HandlerFunc(upstream.serve))
+	t.Cleanup(func() {
+		upstream.Close()
+		for _, headTracker := range tracker.EvictIdle(0, func(string) bool { return false }) {
+			watchedTrackers.Delete(headTracker)
+		}
+	})
+	return upstream
+}
+
+// follow configures the upstream as allowed, so its chain head is followed.
+func (u *fakeUpstream) follow(t *testing.T) {
+	cfg := config.Default()
+	cfg.RPCURL.Allow = []string{u.URL}
+	config.Set(cfg)
+	t.Cleanup(func() { config.Set(config.Default()) })
+}
+
+func (u *fakeUpstream) serve(w http.ResponseWriter, r *http.Request) {
+	var request model.RPCRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	u.mutex.Lock()
+	u.requests = append(u.requests, request)
+	u.headers = append(u.headers, r.Header.Clone())
+	u.mutex.Unlock()
+
+	var result any
+	if override, ok := u.results[request.Method]; ok {
+		json.NewEncoder(w).Encode(model.NewResultResponse(request.ID, override))
+		return
+	}
+	switch request.Method {
+	case "eth_getBlockByNumber":
+		number := u.head
+		tag, _ := request.Params[0].(string)
+		switch tag {
+		case "latest", "pending":
+		case "finalized", "safe":
+			if u.noFinalized {
+				json.NewEncoder(w).Encode(model.NewResultResponse(request.ID, nil))
+				return
+			}
+			number = u.head - 10
+		default:
+			number, _ = tracker.ParseHexUint64(tag)
+		}
+		result = model.BlockHeader{
+			Number:     fmt.Sprintf("0x%x", number),
+			Hash:       fmt.Sprintf("0x%064x", number),
+			ParentHash: fmt.Sprintf("0x%064x", number-1),
+			Timestamp:  "0x1",
+		}
+		if len(request.Params) > 1 && request.Params[1] == true && len(u.transactions[number]) > 0 {
+			txs := make([]map[string]string, 0, len(u.transactions[number]))
+			for _, hash := range u.transactions[number] {
+				txs = append(txs, map[string]string{"hash": hash, "blockNumber": fmt.Sprintf("0x%x", number), "blockHash": fmt.Sprintf("0x%064x", number)})
+			}
+			result = map[string]any{
+				"number":       fmt.Sprintf("0x%x", number),
+				"hash":         fmt.Sprintf("0x%064x", number),
+				"parentHash":   fmt.Sprintf("0x%064x", number-1),
+				"timestamp":    "0x1",
+				"transactions": txs,
+			}
+		}
+	case "eth_blockNumber":
+		result = fmt.Sprintf("0x%x", u.head)
+	case "eth_chainId":
+		result = "0x1"
+	case "eth_getLogs":
+		result = []any{}
+	default:
+		result = "0x0"
+	}
+	json.NewEncoder(w).Encode(model.NewResultResponse(request.ID, result))
+}
+
+// calls returns the requests received for method.
+func (u *fakeUpstream) calls(method string) (requests []model.RPCRequest) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	for _, request := range u.requests {
+		if request.Method == method {
+			requests = append(requests, request)
+		}
+	}
+	return
+}
+
+// useTestDB replaces the database with an empty one for the test.
+func useTestDB(t *testing.T) {
+	db, err := database.NewBadgerDB(t.TempDir())
+	if err != nil {
+		t.Fatalf("cannot open database: %v", err)
+	}
+	previous := database.DB
+	database.DB = db
+	t.Cleanup(func() {
+		database.DB = previous
+		db.Close()
+	})
+}
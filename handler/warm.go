@@ -0,0 +1,387 @@
+package handler
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/jeffprestes/sjrpc/config"
+	"github.com/jeffprestes/sjrpc/database"
+	"github.com/jeffprestes/sjrpc/logstore"
+	"github.com/jeffprestes/sjrpc/model"
+	"github.com/jeffprestes/sjrpc/upstream"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/time/rate"
+)
+
+// Data a warm job can fetch for each block.
+const (
+	WarmBlocks   = "blocks"
+	WarmReceipts = "receipts"
+	WarmLogs     = "logs"
+)
+
+// Warm job status.
+const (
+	WarmRunning  = "running"
+	WarmDone     = "done"
+	WarmFailed   = "failed"
+	WarmCanceled = "canceled"
+)
+
+const (
+	// Upstream requests per second of a warm job when not set.
+	defaultWarmRate = 10
+
+	// Path parameter of the warm job id.
+	ParamWarmJobId = "warmId"
+
+	// How often the progress of a running job is logged, in blocks.
+	warmLogInterval = 1000
+)
+
+// WarmJob fetches a block range from upstream into the cache. Its progress is
+// saved after every block, so a job started again with the same chain, range,
+// data and addresses continues where it stopped.
+type WarmJob struct {
+	ID                string    `json:"id"`
+	Chain             string    `json:"chain,omitempty"`
+	ChainID           *int      `json:"chainId,omitempty"`
+	From              uint64    `json:"from"`
+	To                uint64    `json:"to"`
+	Methods           []string  `json:"methods"`
+	Addresses         []string  `json:"addresses,omitempty"`
+	RequestsPerSecond float64   `json:"requestsPerSecond,omitempty"`
+	Next              uint64    `json:"next"`
+	Status            string    `json:"status"`
+	Error             string    `json:"error,omitempty"`
+	Updated           time.Time `json:"updated"`
+}
+
+// ErrWarmJobRunning is returned when starting a job already running.
+var ErrWarmJobRunning = errors.New("warm job already running")
+
+// running warm jobs, by id
+var warmJobs sync.Map
+
+// Prepare validates the job, resolves its chain and sets its id.
+func (job *WarmJob) Prepare() (err error) {
+	if job.To < job.From {
+		err = fmt.Errorf("invalid block range: %d - %d", job.From, job.To)
+		return
+	}
+	if len(job.Methods) < 1 {
+		job.Methods = []string{WarmBlocks}
+	}
+	for i, method := range job.Methods {
+		job.Methods[i] = strings.ToLower(strings.TrimSpace(method))
+		switch job.Methods[i] {
+		case WarmBlocks, WarmReceipts:
+		case WarmLogs:
+			if len(job.Addresses) < 1 {
+				err = fmt.Errorf("warming logs needs at least one address")
+				return
+			}
+		default:
+			err = fmt.Errorf("unknown warm method %q, use %s, %s or %s", method, WarmBlocks, WarmReceipts, WarmLogs)
+			return
+		}
+	}
+	sort.Strings(job.Methods)
+	for i, address := range job.Addresses {
+		job.Addresses[i] = strings.ToLower(strings.TrimSpace(address))
+	}
+	sort.Strings(job.Addresses)
+	if job.RequestsPerSecond <= 0 {
+		job.RequestsPerSecond = defaultWarmRate
+	}
+
+	if len(job.Chain) > 0 {
+		chain, ok := config.Get().ChainByName(job.Chain)
+		if !ok {
+			err = fmt.Errorf("unknown chain %q", job.Chain)
+			return
+		}
+		job.Chain = chain.Name
+		job.ChainID = &chain.ChainID
+	}
+	if len(job.upstream()) < 5 {
+		err = fmt.Errorf("no upstream server set for the warm job")
+		return
+	}
+
+	tmp, err := json.Marshal([]any{job.ChainID, job.From, job.To, job.Methods, job.Addresses})
+	if err != nil {
+		return
+	}
+	hash := blake2b.Sum256(tmp)
+	job.ID = hex.EncodeToString(hash[:8])
+	return
+}
+
+// upstream returns the upstream of the job chain, which is not saved with the
+// job as upstream URLs often hold API keys.
+func (job *WarmJob) upstream() string {
+	cfg := config.Get()
+	if job.ChainID != nil {
+		chain, ok := cfg.ChainByID(*job.ChainID)
+		if ok {
+			return chain.Upstreams[0]
+		}
+	}
+	return cfg.Upstream()
+}
+
+// RunWarmJob fetches the blocks of a prepared job, continuing from the
+// progress saved by a previous run. It returns when the job is finished or
+// ctx is canceled.
+func RunWarmJob(ctx context.Context, job *WarmJob) (err error) {
+	saved, found, err := loadWarmJob(job.ID)
+	if err != nil {
+		return
+	}
+	job.Next = job.From
+	if found {
+		if saved.Status == WarmDone {
+			*job = saved
+			return
+		}
+		if saved.Next > job.Next {
+			job.Next = saved.Next
+		}
+	}
+	job.Status = WarmRunning
+	job.Error = ""
+	saveWarmJob(job)
+
+	err = job.run(ctx)
+	switch {
+	case err == nil:
+		job.Status = WarmDone
+		slog.Info("warm job done", "job", job.ID, "from", job.From, "to", job.To)
+	case ctx.Err() != nil:
+		job.Status = WarmCanceled
+		slog.Info("warm job stopped", "job", job.ID, "next", job.Next)
+	default:
+		job.Status = WarmFailed
+		job.Error = err.Error()
+		slog.Error("warm job failed", "job", job.ID, "next", job.Next, "error", err)
+	}
+	saveWarmJob(job)
+	return
+}
+
+func (job *WarmJob) run(ctx context.Context) (err error) {
+	rpcUrl := job.upstream()
+	limiter := rate.NewLimiter(rate.Limit(job.RequestsPerSecond), 1)
+	chunkSize := model.GetCachePolicy().LogsChunkSize
+	logsTo := job.Next
+
+	for number := job.Next; number <= job.To; number++ {
+		for _, method := range job.Methods {
+			if method == WarmLogs && number < logsTo {
+				// already fetched with the previous blocks
+				continue
+			}
+			err = limiter.Wait(ctx)
+			if err != nil {
+				return
+			}
+			switch method {
+			case WarmBlocks:
+				err = job.warmBlock(ctx, rpcUrl, number)
+			case WarmReceipts:
+				_, err = StoreBlockReceipts(ctx, job.ChainID, rpcUrl, number)
+			case WarmLogs:
+				logsTo = number + chunkSize
+				if logsTo > job.To+1 {
+					logsTo = job.To + 1
+				}
+				err = job.warmLogs(ctx, rpcUrl, number, logsTo-1, chunkSize)
+			}
+			if err != nil {
+				return
+			}
+		}
+		job.Next = number + 1
+		saveWarmJob(job)
+		if (number-job.From+1)%warmLogInterval == 0 {
+			slog.Info("warm job progress", "job", job.ID, "cached", number-job.From+1, "blocks", job.To-job.From+1)
+		}
+		if number == job.To {
+			// avoids overflow when To is the largest block number
+			break
+		}
+	}
+	return
+}
+
+func (job *WarmJob) warmBlock(ctx context.Context, rpcUrl string, number uint64) (err error) {
+	var request model.RPCRequest
+	request.JsonRpcVersion = "2.0"
+	request.Method = "eth_getBlockByNumber"
+	request.ID = 1
+	request.Params = append(request.Params, fmt.Sprintf("0x%x", number), true)
+	resp, _, err := ProcessBlockRequest(ctx, &request, job.ChainID, rpcUrl)
+	if err != nil {
+		return
+	}
+	if model.IsResultNull(resp) {
+		err = fmt.Errorf("block %d is not available upstream", number)
+	}
+	return
+}
+
+func (job *WarmJob) warmLogs(ctx context.Context, rpcUrl string, from, to uint64, chunkSize uint64) (err error) {
+	headTracker := HeadTracker(rpcUrl)
+	latest, err := headTracker.Latest(ctx)
+	if err != nil {
+		return
+	}
+	finalized, err := headTracker.Finalized(ctx)
+	if err != nil {
+		return
+	}
+	addresses := make([]any, 0, len(job.Addresses))
+	for _, address := range job.Addresses {
+		addresses = append(addresses, address)
+	}
+	query := model.FilterQuery{
+		FromBlock: fmt.Sprintf("0x%x", from),
+		ToBlock:   fmt.Sprintf("0x%x", to),
+		Address:   addresses,
+	}
+	_, _, rpcErr, err := logstore.GetLogs(ctx, upstream.For(rpcUrl), job.ChainID, query, logstore.Blocks{Latest: latest.Number, Finalized: finalized}, chunkSize)
+	if err == nil && rpcErr != nil {
+		err = fmt.Errorf("eth_getLogs of blocks %d to %d: %s", from, to, rpcErr.Message)
+	}
+	return
+}
+
+// StartWarmJob runs a prepared job in background.
+func StartWarmJob(job WarmJob) (err error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	_, running := warmJobs.LoadOrStore(job.ID, cancel)
+	if running {
+		cancel()
+		err = ErrWarmJobRunning
+		return
+	}
+	go func() {
+		defer cancel()
+		defer warmJobs.Delete(job.ID)
+		RunWarmJob(ctx, &job)
+	}()
+	return
+}
+
+// ResumeWarmJobs starts again the jobs that were running when the server
+// stopped.
+func ResumeWarmJobs() {
+	jobs, err := listWarmJobs()
+	if err != nil {
+		slog.Error("error loading warm jobs", "error", err)
+		return
+	}
+	for _, job := range jobs {
+		if job.Status != WarmRunning {
+			continue
+		}
+		slog.Info("resuming warm job", "job", job.ID, "next", job.Next)
+		err = StartWarmJob(job)
+		if err != nil {
+			slog.Error("error resuming warm job", "job", job.ID, "error", err)
+		}
+	}
+}
+
+// WarmStartHandler starts the warm job described in the request body.
+func WarmStartHandler(echoCtx echo.Context) error {
+	var job WarmJob
+	err := json.NewDecoder(echoCtx.Request().Body).Decode(&job)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid warm job: %v", err))
+	}
+	err = job.Prepare()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	err = StartWarmJob(job)
+	if err == ErrWarmJobRunning {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+	job.Status = WarmRunning
+	return echoCtx.JSON(http.StatusAccepted, job)
+}
+
+// WarmListHandler returns every warm job with its progress.
+func WarmListHandler(echoCtx echo.Context) error {
+	jobs, err := listWarmJobs()
+	if err != nil {
+		return err
+	}
+	return echoCtx.JSON(http.StatusOK, jobs)
+}
+
+// WarmCancelHandler stops a running warm job. Its progress is kept.
+func WarmCancelHandler(echoCtx echo.Context) error {
+	id := echoCtx.Param(ParamWarmJobId)
+	cancel, ok := warmJobs.Load(id)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "warm job not running")
+	}
+	cancel.(context.CancelFunc)()
+	return echoCtx.NoContent(http.StatusNoContent)
+}
+
+func saveWarmJob(job *WarmJob) {
+	job.Updated = time.Now().UTC()
+	value, err := json.Marshal(job)
+	if err == nil {
+		err = database.DB.Update(database.WarmJobsNamespace, []byte(job.ID), value)
+	}
+	if err != nil {
+		slog.Error("error saving warm job", "job", job.ID, "error", err)
+	}
+}
+
+func loadWarmJob(id string) (job WarmJob, found bool, err error) {
+	value, err := database.DB.Get(database.WarmJobsNamespace, []byte(id))
+	if err == badger.ErrKeyNotFound {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal([]byte(value), &job)
+	found = err == nil
+	return
+}
+
+func listWarmJobs() (jobs []WarmJob, err error) {
+	jobs = make([]WarmJob, 0)
+	err = database.DB.Scan(database.WarmJobsNamespace, nil, lastKey, func(_, value []byte) error {
+		var job WarmJob
+		err := json.Unmarshal(value, &job)
+		if err != nil {
+			return err
+		}
+		jobs = append(jobs, job)
+		return nil
+	})
+	return
+}
@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jeffprestes/sjrpc/config"
+	"github.com/jeffprestes/sjrpc/tracker"
+)
+
+// useWarmUpstream makes the fake upstream the one of warm jobs.
+func useWarmUpstream(t *testing.T, upstream *fakeUpstream) {
+	cfg := config.Default()
+	cfg.Upstreams = []string{upstream.URL}
+	config.Set(cfg)
+	t.Cleanup(func() { config.Set(config.Default()) })
+}
+
+// warmedBlocks returns the numbers of the blocks fetched with their
+// transactions, as warm jobs do.
+func (u *fakeUpstream) warmedBlocks() (numbers []uint64) {
+	for _, request := range u.calls("eth_getBlockByNumber") {
+		if fullTx, _ := request.Params[1].(bool); fullTx {
+			number, _ := tracker.ParseHexUint64(request.Params[0].(string))
+			numbers = append(numbers, number)
+		}
+	}
+	return
+}
+
+func TestWarmJobResumesFromCheckpoint(t *testing.T) {
+	useTestDB(t)
+	upstream := newFakeUpstream(t, 100)
+	useWarmUpstream(t, upstream)
+
+	job := WarmJob{From: 90, To: 95, RequestsPerSecond: 1000}
+	if err := job.Prepare(); err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	// the server stopped while the job was running, after block 92
+	job.Next = 93
+	job.Status = WarmRunning
+	saveWarmJob(&job)
+
+	ResumeWarmJobs()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, running := warmJobs.Load(job.ID); !running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("resumed job still running")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	saved, found, err := loadWarmJob(job.ID)
+	if err != nil || !found || saved.Status != WarmDone || saved.Next != 96 {
+		t.Fatalf("job after resume %+v, %v, %v", saved, found, err)
+	}
+	if blocks := upstream.warmedBlocks(); len(blocks) != 3 || blocks[0] != 93 || blocks[2] != 95 {
+		t.Errorf("blocks fetched %v, want 93 to 95", blocks)
+	}
+
+	// a job done is not run again
+	if err = RunWarmJob(context.Background(), &job); err != nil || job.Status != WarmDone {
+		t.Errorf("job done run again: %v, status %s", err, job.Status)
+	}
+	if blocks := upstream.warmedBlocks(); len(blocks) != 3 {
+		t.Errorf("blocks fetched again %v", blocks)
+	}
+}
+
+func TestWarmJobRate(t *testing.T) {
+	useTestDB(t)
+	upstream := newFakeUpstream(t, 100)
+	useWarmUpstream(t, upstream)
+
+	job := WarmJob{From: 80, To: 89, RequestsPerSecond: 20}
+	if err := job.Prepare(); err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	start := time.Now()
+	if err := RunWarmJob(context.Background(), &job); err != nil || job.Status != WarmDone {
+		t.Fatalf("run: %v, status %s", err, job.Status)
+	}
+	// the first request is not delayed, the 9 others wait 50ms each
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("10 blocks fetched in %v at 20 requests per second", elapsed)
+	}
+	if blocks := upstream.warmedBlocks(); len(blocks) != 10 {
+		t.Errorf("%d blocks fetched, want 10", len(blocks))
+	}
+}
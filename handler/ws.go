@@ -0,0 +1,273 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeffprestes/sjrpc/config"
+	"github.com/jeffprestes/sjrpc/logging"
+	"github.com/jeffprestes/sjrpc/model"
+	"github.com/jeffprestes/sjrpc/subscription"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// Maximum number of messages of a WebSocket client processed at the same
+	// time.
+	wsMaxConcurrentMessages = 16
+
+	// Timeout to write a message to a WebSocket client.
+	wsWriteTimeout = 10 * time.Second
+)
+
+// IsWebSocketRequest reports if the request asks for a WebSocket upgrade.
+func IsWebSocketRequest(echoCtx echo.Context) bool {
+	return strings.EqualFold(echoCtx.Request().Header.Get(echo.HeaderUpgrade), "websocket")
+}
+
+// WebSocketHandler serves JSON-RPC over WebSocket on the same paths of
+// PostHandler. Calls go through the same cache of PostHandler, while
+// eth_subscribe subscriptions are shared among every client of the chain.
+func WebSocketHandler(echoCtx echo.Context) error {
+	userSelectedChainId, rpcUrl := CheckParams(echoCtx)
+	chain, routed, ok := RouteChain(echoCtx)
+	if routed && !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "unknown chain")
+	}
+	if len(rpcUrl) < 5 {
+		return fmt.Errorf("no upstream server set in command line, SJRPC_URL environment variable or query string")
+	}
+	err := checkUpstream(echoCtx.Request().Context(), rpcUrl)
+	if err != nil {
+		return err
+	}
+	err = checkKeyChain(echoCtx, userSelectedChainId, rpcUrl)
+	if err != nil {
+		return err
+	}
+	wsUrl := config.Get().WSUpstreamFor(rpcUrl)
+	if routed {
+		wsUrl = chain.WSUpstream()
+	}
+
+	firewall := NewFirewall(echoCtx, userSelectedChainId, rpcUrl)
+
+	server := websocket.Server{
+		// browsers send the Origin of the page opening the socket and CORS
+		// does not apply to upgrades, so the allowed origins are checked here
+		Handshake: func(_ *websocket.Config, req *http.Request) error {
+			origin := req.Header.Get(echo.HeaderOrigin)
+			if len(origin) > 0 && !config.Get().CORS.AllowsOrigin(origin) {
+				return fmt.Errorf("origin %s not allowed", origin)
+			}
+			return nil
+		},
+		Handler: func(ws *websocket.Conn) {
+			if maxSize := config.Get().Limits.MaxBodySize; maxSize > 0 {
+				ws.MaxPayloadBytes = int(maxSize)
+			}
+			client := &wsClient{
+				conn:          ws,
+				hub:           subscription.HubFor(wsUrl),
+				chainId:       userSelectedChainId,
+				rpcUrl:        rpcUrl,
+				firewall:      firewall,
+				subscriptions: make(map[string]bool),
+			}
+			client.serve()
+		},
+	}
+	server.ServeHTTP(echoCtx.Response(), echoCtx.Request())
+	return nil
+}
+
+type wsClient struct {
+	conn     *websocket.Conn
+	hub      *subscription.Hub
+	chainId  *int
+	rpcUrl   string
+	firewall *Firewall
+
+	writeMutex    sync.Mutex
+	mutex         sync.Mutex
+	subscriptions map[string]bool
+}
+
+func (client *wsClient) serve() {
+	defer client.close()
+	ctx, cancel := context.WithCancel(client.conn.Request().Context())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	slots := make(chan struct{}, wsMaxConcurrentMessages)
+	for {
+		var data []byte
+		err := websocket.Message.Receive(client.conn, &data)
+		if err == websocket.ErrFrameTooLarge {
+			// the rest of the message is skipped by the next Receive
+			client.send(bodyTooLarge())
+			continue
+		}
+		if err != nil {
+			return
+		}
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			client.handleMessage(ctx, data)
+		}()
+	}
+}
+
+func (client *wsClient) handleMessage(ctx context.Context, data []byte) {
+	// the restriction of an upstream given by the client is evicted when
+	// idle, so it is checked again
+	err := checkUpstream(ctx, client.rpcUrl)
+	if err != nil {
+		client.send(model.NewRawIDErrorResponse(nil, model.ErrCodeInternal, err.Error()))
+		return
+	}
+	if !client.firewall.allowCalls(1) {
+		client.send(model.NewRawIDErrorResponse(rawRequestID(data), model.ErrCodeLimitExceeded, errRateLimited.Message.(string)))
+		return
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '{' {
+		resp, exceeded := checkLimits(data)
+		if exceeded {
+			client.send(resp)
+			return
+		}
+		var request model.RPCRequest
+		err = json.Unmarshal(data, &request)
+		if err != nil {
+			client.send(model.NewRawIDErrorResponse(rawRequestID(data), model.ErrCodeParse, err.Error()))
+			return
+		}
+		resp, blocked := admit(&request, client.chainId, client.firewall)
+		if blocked {
+			client.send(resp)
+			return
+		}
+		switch request.Method {
+		case "eth_subscribe":
+			client.subscribe(&request)
+			return
+		case "eth_unsubscribe":
+			client.unsubscribe(&request)
+			return
+		}
+		var status CacheStatus
+		resp, _, err = ProcessRequest(withCacheStatus(ctx, &status), &request, client.chainId, client.rpcUrl)
+		if err != nil {
+			errResp := model.NewErrorResponse(request.ID, model.ErrCodeInternal, err.Error())
+			client.send(errResp.ToString())
+			return
+		}
+		client.send(RestoreOriginalId(&request, resp))
+		return
+	}
+
+	resp, _, err := ProcessRequests(ctx, data, client.chainId, client.rpcUrl, client.firewall)
+	if err == errRateLimited {
+		client.send(model.NewRawIDErrorResponse(nil, model.ErrCodeLimitExceeded, errRateLimited.Message.(string)))
+		return
+	}
+	if err != nil {
+		client.send(model.NewRawIDErrorResponse(nil, model.ErrCodeInternal, err.Error()))
+		return
+	}
+	client.send(resp)
+}
+
+func (client *wsClient) subscribe(request *model.RPCRequest) {
+	id, err := client.hub.Subscribe(request.Params, client.notify, client.dropped)
+	if err != nil {
+		logging.FromContext(client.conn.Request().Context()).Debug("eth_subscribe failed", "params", request.Params, "error", err)
+		resp := model.NewErrorResponse(request.ID, model.ErrCodeInvalidParams, err.Error())
+		client.send(resp.ToString())
+		return
+	}
+	client.mutex.Lock()
+	client.subscriptions[id] = true
+	client.mutex.Unlock()
+	resp := model.NewResultResponse(request.ID, id)
+	client.send(resp.ToString())
+}
+
+func (client *wsClient) unsubscribe(request *model.RPCRequest) {
+	var id string
+	if len(request.Params) > 0 {
+		id, _ = request.Params[0].(string)
+	}
+	client.mutex.Lock()
+	owned := client.subscriptions[id]
+	delete(client.subscriptions, id)
+	client.mutex.Unlock()
+
+	ok := owned && client.hub.Unsubscribe(id)
+	resp := model.NewResultResponse(request.ID, ok)
+	client.send(resp.ToString())
+}
+
+// notify sends a subscription notification to the client.
+func (client *wsClient) notify(subscriptionID string, result json.RawMessage) {
+	notification := struct {
+		Jsonrpc string `json:"jsonrpc"`
+		Method  string `json:"method"`
+		Params  struct {
+			Subscription string          `json:"subscription"`
+			Result       json.RawMessage `json:"result"`
+		} `json:"params"`
+	}{Jsonrpc: "2.0", Method: "eth_subscription"}
+	notification.Params.Subscription = subscriptionID
+	notification.Params.Result = result
+	tmp, err := json.Marshal(notification)
+	if err != nil {
+		return
+	}
+	client.send(string(tmp))
+}
+
+// dropped disconnects a client too slow to read its notifications.
+func (client *wsClient) dropped(subscriptionID string) {
+	client.mutex.Lock()
+	delete(client.subscriptions, subscriptionID)
+	client.mutex.Unlock()
+	client.conn.Close()
+}
+
+func (client *wsClient) send(msg string) {
+	client.writeMutex.Lock()
+	defer client.writeMutex.Unlock()
+	client.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	err := websocket.Message.Send(client.conn, msg)
+	if err != nil {
+		logging.FromContext(client.conn.Request().Context()).Debug("error writing to WebSocket client", "error", err)
+	}
+}
+
+// close removes every subscription of the client from the hub.
+func (client *wsClient) close() {
+	client.mutex.Lock()
+	ids := make([]string, 0, len(client.subscriptions))
+	for id := range client.subscriptions {
+		ids = append(ids, id)
+	}
+	client.subscriptions = make(map[string]bool)
+	client.mutex.Unlock()
+	for _, id := range ids {
+		client.hub.Unsubscribe(id)
+	}
+	client.conn.Close()
+}
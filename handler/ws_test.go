@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jeffprestes/sjrpc/config"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/net/websocket"
+)
+
+func TestWebSocketOrigin(t *testing.T) {
+	cfg := config.Default()
+	cfg.Upstreams = []string{"http://127.0.0.1:1"}
+	cfg.CORS.AllowOrigins = []string{"https://app.example.com"}
+	config.Set(cfg)
+	defer config.Set(config.Default())
+
+	e := echo.New()
+	e.GET("/", WebSocketHandler)
+	server := httptest.NewServer(e)
+	defer server.Close()
+	wsUrl := "ws" + strings.TrimPrefix(server.URL, "http") + "/"
+
+	_, err := websocket.Dial(wsUrl, "", "https://evil.example.com")
+	if err == nil {
+		t.Fatal("socket opened from an origin not allowed")
+	}
+	conn, err := websocket.Dial(wsUrl, "", "https://app.example.com")
+	if err != nil {
+		t.Fatalf("socket from an allowed origin: %v", err)
+	}
+	conn.Close()
+}
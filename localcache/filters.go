@@ -0,0 +1,6 @@
+package localcache
+
+import "sync"
+
+// Filters holds the *model.Filter installed by clients, by filter id.
+var Filters sync.Map
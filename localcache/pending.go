@@ -0,0 +1,7 @@
+package localcache
+
+import "sync"
+
+// PendingTransactions holds the model.PendingTransaction sent through sjrpc
+// that are not in a block yet, by chain and transaction hash.
+var PendingTransactions sync.Map
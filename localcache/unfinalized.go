@@ -0,0 +1,8 @@
+package localcache
+
+import "sync"
+
+// UnfinalizedRequests holds the model.UnfinalizedRequest whose block is not
+// final yet, by request hash. They are moved to the database once their block
+// is final and removed if their block is reorged out.
+var UnfinalizedRequests sync.Map
@@ -0,0 +1,139 @@
+// Package logging sets up the structured logs of sjrpc, keeps the logger of
+// each request in its context and hides the credentials found in URLs.
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces the credentials found in URLs.
+const Redacted = "REDACTED"
+
+// minKeyLength is the length from which URL path segments made of letters,
+// digits, - and _, with both letters and digits, are taken as API keys.
+const minKeyLength = 20
+
+var (
+	level      slog.LevelVar
+	setupMutex sync.Mutex
+	configured bool
+	jsonInUse  bool
+)
+
+// Setup makes the default logger, also used by the log package, write the
+// records of levelName and above to stderr, as JSON or as key=value text. The
+// level of loggers already made changes too, their format does not.
+func Setup(levelName string, json bool) (err error) {
+	var lvl slog.Level
+	err = lvl.UnmarshalText([]byte(levelName))
+	if err != nil {
+		return
+	}
+	setupMutex.Lock()
+	defer setupMutex.Unlock()
+	level.Set(lvl)
+	if configured && json == jsonInUse {
+		return
+	}
+	opts := &slog.HandlerOptions{Level: &level}
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, opts)
+	if json {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+	configured, jsonInUse = true, json
+	return
+}
+
+type loggerKey struct{}
+
+// With returns a copy of ctx whose logger adds args to its records.
+func With(ctx context.Context, args ...any) context.Context {
+	return context.WithValue(ctx, loggerKey{}, FromContext(ctx).With(args...))
+}
+
+// FromContext returns the logger of ctx, the default logger when it has none.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// RedactURL hides the credentials of rawUrl: its password, the values of
+// query parameters named like keys or tokens, the path segment after /key/
+// and path segments long enough to be API keys, as the ones of Infura and
+// Alchemy. Upstream URLs given in the query string are redacted too.
+func RedactURL(rawUrl string) string {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil {
+		return Redacted
+	}
+	if parsed.User != nil {
+		// a user alone is the key of some providers
+		if _, hasPassword := parsed.User.Password(); hasPassword {
+			parsed.User = url.UserPassword(parsed.User.Username(), Redacted)
+		} else {
+			parsed.User = url.User(Redacted)
+		}
+	}
+
+	segments := strings.Split(parsed.Path, "/")
+	for i, segment := range segments {
+		if (i > 0 && segments[i-1] == "key" && len(segment) > 0) || isKeyLike(segment) {
+			segments[i] = Redacted
+		}
+	}
+	parsed.Path = strings.Join(segments, "/")
+	parsed.RawPath = ""
+
+	if len(parsed.RawQuery) > 0 {
+		query := parsed.Query()
+		for name, values := range query {
+			lower := strings.ToLower(name)
+			for i, value := range values {
+				switch {
+				case lower == "rpcurl" || lower == "rpc_url":
+					values[i] = RedactURL(value)
+				case isSecretName(lower):
+					values[i] = Redacted
+				}
+			}
+		}
+		parsed.RawQuery = query.Encode()
+	}
+	return parsed.String()
+}
+
+func isSecretName(name string) bool {
+	for _, word := range []string{"key", "token", "secret", "password", "auth"} {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+func isKeyLike(segment string) bool {
+	if len(segment) < minKeyLength {
+		return false
+	}
+	hasLetter, hasDigit := false, false
+	for _, c := range segment {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+			hasLetter = true
+		case c >= '0' && c <= '9':
+			hasDigit = true
+		case c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return hasLetter && hasDigit
+}
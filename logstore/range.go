@@ -0,0 +1,72 @@
+package logstore
+
+import "sort"
+
+// Range is a block range, both ends included.
+type Range struct {
+	From uint64 `json:"from"`
+	To   uint64 `json:"to"`
+}
+
+// Missing returns the parts of the range from-to not covered by ranges.
+// ranges must be sorted and not overlapping, as returned by Add.
+func Missing(ranges []Range, from, to uint64) (gaps []Range) {
+	next := from
+	for _, item := range ranges {
+		if item.To < next {
+			continue
+		}
+		if item.From > to {
+			break
+		}
+		if item.From > next {
+			gaps = append(gaps, Range{From: next, To: item.From - 1})
+		}
+		if item.To >= to {
+			return
+		}
+		next = item.To + 1
+	}
+	if next <= to {
+		gaps = append(gaps, Range{From: next, To: to})
+	}
+	return
+}
+
+// Add returns ranges with newRange added, sorted and with overlapping or
+// adjacent ranges merged.
+func Add(ranges []Range, newRange Range) (merged []Range) {
+	all := append(append([]Range{}, ranges...), newRange)
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].From < all[j].From
+	})
+	for _, item := range all {
+		last := len(merged) - 1
+		if last >= 0 && item.From <= merged[last].To+1 {
+			if item.To > merged[last].To {
+				merged[last].To = item.To
+			}
+			continue
+		}
+		merged = append(merged, item)
+	}
+	return
+}
+
+// Split divides r in consecutive ranges of at most size blocks.
+func Split(r Range, size uint64) (chunks []Range) {
+	if size < 1 {
+		size = 1
+	}
+	for from := r.From; from <= r.To; from += size {
+		to := from + size - 1
+		if to > r.To || to < from {
+			to = r.To
+		}
+		chunks = append(chunks, Range{From: from, To: to})
+		if to == r.To {
+			break
+		}
+	}
+	return
+}
@@ -0,0 +1,75 @@
+package logstore
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMissing(t *testing.T) {
+	ranges := []Range{{From: 10, To: 19}, {From: 30, To: 39}}
+	tests := []struct {
+		from, to uint64
+		want     []Range
+	}{
+		{0, 5, []Range{{0, 5}}},
+		{12, 18, nil},
+		{10, 39, []Range{{20, 29}}},
+		{5, 45, []Range{{5, 9}, {20, 29}, {40, 45}}},
+		{15, 35, []Range{{20, 29}}},
+		{25, 32, []Range{{25, 29}}},
+		{40, 50, []Range{{40, 50}}},
+	}
+	for _, test := range tests {
+		got := Missing(ranges, test.from, test.to)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Missing(%d, %d) = %v, want %v", test.from, test.to, got, test.want)
+		}
+	}
+	if got := Missing(nil, 3, 7); !reflect.DeepEqual(got, []Range{{3, 7}}) {
+		t.Errorf("Missing of no ranges = %v", got)
+	}
+}
+
+func TestAdd(t *testing.T) {
+	ranges := []Range{{From: 10, To: 19}, {From: 30, To: 39}}
+	tests := []struct {
+		add  Range
+		want []Range
+	}{
+		{Range{0, 5}, []Range{{0, 5}, {10, 19}, {30, 39}}},
+		{Range{0, 9}, []Range{{0, 19}, {30, 39}}},
+		{Range{20, 29}, []Range{{10, 39}}},
+		{Range{15, 32}, []Range{{10, 39}}},
+		{Range{12, 14}, []Range{{10, 19}, {30, 39}}},
+		{Range{41, 50}, []Range{{10, 19}, {30, 39}, {41, 50}}},
+	}
+	for _, test := range tests {
+		got := Add(ranges, test.add)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Add(%v) = %v, want %v", test.add, got, test.want)
+		}
+	}
+	if !reflect.DeepEqual(ranges, []Range{{From: 10, To: 19}, {From: 30, To: 39}}) {
+		t.Errorf("Add modified its argument: %v", ranges)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		r    Range
+		size uint64
+		want []Range
+	}{
+		{Range{0, 9}, 5, []Range{{0, 4}, {5, 9}}},
+		{Range{0, 10}, 5, []Range{{0, 4}, {5, 9}, {10, 10}}},
+		{Range{3, 3}, 5, []Range{{3, 3}}},
+		{Range{1, 2}, 0, []Range{{1, 1}, {2, 2}}},
+		{Range{^uint64(0) - 2, ^uint64(0)}, 2, []Range{{^uint64(0) - 2, ^uint64(0) - 1}, {^uint64(0), ^uint64(0)}}},
+	}
+	for _, test := range tests {
+		got := Split(test.r, test.size)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Split(%v, %d) = %v, want %v", test.r, test.size, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package logstore
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/jeffprestes/sjrpc/database"
+	"github.com/jeffprestes/sjrpc/model"
+	"github.com/jeffprestes/sjrpc/tracker"
+	"github.com/jeffprestes/sjrpc/upstream"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Log is the part of a log entry the store needs to index it.
+type Log struct {
+	BlockNumber string `json:"blockNumber"`
+}
+
+// Blocks bounds the range of a query.
+type Blocks struct {
+	Latest    uint64
+	Finalized uint64
+}
+
+// keyMutex serializes the updates of the same filter. users counts the
+// fills holding or waiting for it, so it is removed when the last one ends.
+type keyMutex struct {
+	sync.Mutex
+	users int
+}
+
+var (
+	keyMutexes      = make(map[string]*keyMutex)
+	keyMutexesMutex sync.Mutex
+)
+
+// GetLogs answers the eth_getLogs query. Logs of finalized blocks are kept in
+// the database by filter and block number; only the block ranges never
+// fetched before are requested from upstream, in chunks of at most chunkSize
+// blocks. Logs of blocks after the finalized one are always fetched from
+// upstream. fetched reports if upstream was called.
+func GetLogs(ctx context.Context, transport upstream.Transport, chainId *int, query model.FilterQuery, blocks Blocks, chunkSize uint64) (logs []json.RawMessage, fetched bool, rpcErr *model.RPCError, err error) {
+	from, err := blockNumber(query.FromBlock, blocks)
+	if err != nil {
+		rpcErr = &model.RPCError{Code: model.ErrCodeInvalidParams, Message: err.Error()}
+		err = nil
+		return
+	}
+	to, err := blockNumber(query.ToBlock, blocks)
+	if err != nil {
+		rpcErr = &model.RPCError{Code: model.ErrCodeInvalidParams, Message: err.Error()}
+		err = nil
+		return
+	}
+	if from > to {
+		rpcErr = &model.RPCError{Code: model.ErrCodeInvalidParams, Message: "invalid block range params"}
+		return
+	}
+	logs = make([]json.RawMessage, 0)
+
+	if from <= blocks.Finalized {
+		storedTo := to
+		if storedTo > blocks.Finalized {
+			storedTo = blocks.Finalized
+		}
+		var key string
+		key, err = filterKey(chainId, query)
+		if err != nil {
+			return
+		}
+		fetched, rpcErr, err = fill(ctx, transport, key, query, Range{From: from, To: storedTo}, chunkSize)
+		if err != nil || rpcErr != nil {
+			return
+		}
+		logs, err = read(ctx, key, Range{From: from, To: storedTo})
+		if err != nil {
+			return
+		}
+		from = storedTo + 1
+	}
+
+	if from <= to {
+		var tail []json.RawMessage
+		for _, chunk := range Split(Range{From: from, To: to}, chunkSize) {
+			tail, rpcErr, err = fetch(ctx, transport, query, chunk)
+			if err != nil || rpcErr != nil {
+				return
+			}
+			logs = append(logs, tail...)
+		}
+		fetched = true
+	}
+	return
+}
+
+// fill fetches and stores the parts of r missing in the database.
+func fill(ctx context.Context, transport upstream.Transport, key string, query model.FilterQuery, r Range, chunkSize uint64) (fetched bool, rpcErr *model.RPCError, err error) {
+	lockKey(key)
+	defer unlockKey(key)
+
+	ranges, err := loadRanges(ctx, key)
+	if err != nil {
+		return
+	}
+	for _, gap := range Missing(ranges, r.From, r.To) {
+		for _, chunk := range Split(gap, chunkSize) {
+			var logs []json.RawMessage
+			logs, rpcErr, err = fetch(ctx, transport, query, chunk)
+			if err != nil || rpcErr != nil {
+				return
+			}
+			fetched = true
+			err = store(ctx, key, logs)
+			if err != nil {
+				return
+			}
+			// saved after every chunk so an interrupted fill is resumed
+			ranges = Add(ranges, chunk)
+			err = saveRanges(ctx, key, ranges)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// fetch requests the logs of the range from upstream. Ranges rejected by the
+// upstream server for being too large are split in halves.
+func fetch(ctx context.Context, transport upstream.Transport, query model.FilterQuery, r Range) (logs []json.RawMessage, rpcErr *model.RPCError, err error) {
+	query.BlockHash = ""
+	query.FromBlock = fmt.Sprintf("0x%x", r.From)
+	query.ToBlock = fmt.Sprintf("0x%x", r.To)
+	var request model.RPCRequest
+	request.JsonRpcVersion = "2.0"
+	request.Method = "eth_getLogs"
+	request.ID = 1
+	request.Params = append(request.Params, query)
+	resp, err := transport.Call(ctx, &request)
+	if err != nil {
+		return
+	}
+
+	var respObj model.RPCResponse
+	err = json.Unmarshal([]byte(resp), &respObj)
+	if err != nil {
+		return
+	}
+	if respObj.Error != nil {
+		if r.From == r.To || !isRangeError(respObj.Error) {
+			rpcErr = respObj.Error
+			return
+		}
+		middle := r.From + (r.To-r.From)/2
+		logs, rpcErr, err = fetch(ctx, transport, query, Range{From: r.From, To: middle})
+		if err != nil || rpcErr != nil {
+			return
+		}
+		var second []json.RawMessage
+		second, rpcErr, err = fetch(ctx, transport, query, Range{From: middle + 1, To: r.To})
+		logs = append(logs, second...)
+		return
+	}
+	err = json.Unmarshal(respObj.Result, &logs)
+	return
+}
+
+func lockKey(key string) {
+	keyMutexesMutex.Lock()
+	mutex, ok := keyMutexes[key]
+	if !ok {
+		mutex = &keyMutex{}
+		keyMutexes[key] = mutex
+	}
+	mutex.users++
+	keyMutexesMutex.Unlock()
+	mutex.Lock()
+}
+
+func unlockKey(key string) {
+	keyMutexesMutex.Lock()
+	defer keyMutexesMutex.Unlock()
+	mutex := keyMutexes[key]
+	mutex.Unlock()
+	mutex.users--
+	if mutex.users < 1 {
+		delete(keyMutexes, key)
+	}
+}
+
+var (
+	// Messages of providers rejecting a block range or too many results.
+	rangeErrorHints = []string{
+		"block range",
+		"blocks range",
+		"query returned more than",
+		"response size exceeded",
+		"range is too large",
+		"range too large",
+	}
+	// Messages of rate limit errors, which may also mention limits or
+	// share the -32005 code, but must not be retried with more requests.
+	rateLimitHints = []string{"rate limit", "too many requests", "429", "request count", "capacity"}
+)
+
+// isRangeError reports if the upstream error asks for a smaller block range
+// or returned too many results.
+func isRangeError(rpcErr *model.RPCError) bool {
+	message := strings.ToLower(rpcErr.Message)
+	for _, hint := range rateLimitHints {
+		if strings.Contains(message, hint) {
+			return false
+		}
+	}
+	for _, hint := range rangeErrorHints {
+		if strings.Contains(message, hint) {
+			return true
+		}
+	}
+	// -32005 is the generic limit exceeded code of EIP-1474
+	return rpcErr.Code == -32005 && strings.Contains(message, "range")
+}
+
+// store saves the logs grouped by block number.
+func store(ctx context.Context, key string, logs []json.RawMessage) (err error) {
+	byBlock := make(map[uint64][]json.RawMessage)
+	for _, item := range logs {
+		var entry Log
+		err = json.Unmarshal(item, &entry)
+		if err != nil {
+			return
+		}
+		var number uint64
+		number, err = tracker.ParseHexUint64(entry.BlockNumber)
+		if err != nil {
+			err = fmt.Errorf("invalid log block number %q: %w", entry.BlockNumber, err)
+			return
+		}
+		byBlock[number] = append(byBlock[number], item)
+	}
+	items := make([]database.Item, 0, len(byBlock))
+	for number, blockLogs := range byBlock {
+		var value []byte
+		value, err = json.Marshal(blockLogs)
+		if err != nil {
+			return
+		}
+		items = append(items, database.Item{Key: blockKey(key, number), Value: value})
+	}
+	if len(items) < 1 {
+		return
+	}
+	err = database.Traced(ctx).UpdateMany(database.LogsNamespace, items)
+	return
+}
+
+// read returns the stored logs of the range, ordered by block.
+func read(ctx context.Context, key string, r Range) (logs []json.RawMessage, err error) {
+	logs = make([]json.RawMessage, 0)
+	err = database.Traced(ctx).Scan(database.LogsNamespace, blockKey(key, r.From), blockKey(key, r.To), func(_, value []byte) error {
+		var blockLogs []json.RawMessage
+		err := json.Unmarshal(value, &blockLogs)
+		if err != nil {
+			return err
+		}
+		logs = append(logs, blockLogs...)
+		return nil
+	})
+	return
+}
+
+func loadRanges(ctx context.Context, key string) (ranges []Range, err error) {
+	value, err := database.Traced(ctx).Get(database.LogRangesNamespace, []byte(key))
+	if err == badger.ErrKeyNotFound {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal([]byte(value), &ranges)
+	return
+}
+
+func saveRanges(ctx context.Context, key string, ranges []Range) (err error) {
+	value, err := json.Marshal(ranges)
+	if err != nil {
+		return
+	}
+	err = database.Traced(ctx).Update(database.LogRangesNamespace, []byte(key), value)
+	return
+}
+
+func blockKey(key string, number uint64) []byte {
+	tmp := make([]byte, 0, len(key)+9)
+	tmp = append(tmp, key...)
+	tmp = append(tmp, '/')
+	return binary.BigEndian.AppendUint64(tmp, number)
+}
+
+// blockNumber converts the block number or tag of a query. Missing blocks
+// mean latest, as in eth_getLogs.
+func blockNumber(tag string, blocks Blocks) (number uint64, err error) {
+	switch strings.ToLower(tag) {
+	case "", "latest", "pending":
+		number = blocks.Latest
+	case "safe", "finalized":
+		number = blocks.Finalized
+	case "earliest":
+		number = 0
+	default:
+		number, err = tracker.ParseHexUint64(tag)
+		if err != nil {
+			err = fmt.Errorf("invalid block number: %s", tag)
+		}
+	}
+	return
+}
+
+// filterKey identifies the address and topics filter of a query, ignoring
+// the case and order of the addresses and of the topics of each position.
+func filterKey(chainId *int, query model.FilterQuery) (key string, err error) {
+	var filter struct {
+		ChainId   *int       `json:"c"`
+		Addresses []string   `json:"a"`
+		Topics    [][]string `json:"t"`
+	}
+	filter.ChainId = chainId
+	filter.Addresses = normalize(query.Address)
+	for _, position := range query.Topics {
+		filter.Topics = append(filter.Topics, normalize(position))
+	}
+	for len(filter.Topics) > 0 && filter.Topics[len(filter.Topics)-1] == nil {
+		filter.Topics = filter.Topics[:len(filter.Topics)-1]
+	}
+	tmp, err := json.Marshal(filter)
+	if err != nil {
+		return
+	}
+	hash := blake2b.Sum256(tmp)
+	key = hex.EncodeToString(hash[:])
+	return
+}
+
+// normalize returns the sorted lower case values of a string or list of
+// strings. nil means any value.
+func normalize(value any) (values []string) {
+	switch tmp := value.(type) {
+	case string:
+		values = []string{strings.ToLower(tmp)}
+	case []any:
+		seen := make(map[string]bool)
+		for _, item := range tmp {
+			str, ok := item.(string)
+			if !ok {
+				// a null in the list matches any value
+				return nil
+			}
+			str = strings.ToLower(str)
+			if !seen[str] {
+				seen[str] = true
+				values = append(values, str)
+			}
+		}
+		sort.Strings(values)
+	}
+	return
+}
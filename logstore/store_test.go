@@ -0,0 +1,126 @@
+package logstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jeffprestes/sjrpc/model"
+	"github.com/jeffprestes/sjrpc/tracker"
+)
+
+// limitedTransport answers eth_getLogs with the error message for ranges
+// larger than maxRange blocks, and with one log per block otherwise.
+type limitedTransport struct {
+	maxRange uint64
+	message  string
+	calls    []Range
+}
+
+func (t *limitedTransport) Call(_ context.Context, request *model.RPCRequest) (resp string, err error) {
+	query := request.Params[0].(model.FilterQuery)
+	var r Range
+	r.From, _ = tracker.ParseHexUint64(query.FromBlock)
+	r.To, _ = tracker.ParseHexUint64(query.ToBlock)
+	t.calls = append(t.calls, r)
+	respObj := model.NewErrorResponse(request.ID, -32005, t.message)
+	if r.To-r.From+1 <= t.maxRange {
+		logs := make([]Log, 0)
+		for number := r.From; number <= r.To; number++ {
+			logs = append(logs, Log{BlockNumber: fmt.Sprintf("0x%x", number)})
+		}
+		respObj = model.NewResultResponse(request.ID, logs)
+	}
+	resp = respObj.ToString()
+	return
+}
+
+func (t *limitedTransport) URL() string  { return "http://limited.example.com" }
+func (t *limitedTransport) Close() error { return nil }
+
+func TestFetchSplitsRange(t *testing.T) {
+	transport := &limitedTransport{maxRange: 3, message: "query returned more than 10000 results"}
+	logs, rpcErr, err := fetch(context.Background(), transport, model.FilterQuery{}, Range{From: 1, To: 10})
+	if err != nil || rpcErr != nil {
+		t.Fatalf("fetch failed: %v %v", err, rpcErr)
+	}
+	if len(logs) != 10 {
+		t.Fatalf("%d logs, want 10", len(logs))
+	}
+	for i, item := range logs {
+		var entry Log
+		json.Unmarshal(item, &entry)
+		if entry.BlockNumber != fmt.Sprintf("0x%x", i+1) {
+			t.Errorf("log %d of block %s", i, entry.BlockNumber)
+		}
+	}
+}
+
+func TestFetchDoesNotSplitOnRateLimit(t *testing.T) {
+	transport := &limitedTransport{maxRange: 3, message: "daily request count exceeded, request rate limited"}
+	_, rpcErr, err := fetch(context.Background(), transport, model.FilterQuery{}, Range{From: 1, To: 10})
+	if err != nil {
+		t.Fatalf("fetch failed: %v", err)
+	}
+	if rpcErr == nil || rpcErr.Code != -32005 {
+		t.Errorf("error = %v, want the rate limit error", rpcErr)
+	}
+	if len(transport.calls) != 1 {
+		t.Errorf("%d upstream calls, want 1", len(transport.calls))
+	}
+}
+
+func TestIsRangeError(t *testing.T) {
+	tests := []struct {
+		code    int
+		message string
+		want    bool
+	}{
+		{-32005, "query returned more than 10000 results", true},
+		{-32602, "Log response size exceeded. You can make eth_getLogs requests with up to a 2K block range", true},
+		{-32000, "block range is too wide", true},
+		{-32000, "exceed maximum block range: 5000", true},
+		{-32005, "logs range is too large", true},
+		{-32005, "daily request count exceeded, request rate limited", false},
+		{-32005, "limit exceeded", false},
+		{-32000, "Too Many Requests", false},
+		{429, "rate limit exceeded for block range queries", false},
+		{-32000, "max fee per gas less than block base fee", false},
+		{-32000, "execution reverted", false},
+	}
+	for _, test := range tests {
+		got := isRangeError(&model.RPCError{Code: test.code, Message: test.message})
+		if got != test.want {
+			t.Errorf("isRangeError(%d, %q) = %v, want %v", test.code, test.message, got, test.want)
+		}
+	}
+}
+
+func TestKeyMutexesRemoved(t *testing.T) {
+	var wg sync.WaitGroup
+	var running atomic.Int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lockKey("key")
+			defer unlockKey("key")
+			if running.Add(1) > 1 {
+				t.Error("two fills of the same key at once")
+			}
+			runtime.Gosched()
+			running.Add(-1)
+		}()
+	}
+	wg.Wait()
+
+	keyMutexesMutex.Lock()
+	defer keyMutexesMutex.Unlock()
+	if len(keyMutexes) != 0 {
+		t.Errorf("%d key mutexes left", len(keyMutexes))
+	}
+}
@@ -10,45 +10,25 @@ type BlockByNumberResponse struct {
 	Jsonrpc string `json:"jsonrpc"`
 	ID      int    `json:"id"`
 	Result  struct {
-		BaseFeePerGas   string `json:"baseFeePerGas"`
-		Difficulty      string `json:"difficulty"`
-		ExtraData       string `json:"extraData"`
-		GasLimit        string `json:"gasLimit"`
-		GasUsed         string `json:"gasUsed"`
-		Hash            string `json:"hash"`
-		LogsBloom       string `json:"logsBloom"`
-		Miner           string `json:"miner"`
-		MixHash         string `json:"mixHash"`
-		Nonce           string `json:"nonce"`
-		Number          string `json:"number"`
-		ParentHash      string `json:"parentHash"`
-		ReceiptsRoot    string `json:"receiptsRoot"`
-		Sha3Uncles      string `json:"sha3Uncles"`
-		Size            string `json:"size"`
-		StateRoot       string `json:"stateRoot"`
-		Timestamp       string `json:"timestamp"`
-		TotalDifficulty string `json:"totalDifficulty"`
-		Transactions    []struct {
-			AccessList           []interface{} `json:"accessList,omitempty"`
-			BlockHash            string        `json:"blockHash"`
-			BlockNumber          string        `json:"blockNumber"`
-			ChainID              string        `json:"chainId"`
-			From                 string        `json:"from"`
-			Gas                  string        `json:"gas"`
-			GasPrice             string        `json:"gasPrice"`
-			Hash                 string        `json:"hash"`
-			Input                string        `json:"input"`
-			MaxFeePerGas         string        `json:"maxFeePerGas,omitempty"`
-			MaxPriorityFeePerGas string        `json:"maxPriorityFeePerGas,omitempty"`
-			Nonce                string        `json:"nonce"`
-			R                    string        `json:"r"`
-			S                    string        `json:"s"`
-			To                   string        `json:"to"`
-			TransactionIndex     string        `json:"transactionIndex"`
-			Type                 string        `json:"type"`
-			V                    string        `json:"v"`
-			Value                string        `json:"value"`
-		} `json:"transactions"`
+		BaseFeePerGas    string        `json:"baseFeePerGas"`
+		Difficulty       string        `json:"difficulty"`
+		ExtraData        string        `json:"extraData"`
+		GasLimit         string        `json:"gasLimit"`
+		GasUsed          string        `json:"gasUsed"`
+		Hash             string        `json:"hash"`
+		LogsBloom        string        `json:"logsBloom"`
+		Miner            string        `json:"miner"`
+		MixHash          string        `json:"mixHash"`
+		Nonce            string        `json:"nonce"`
+		Number           string        `json:"number"`
+		ParentHash       string        `json:"parentHash"`
+		ReceiptsRoot     string        `json:"receiptsRoot"`
+		Sha3Uncles       string        `json:"sha3Uncles"`
+		Size             string        `json:"size"`
+		StateRoot        string        `json:"stateRoot"`
+		Timestamp        string        `json:"timestamp"`
+		TotalDifficulty  string        `json:"totalDifficulty"`
+		Transactions     []Transaction `json:"transactions"`
 		TransactionsRoot string        `json:"transactionsRoot"`
 		Uncles           []interface{} `json:"uncles"`
 		Withdrawals      []struct {
@@ -60,3 +40,42 @@ type BlockByNumberResponse struct {
 		WithdrawalsRoot string `json:"withdrawalsRoot"`
 	} `json:"result"`
 }
+
+// Transaction is a transaction object of a block fetched with full
+// transactions, or of eth_getTransactionByHash.
+type Transaction struct {
+	AccessList           []interface{} `json:"accessList,omitempty"`
+	BlockHash            string        `json:"blockHash"`
+	BlockNumber          string        `json:"blockNumber"`
+	ChainID              string        `json:"chainId"`
+	From                 string        `json:"from"`
+	Gas                  string        `json:"gas"`
+	GasPrice             string        `json:"gasPrice"`
+	Hash                 string        `json:"hash"`
+	Input                string        `json:"input"`
+	MaxFeePerGas         string        `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas string        `json:"maxPriorityFeePerGas,omitempty"`
+	Nonce                string        `json:"nonce"`
+	R                    string        `json:"r"`
+	S                    string        `json:"s"`
+	To                   string        `json:"to"`
+	TransactionIndex     string        `json:"transactionIndex"`
+	Type                 string        `json:"type"`
+	V                    string        `json:"v"`
+	Value                string        `json:"value"`
+}
+
+// BlockHeader holds the block fields sjrpc needs to follow the chain head.
+// It decodes eth_getBlockByNumber results and newHeads notifications.
+type BlockHeader struct {
+	Number     string `json:"number"`
+	Hash       string `json:"hash"`
+	ParentHash string `json:"parentHash"`
+	Timestamp  string `json:"timestamp"`
+}
+
+type BlockHeaderResponse struct {
+	Jsonrpc string       `json:"jsonrpc"`
+	ID      int          `json:"id"`
+	Result  *BlockHeader `json:"result"`
+}
@@ -0,0 +1,62 @@
+package model
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Filter kinds.
+const (
+	LogFilter   = "logs"
+	BlockFilter = "blocks"
+)
+
+// FilterQuery is the eth_newFilter and eth_getLogs filter object.
+type FilterQuery struct {
+	BlockHash string `json:"blockHash,omitempty"`
+	FromBlock string `json:"fromBlock,omitempty"`
+	ToBlock   string `json:"toBlock,omitempty"`
+	Address   any    `json:"address,omitempty"`
+	Topics    []any  `json:"topics,omitempty"`
+}
+
+// Filter is a filter installed by eth_newFilter or eth_newBlockFilter and
+// kept by sjrpc.
+type Filter struct {
+	ID      string
+	Kind    string
+	Query   FilterQuery
+	RpcUrl  string
+	ChainId *int
+
+	// Mutex serializes the polls of the filter.
+	Mutex sync.Mutex
+	// LastBlock is the last block whose changes were returned.
+	LastBlock uint64
+	// lastPoll is the Unix time in nanoseconds of the last poll. It is read
+	// without Mutex, so expiry checks do not wait for a running poll.
+	lastPoll atomic.Int64
+}
+
+// Poll records that the filter was polled now.
+func (f *Filter) Poll() {
+	f.lastPoll.Store(time.Now().UnixNano())
+}
+
+// IsExpired reports if the filter was not polled for longer than timeout.
+func (f *Filter) IsExpired(timeout time.Duration) bool {
+	return time.Since(time.Unix(0, f.lastPoll.Load())) > timeout
+}
+
+// BelongsTo reports if the filter was installed for the upstream rpcUrl and
+// chainId.
+func (f *Filter) BelongsTo(rpcUrl string, chainId *int) bool {
+	if f.RpcUrl != rpcUrl {
+		return false
+	}
+	if f.ChainId == nil || chainId == nil {
+		return f.ChainId == nil && chainId == nil
+	}
+	return *f.ChainId == *chainId
+}
@@ -0,0 +1,149 @@
+package model
+
+import (
+	"os"
+	"sync/atomic"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CachePolicy defines in which cache tier each JSON-RPC method is handled.
+// Methods not listed in any tier are always forwarded to the remote node.
+type CachePolicy struct {
+	// Permanent methods are stored in the database at the first call.
+	Permanent []string `json:"permanent" yaml:"permanent"`
+	// AfterFinal methods are stored in the database once their result is final.
+	AfterFinal []string `json:"afterFinal" yaml:"afterFinal"`
+	// BlockBound methods depend on the block of a parameter, or on the block
+	// of the transaction they refer to. They are stored in the database once
+	// that block is final.
+	BlockBound []string `json:"blockBound" yaml:"blockBound"`
+	// Timely methods are kept in memory for TimelyTTL seconds.
+	Timely []string `json:"timely" yaml:"timely"`
+	// Env methods are answered from environment variables.
+	Env []string `json:"env" yaml:"env"`
+	// TimelyTTL is the number of seconds a timely response is considered valid.
+	TimelyTTL int64 `json:"timelyTTL" yaml:"timelyTTL"`
+	// TTLs overrides TimelyTTL for specific timely methods.
+	TTLs map[string]int64 `json:"ttls,omitempty" yaml:"ttls,omitempty"`
+	// Logs methods are answered from the log store, indexed by block range.
+	Logs []string `json:"logs" yaml:"logs"`
+	// LogsChunkSize is the largest block range requested from upstream at once.
+	LogsChunkSize uint64 `json:"logsChunkSize" yaml:"logsChunkSize"`
+	// Blocks methods are answered from the block store, where each block is
+	// kept once by hash.
+	Blocks []string `json:"blocks" yaml:"blocks"`
+	// PrefetchReceipts caches the receipts of a finalized block, fetched
+	// with eth_getBlockReceipts, when the block is cached.
+	PrefetchReceipts bool `json:"prefetchReceipts" yaml:"prefetchReceipts"`
+}
+
+var currentPolicy atomic.Pointer[CachePolicy]
+
+// DefaultCachePolicy returns the built-in method classification.
+func DefaultCachePolicy() *CachePolicy {
+	return &CachePolicy{
+		Permanent: []string{
+			"web3_clientVersion",
+			"web3_sha3",
+			"net_version",
+			"eth_chainId",
+		},
+		AfterFinal: []string{
+			"eth_getTransactionReceipt",
+			"eth_getTransactionByHash",
+		},
+		BlockBound: []string{
+			"eth_getBlockReceipts",
+			"eth_getProof",
+			"eth_getUncleByBlockHashAndIndex",
+			"eth_getUncleByBlockNumberAndIndex",
+			"eth_getUncleCountByBlockHash",
+			"eth_getUncleCountByBlockNumber",
+			"debug_traceTransaction",
+			"debug_traceBlockByNumber",
+			"debug_traceBlockByHash",
+			"trace_block",
+			"trace_transaction",
+		},
+		Timely: []string{
+			"eth_getCode",
+			"eth_getTransactionCount",
+			"eth_feeHistory",
+			"eth_getStorageAt",
+			"eth_getBalance",
+		},
+		Env: []string{
+			"eth_accounts",
+		},
+		TimelyTTL: 12,
+		Logs: []string{
+			"eth_getLogs",
+		},
+		LogsChunkSize: 2000,
+		Blocks: []string{
+			"eth_getBlockByHash",
+			"eth_getBlockByNumber",
+			"eth_getBlockTransactionCountByHash",
+			"eth_getBlockTransactionCountByNumber",
+			"eth_getTransactionByBlockNumberAndIndex",
+			"eth_getTransactionByBlockHashAndIndex",
+		},
+	}
+}
+
+// LoadCachePolicy reads a cache policy file written in JSON or YAML. Tiers
+// missing in the file keep their default methods.
+func LoadCachePolicy(path string) (policy *CachePolicy, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	policy = DefaultCachePolicy()
+	err = yaml.Unmarshal(data, policy)
+	if err != nil {
+		policy = nil
+		return
+	}
+	if policy.TimelyTTL < 1 {
+		policy.TimelyTTL = DefaultCachePolicy().TimelyTTL
+	}
+	if policy.LogsChunkSize < 1 {
+		policy.LogsChunkSize = DefaultCachePolicy().LogsChunkSize
+	}
+	return
+}
+
+// GetCachePolicy returns the cache policy currently in use.
+func GetCachePolicy() *CachePolicy {
+	policy := currentPolicy.Load()
+	if policy == nil {
+		policy = DefaultCachePolicy()
+		currentPolicy.CompareAndSwap(nil, policy)
+		policy = currentPolicy.Load()
+	}
+	return policy
+}
+
+// SetCachePolicy replaces the cache policy currently in use.
+func SetCachePolicy(policy *CachePolicy) {
+	currentPolicy.Store(policy)
+}
+
+// TTL returns the number of seconds a timely response of method is valid.
+func (policy *CachePolicy) TTL(method string) int64 {
+	ttl, ok := policy.TTLs[method]
+	if ok && ttl > 0 {
+		return ttl
+	}
+	return policy.TimelyTTL
+}
+
+func (policy *CachePolicy) has(methods []string, method string) bool {
+	for _, item := range methods {
+		if item == method {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package model
+
+import "encoding/json"
+
+// JSON-RPC error codes.
+const (
+	ErrCodeParse          = -32700
+	ErrCodeInvalidRequest = -32600
+	ErrCodeMethodNotFound = -32601
+	ErrCodeInvalidParams  = -32602
+	ErrCodeInternal       = -32603
+	ErrCodeLimitExceeded  = -32005
+)
+
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+type RPCResponse struct {
+	Jsonrpc string          `json:"jsonrpc"`
+	ID      int             `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// NewResultResponse returns a successful JSON-RPC response to the request id.
+func NewResultResponse(id int, result any) (resp RPCResponse) {
+	resp.Jsonrpc = "2.0"
+	resp.ID = id
+	tmp, err := json.Marshal(result)
+	if err != nil {
+		resp.Error = &RPCError{Code: ErrCodeInternal, Message: err.Error()}
+		return
+	}
+	resp.Result = tmp
+	return
+}
+
+// NewErrorResponse returns a JSON-RPC error response to the request id.
+func NewErrorResponse(id int, code int, message string) (resp RPCResponse) {
+	resp.Jsonrpc = "2.0"
+	resp.ID = id
+	resp.Error = &RPCError{Code: code, Message: message}
+	return
+}
+
+// NewRawIDErrorResponse returns a JSON-RPC error response to a request whose
+// id was not decoded. id is the raw id of the request, a number or a string;
+// the response id is null when it is empty, as for requests that could not
+// be read.
+func NewRawIDErrorResponse(id json.RawMessage, code int, message string) string {
+	if len(id) < 1 {
+		id = json.RawMessage("null")
+	}
+	tmp, _ := json.Marshal(struct {
+		Jsonrpc string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Error   *RPCError       `json:"error"`
+	}{"2.0", id, &RPCError{Code: code, Message: message}})
+	return string(tmp)
+}
+
+func (resp *RPCResponse) ToString() string {
+	tmp, _ := json.Marshal(resp)
+	return string(tmp)
+}
@@ -1,8 +1,10 @@
 package model
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"strconv"
 	"strings"
 	"time"
 
@@ -42,69 +44,170 @@ func (rpc *RPCRequest) Base64Hash(userSelectedChainId *int) (hash string) {
 }
 
 func (rpc *RPCRequest) IsCacheable() (resp bool) {
-	switch rpc.Method {
-	case
-		"eth_getTransactionByBlockNumberAndIndex",
-		"eth_getTransactionByBlockHashAndIndex",
-		"web3_clientVersion",
-		"web3_sha3",
-		"net_version",
-		"eth_chainId",
-		"eth_getBlockByHash",
-		"eth_getBlockByNumber",
-		"eth_getBlockTransactionCountByHash",
-		"eth_getBlockTransactionCountByNumber":
-		resp = true
-	}
+	policy := GetCachePolicy()
+	resp = policy.has(policy.Permanent, rpc.Method)
 	return
 }
 
 func (rpc *RPCRequest) IsAfterFinalCacheable() (resp bool) {
-	switch rpc.Method {
-	case "eth_getTransactionReceipt", "eth_getTransactionByHash":
-		resp = true
-	}
+	policy := GetCachePolicy()
+	resp = policy.has(policy.AfterFinal, rpc.Method)
+	return
+}
+
+// IsBlockBoundCacheable reports if the response of the method is cached once
+// the block it depends on is final.
+func (rpc *RPCRequest) IsBlockBoundCacheable() (resp bool) {
+	policy := GetCachePolicy()
+	resp = policy.has(policy.BlockBound, rpc.Method)
 	return
 }
 
 func (rpc *RPCRequest) IsTimelyCacheable() (resp bool) {
-	switch rpc.Method {
-	case "eth_getLogs", "eth_getCode", "eth_getTransactionCount", "eth_feeHistory", "eth_getStorageAt", "eth_getBalance":
-		resp = true
-	}
+	policy := GetCachePolicy()
+	resp = policy.has(policy.Timely, rpc.Method)
+	return
+}
+
+// IsLogsCacheable reports if the method is answered from the log store.
+func (rpc *RPCRequest) IsLogsCacheable() (resp bool) {
+	policy := GetCachePolicy()
+	resp = policy.has(policy.Logs, rpc.Method)
+	return
+}
+
+// IsBlockCacheable reports if the method is answered from the block store.
+func (rpc *RPCRequest) IsBlockCacheable() (resp bool) {
+	policy := GetCachePolicy()
+	resp = policy.has(policy.Blocks, rpc.Method)
 	return
 }
 
 func (rpc *RPCRequest) IsEnvCacheable() (resp bool) {
+	policy := GetCachePolicy()
+	resp = policy.has(policy.Env, rpc.Method)
+	return
+}
+
+// IsSendRawTransaction reports if the request submits a signed transaction.
+func (rpc *RPCRequest) IsSendRawTransaction() (resp bool) {
+	return rpc.Method == "eth_sendRawTransaction"
+}
+
+// IsIdempotent reports if sending the request twice has the same effect of
+// sending it once, so it can be sent again when its response is lost.
+// Submitting transactions and installing filters or subscriptions are not.
+func (rpc *RPCRequest) IsIdempotent() (resp bool) {
+	switch rpc.Method {
+	case "eth_sendRawTransaction", "eth_sendTransaction", "personal_sendTransaction",
+		"eth_newFilter", "eth_newBlockFilter", "eth_newPendingTransactionFilter", "eth_subscribe":
+		return false
+	}
+	return true
+}
+
+// IsFilterMethod reports if the method is a stateful filter method answered
+// by sjrpc itself.
+func (rpc *RPCRequest) IsFilterMethod() (resp bool) {
 	switch rpc.Method {
-	case "eth_accounts":
+	case "eth_newFilter", "eth_newBlockFilter", "eth_getFilterChanges", "eth_getFilterLogs", "eth_uninstallFilter":
 		resp = true
 	}
 	return
 }
 
-func (rpc *RPCRequest) IsResultFinal(resp string) (final bool) {
-	final = true
-	if strings.Contains(resp, `"result":null`) ||
-		strings.Contains(resp, `"result": null`) ||
-		strings.Contains(resp, `"blockNumber": null,`) ||
-		strings.Contains(resp, `"blockNumber":null,`) {
-		final = false
+// ResultBlock is the block a transaction or receipt belongs to.
+type ResultBlock struct {
+	Number uint64
+	Hash   string
+}
+
+// ResultBlock decodes the block of a transaction or receipt response. ok is
+// false when the response is an error, the result is null or the
+// transaction is still pending.
+func (rpc *RPCRequest) ResultBlock(resp string) (block ResultBlock, ok bool) {
+	var respObj RPCResponse
+	err := json.Unmarshal([]byte(resp), &respObj)
+	if err != nil || respObj.Error != nil || IsNullResult(respObj.Result) {
+		return
+	}
+	var result struct {
+		BlockNumber *string `json:"blockNumber"`
+		BlockHash   *string `json:"blockHash"`
+	}
+	err = json.Unmarshal(respObj.Result, &result)
+	if err != nil || result.BlockNumber == nil || result.BlockHash == nil {
+		return
+	}
+	tmp, _ := strings.CutPrefix(*result.BlockNumber, "0x")
+	block.Number, err = strconv.ParseUint(tmp, 16, 64)
+	if err != nil {
+		return
 	}
+	block.Hash = *result.BlockHash
+	ok = true
 	return
 }
 
+// IsNullResult reports if a JSON-RPC result is missing or null.
+func IsNullResult(result json.RawMessage) bool {
+	tmp := bytes.TrimSpace(result)
+	return len(tmp) < 1 || bytes.Equal(tmp, []byte("null"))
+}
+
+// IsResultNull reports if the response has no result, either because it is
+// an error or because the result is null.
+func IsResultNull(resp string) bool {
+	var respObj RPCResponse
+	err := json.Unmarshal([]byte(resp), &respObj)
+	return err != nil || respObj.Error != nil || IsNullResult(respObj.Result)
+}
+
+// IsResponseError reports if the response is invalid or an error.
+func IsResponseError(resp string) bool {
+	var respObj RPCResponse
+	err := json.Unmarshal([]byte(resp), &respObj)
+	return err != nil || respObj.Error != nil
+}
+
 type EphemeralRequest struct {
 	Base64Hash  []byte
 	Request     RPCRequest
 	Response    string
 	BlockNumber uint64
 	When        int64
+	// Fetched is when the response came from upstream.
+	Fetched time.Time
+}
+
+// UnfinalizedRequest is a response depending on a block not final yet.
+type UnfinalizedRequest struct {
+	Key      []byte
+	Response string
+	Block    ResultBlock
+	RpcUrl   string
+	// Fetched is when the response came from upstream.
+	Fetched time.Time
+}
+
+// PendingTransaction is a transaction sent through sjrpc that is not in a
+// block yet. Polls for it are answered with the last upstream response until
+// the head reaches NextCheck.
+type PendingTransaction struct {
+	Hash      string
+	RpcUrl    string
+	Submitted time.Time
+	// Checks is the number of times upstream was polled for the transaction.
+	Checks int
+	// NextCheck is the head number from which upstream is polled again.
+	NextCheck uint64
+	// Responses holds the last upstream response of each polling method.
+	Responses map[string]string
 }
 
 func (erpc *EphemeralRequest) IsStillValid() (ok bool) {
 	now := time.Now().UTC().Unix()
-	max := erpc.When + 12
+	max := erpc.When + GetCachePolicy().TTL(erpc.Request.Method)
 	if now <= max {
 		ok = true
 	}
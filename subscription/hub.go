@@ -0,0 +1,392 @@
+package subscription
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jeffprestes/sjrpc/logging"
+	"github.com/jeffprestes/sjrpc/model"
+	"github.com/jeffprestes/sjrpc/upstream"
+)
+
+const (
+	// Timeout of a request sent to the upstream WebSocket server.
+	upstreamCallTimeout = 15 * time.Second
+
+	// Maximum wait between two attempts to restore the subscriptions.
+	maxResubscribeDelay = 30 * time.Second
+
+	// Notifications waiting to be delivered to a subscriber, which is
+	// dropped when they are more.
+	subscriberQueueSize = 256
+)
+
+// DeliverFunc receives the result of every notification of a subscription.
+type DeliverFunc func(subscriptionID string, result json.RawMessage)
+
+// DroppedFunc is called when a subscription is removed because its
+// subscriber did not keep up with the notifications.
+type DroppedFunc func(subscriptionID string)
+
+type (
+	// Hub shares upstream eth_subscribe subscriptions among local subscribers.
+	// Subscriptions with the same params use a single upstream subscription,
+	// which is created by the first subscriber and removed after the last one
+	// unsubscribes. Upstream subscriptions are created again when the
+	// connection is restored.
+	Hub struct {
+		transport *upstream.WSTransport
+		lastUsed  atomic.Int64
+
+		// subscribeMutex serializes subscribe, unsubscribe and resubscribe
+		// operations.
+		subscribeMutex sync.Mutex
+
+		mutex        sync.Mutex
+		topics       map[string]*topic
+		byUpstreamID map[string]*topic
+		bySubscriber map[string]*topic
+	}
+
+	topic struct {
+		key         string
+		params      []any
+		upstreamID  string
+		subscribers map[string]*subscriber
+	}
+
+	// subscriber delivers the notifications of a subscription in its own
+	// goroutine, so a slow subscriber does not hold the others back.
+	subscriber struct {
+		id       string
+		deliver  DeliverFunc
+		dropped  DroppedFunc
+		queue    chan json.RawMessage
+		done     chan struct{}
+		stopOnce sync.Once
+		dropping atomic.Bool
+	}
+)
+
+var (
+	hubsMutex sync.Mutex
+	hubs      = make(map[string]*Hub)
+)
+
+// HubFor returns the hub of the upstream WebSocket url, creating it if needed.
+func HubFor(url string) *Hub {
+	hubsMutex.Lock()
+	defer hubsMutex.Unlock()
+	hub, ok := hubs[url]
+	if !ok {
+		hub = NewHub(upstream.WebSocket(url))
+		hubs[url] = hub
+	}
+	hub.lastUsed.Store(time.Now().UnixNano())
+	return hub
+}
+
+// EvictIdle removes the hubs without subscriptions not returned by HubFor
+// for longer than idle, except the ones of the upstreams keep reports true
+// for.
+func EvictIdle(idle time.Duration, keep func(url string) bool) (evicted int) {
+	deadline := time.Now().Add(-idle).UnixNano()
+	hubsMutex.Lock()
+	defer hubsMutex.Unlock()
+	for url, hub := range hubs {
+		hub.mutex.Lock()
+		empty := len(hub.topics) < 1
+		hub.mutex.Unlock()
+		if empty && hub.lastUsed.Load() < deadline && !keep(url) {
+			delete(hubs, url)
+			evicted++
+		}
+	}
+	return
+}
+
+// NewHub returns a hub sending its subscriptions over transport.
+func NewHub(transport *upstream.WSTransport) *Hub {
+	h := &Hub{
+		transport:    transport,
+		topics:       make(map[string]*topic),
+		byUpstreamID: make(map[string]*topic),
+		bySubscriber: make(map[string]*topic),
+	}
+	transport.OnNotification(h.dispatch)
+	transport.OnReconnect(h.resubscribe)
+	return h
+}
+
+// IsSupported reports if kind is an eth_subscribe subscription type the hub
+// can share.
+func IsSupported(kind string) bool {
+	switch kind {
+	case "newHeads", "logs", "newPendingTransactions":
+		return true
+	}
+	return false
+}
+
+// Subscribe registers deliver to receive the notifications of the eth_subscribe
+// params and returns the local subscription id. Subscriptions whose
+// notifications queue up faster than deliver returns are removed and dropped,
+// if not nil, is called.
+func (h *Hub) Subscribe(params []any, deliver DeliverFunc, dropped DroppedFunc) (id string, err error) {
+	if len(params) < 1 {
+		err = fmt.Errorf("missing subscription type")
+		return
+	}
+	kind, _ := params[0].(string)
+	if !IsSupported(kind) {
+		err = fmt.Errorf("unsupported subscription type: %v", params[0])
+		return
+	}
+	tmp, err := json.Marshal(params)
+	if err != nil {
+		return
+	}
+	key := string(tmp)
+
+	h.subscribeMutex.Lock()
+	defer h.subscribeMutex.Unlock()
+
+	h.mutex.Lock()
+	item, ok := h.topics[key]
+	h.mutex.Unlock()
+	if !ok {
+		var upstreamID string
+		upstreamID, err = h.subscribeUpstream(params)
+		if err != nil {
+			return
+		}
+		item = &topic{
+			key:         key,
+			params:      params,
+			upstreamID:  upstreamID,
+			subscribers: make(map[string]*subscriber),
+		}
+		h.mutex.Lock()
+		if len(h.topics) < 1 {
+			h.transport.Hold()
+		}
+		h.topics[key] = item
+		h.byUpstreamID[upstreamID] = item
+		h.mutex.Unlock()
+	}
+
+	id, err = h.addSubscriber(item, deliver, dropped)
+	return
+}
+
+// addSubscriber adds a local subscription to item and starts delivering its
+// notifications.
+func (h *Hub) addSubscriber(item *topic, deliver DeliverFunc, dropped DroppedFunc) (id string, err error) {
+	id, err = NewSubscriptionID()
+	if err != nil {
+		return
+	}
+	sub := &subscriber{
+		id:      id,
+		deliver: deliver,
+		dropped: dropped,
+		queue:   make(chan json.RawMessage, subscriberQueueSize),
+		done:    make(chan struct{}),
+	}
+	h.mutex.Lock()
+	item.subscribers[id] = sub
+	h.bySubscriber[id] = item
+	h.mutex.Unlock()
+	go sub.run()
+	return
+}
+
+// Unsubscribe removes the local subscription id. It reports false if the
+// subscription does not exist.
+func (h *Hub) Unsubscribe(id string) (ok bool) {
+	h.subscribeMutex.Lock()
+	defer h.subscribeMutex.Unlock()
+
+	h.mutex.Lock()
+	item, ok := h.bySubscriber[id]
+	if !ok {
+		h.mutex.Unlock()
+		return
+	}
+	delete(h.bySubscriber, id)
+	item.subscribers[id].stop()
+	delete(item.subscribers, id)
+	last := len(item.subscribers) < 1
+	if last {
+		delete(h.topics, item.key)
+		delete(h.byUpstreamID, item.upstreamID)
+		if len(h.topics) < 1 {
+			h.transport.Release()
+		}
+	}
+	h.mutex.Unlock()
+
+	if last && len(item.upstreamID) > 0 {
+		_, err := h.call("eth_unsubscribe", []any{item.upstreamID})
+		if err != nil {
+			slog.Warn("error unsubscribing", "subscription", item.upstreamID, "upstream", logging.RedactURL(h.transport.URL()), "error", err)
+		}
+	}
+	return
+}
+
+func (h *Hub) subscribeUpstream(params []any) (upstreamID string, err error) {
+	result, err := h.call("eth_subscribe", params)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(result, &upstreamID)
+	if err != nil {
+		err = fmt.Errorf("invalid eth_subscribe result %s: %w", result, err)
+	}
+	return
+}
+
+// call sends a JSON-RPC request to the upstream server and returns its
+// result.
+func (h *Hub) call(method string, params []any) (result json.RawMessage, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), upstreamCallTimeout)
+	defer cancel()
+	request := model.RPCRequest{JsonRpcVersion: "2.0", Method: method, Params: params, ID: 1}
+	tmp, err := h.transport.Call(ctx, &request)
+	if err != nil {
+		return
+	}
+	var resp model.RPCResponse
+	err = json.Unmarshal([]byte(tmp), &resp)
+	if err != nil {
+		return
+	}
+	if resp.Error != nil {
+		err = fmt.Errorf("%s error %d: %s", method, resp.Error.Code, resp.Error.Message)
+		return
+	}
+	result = resp.Result
+	return
+}
+
+// dispatch queues a notification of the upstream subscription for every
+// local subscriber. Subscribers whose queue is full are dropped.
+func (h *Hub) dispatch(upstreamID string, result json.RawMessage) {
+	h.mutex.Lock()
+	item, ok := h.byUpstreamID[upstreamID]
+	var subscribers []*subscriber
+	if ok {
+		for _, sub := range item.subscribers {
+			subscribers = append(subscribers, sub)
+		}
+	}
+	h.mutex.Unlock()
+	for _, sub := range subscribers {
+		select {
+		case sub.queue <- result:
+		default:
+			// unsubscribing may call upstream, whose reader runs dispatch
+			if sub.dropping.CompareAndSwap(false, true) {
+				go h.drop(sub)
+			}
+		}
+	}
+}
+
+// drop removes a subscriber that does not keep up with its notifications.
+func (h *Hub) drop(sub *subscriber) {
+	if !h.Unsubscribe(sub.id) {
+		return
+	}
+	slog.Warn("subscriber too slow, subscription dropped", "subscription", sub.id, "upstream", logging.RedactURL(h.transport.URL()))
+	if sub.dropped != nil {
+		sub.dropped(sub.id)
+	}
+}
+
+func (sub *subscriber) run() {
+	for {
+		select {
+		case result := <-sub.queue:
+			sub.deliver(sub.id, result)
+		case <-sub.done:
+			return
+		}
+	}
+}
+
+// stop stops the delivery of the notifications, the ones queued are
+// discarded.
+func (sub *subscriber) stop() {
+	sub.stopOnce.Do(func() {
+		close(sub.done)
+	})
+}
+
+// resubscribe creates again the upstream subscription of every topic after
+// the connection is restored, retrying until it succeeds.
+func (h *Hub) resubscribe() {
+	h.mutex.Lock()
+	h.byUpstreamID = make(map[string]*topic)
+	h.mutex.Unlock()
+
+	delay := time.Second
+	for !h.resubscribeAll() {
+		time.Sleep(delay)
+		delay *= 2
+		if delay > maxResubscribeDelay {
+			delay = maxResubscribeDelay
+		}
+	}
+}
+
+// resubscribeAll subscribes the topics without an upstream subscription on
+// the current connection. It reports false if it must be retried.
+func (h *Hub) resubscribeAll() (ok bool) {
+	h.subscribeMutex.Lock()
+	defer h.subscribeMutex.Unlock()
+
+	h.mutex.Lock()
+	topics := make([]*topic, 0, len(h.topics))
+	for _, item := range h.topics {
+		if _, done := h.byUpstreamID[item.upstreamID]; !done {
+			topics = append(topics, item)
+		}
+	}
+	h.mutex.Unlock()
+
+	for _, item := range topics {
+		upstreamID, err := h.subscribeUpstream(item.params)
+		if err != nil {
+			slog.Warn("error subscribing again", "upstream", logging.RedactURL(h.transport.URL()), "error", err)
+			return false
+		}
+		h.mutex.Lock()
+		item.upstreamID = upstreamID
+		h.byUpstreamID[upstreamID] = item
+		h.mutex.Unlock()
+	}
+	if len(topics) > 0 {
+		slog.Info("subscriptions restored", "upstream", logging.RedactURL(h.transport.URL()), "subscriptions", len(topics))
+	}
+	return true
+}
+
+// NewSubscriptionID returns a random subscription id.
+func NewSubscriptionID() (id string, err error) {
+	tmp := make([]byte, 16)
+	_, err = rand.Read(tmp)
+	if err != nil {
+		return
+	}
+	id = "0x" + hex.EncodeToString(tmp)
+	return
+}
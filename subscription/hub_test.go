@@ -0,0 +1,69 @@
+package subscription
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jeffprestes/sjrpc/upstream"
+)
+
+func TestSlowSubscriberDropped(t *testing.T) {
+	hub := NewHub(upstream.NewWSTransport("ws://127.0.0.1:1"))
+	item := &topic{key: `["newHeads"]`, params: []any{"newHeads"}, upstreamID: "0x1", subscribers: make(map[string]*subscriber)}
+	hub.topics[item.key] = item
+	hub.byUpstreamID[item.upstreamID] = item
+
+	// the slow subscriber never returns from its first delivery
+	stalled := make(chan struct{})
+	defer close(stalled)
+	dropped := make(chan string, 1)
+	slowID, err := hub.addSubscriber(item, func(string, json.RawMessage) { <-stalled }, func(id string) { dropped <- id })
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan json.RawMessage)
+	fastID, err := hub.addSubscriber(item, func(_ string, result json.RawMessage) { received <- result }, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < subscriberQueueSize+10; i++ {
+		result := json.RawMessage(fmt.Sprintf("%d", i))
+		done := make(chan struct{})
+		go func() {
+			hub.dispatch(item.upstreamID, result)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("notification %d: dispatch blocked by the slow subscriber", i)
+		}
+		select {
+		case got := <-received:
+			if string(got) != string(result) {
+				t.Fatalf("notification %d: fast subscriber got %s", i, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("notification %d not delivered to the fast subscriber", i)
+		}
+	}
+
+	select {
+	case id := <-dropped:
+		if id != slowID {
+			t.Errorf("dropped %s, want the slow subscriber %s", id, slowID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the slow subscriber was not dropped")
+	}
+	hub.mutex.Lock()
+	_, slowKept := hub.bySubscriber[slowID]
+	_, fastKept := hub.bySubscriber[fastID]
+	hub.mutex.Unlock()
+	if slowKept || !fastKept {
+		t.Errorf("slow subscriber kept %v, fast subscriber kept %v", slowKept, fastKept)
+	}
+}
@@ -0,0 +1,61 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// maxMemorySpans is how many of the last spans a MemoryExporter keeps.
+const maxMemorySpans = 1000
+
+// MemoryExporter keeps the last spans exported in memory, to check the
+// traces of a server without a collector.
+type MemoryExporter struct {
+	mutex sync.Mutex
+	spans tracetest.SpanStubs
+}
+
+// NewMemoryExporter returns an exporter keeping the spans in memory.
+func NewMemoryExporter() *MemoryExporter {
+	return &MemoryExporter{}
+}
+
+// ExportSpans implements the sdktrace.SpanExporter interface.
+func (e *MemoryExporter) ExportSpans(_ context.Context, spans []sdktrace.ReadOnlySpan) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.spans = append(e.spans, tracetest.SpanStubsFromReadOnlySpans(spans)...)
+	if len(e.spans) > maxMemorySpans {
+		e.spans = append(tracetest.SpanStubs{}, e.spans[len(e.spans)-maxMemorySpans:]...)
+	}
+	return nil
+}
+
+// Shutdown implements the sdktrace.SpanExporter interface. The spans are
+// kept.
+func (e *MemoryExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+// Spans returns the spans kept, the oldest first.
+func (e *MemoryExporter) Spans() tracetest.SpanStubs {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return append(tracetest.SpanStubs{}, e.spans...)
+}
+
+// Reset forgets the spans kept.
+func (e *MemoryExporter) Reset() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.spans = nil
+}
+
+// MarshalJSON encodes the spans kept as an OTLP/HTTP JSON export request.
+func (e *MemoryExporter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(newExportRequest(e.Spans()))
+}
@@ -0,0 +1,62 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestMemoryExporterJSON(t *testing.T) {
+	exporter := NewMemoryExporter()
+	if err := Setup(exporter, "sjrpc", "test", 1); err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+	defer Shutdown()
+
+	ctx, parent := Start(context.Background(), "parent", trace.SpanKindServer)
+	_, child := Start(ctx, "child", trace.SpanKindClient, attribute.Int("items", 3), attribute.Bool("found", false))
+	RecordError(child, errors.New("failed"))
+	child.End()
+	parent.End()
+	Flush()
+
+	var request otlpExportRequest
+	data, err := json.Marshal(exporter)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if err = json.Unmarshal(data, &request); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if len(request.ResourceSpans) != 1 || len(request.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("export request %s", data)
+	}
+	resource := map[string]string{}
+	for _, kv := range request.ResourceSpans[0].Resource.Attributes {
+		resource[kv.Key] = *kv.Value.StringValue
+	}
+	if resource["service.name"] != "sjrpc" || resource["service.version"] != "test" {
+		t.Errorf("resource %v", resource)
+	}
+	spans := request.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 || spans[0].Name != "child" || spans[1].Name != "parent" {
+		t.Fatalf("spans %s", data)
+	}
+	got, want := spans[0], spans[1]
+	if got.TraceID != want.TraceID || got.ParentSpanID != want.SpanID || len(got.TraceID) != 32 || len(got.SpanID) != 16 {
+		t.Errorf("child ids %s/%s, parent %s, want trace %s and parent %s", got.TraceID, got.SpanID, got.ParentSpanID, want.TraceID, want.SpanID)
+	}
+	if got.Kind != int(trace.SpanKindClient) || got.Status.Code != otlpStatusError || got.Status.Message != "failed" {
+		t.Errorf("child kind %d, status %+v", got.Kind, got.Status)
+	}
+	if len(got.Attributes) != 2 || *got.Attributes[0].Value.IntValue != "3" || *got.Attributes[1].Value.BoolValue {
+		t.Errorf("child attributes %s", data)
+	}
+	if want.Status.Code != 0 {
+		t.Errorf("parent status %+v", want.Status)
+	}
+}
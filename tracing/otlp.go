@@ -0,0 +1,190 @@
+package tracing
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// tracesPath is the OTLP/HTTP path of the traces, added to endpoints without
+// it.
+const tracesPath = "/v1/traces"
+
+// NewOTLPExporter returns an exporter to the collector at endpoint, its base
+// URL as http://localhost:4318 or its traces URL. headers are sent with every
+// export, as the credentials of hosted collectors.
+func NewOTLPExporter(endpoint string, headers map[string]string) (sdktrace.SpanExporter, error) {
+	url := strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(url, tracesPath) {
+		url += tracesPath
+	}
+	return otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpointURL(url),
+		otlptracehttp.WithHeaders(headers),
+	)
+}
+
+// The OTLP JSON encoding of an export request. Ids are hex encoded and 64 bit
+// integers are strings.
+type otlpExportRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	TraceState        string         `json:"traceState,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Events            []otlpEvent    `json:"events,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpEvent struct {
+	Name         string         `json:"name"`
+	TimeUnixNano string         `json:"timeUnixNano"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+// OTLP status codes, which are not the values of codes.Code.
+const (
+	otlpStatusOK    = 1
+	otlpStatusError = 2
+)
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+// newExportRequest encodes spans in an export request, grouped by their
+// resource and instrumentation scope.
+func newExportRequest(spans tracetest.SpanStubs) (request otlpExportRequest) {
+	request.ResourceSpans = []otlpResourceSpans{}
+	resources := map[attribute.Distinct]int{}
+	scopes := map[attribute.Distinct]map[string]int{}
+	for _, stub := range spans {
+		var set attribute.Set
+		if stub.Resource != nil {
+			set = *stub.Resource.Set()
+		}
+		r, ok := resources[set.Equivalent()]
+		if !ok {
+			r = len(request.ResourceSpans)
+			resources[set.Equivalent()] = r
+			scopes[set.Equivalent()] = map[string]int{}
+			request.ResourceSpans = append(request.ResourceSpans, otlpResourceSpans{Resource: otlpResource{Attributes: newKeyValues(set.ToSlice())}})
+		}
+		resourceSpans := &request.ResourceSpans[r]
+		scope := stub.InstrumentationScope.Name + "@" + stub.InstrumentationScope.Version
+		s, ok := scopes[set.Equivalent()][scope]
+		if !ok {
+			s = len(resourceSpans.ScopeSpans)
+			scopes[set.Equivalent()][scope] = s
+			resourceSpans.ScopeSpans = append(resourceSpans.ScopeSpans, otlpScopeSpans{Scope: otlpScope{
+				Name:    stub.InstrumentationScope.Name,
+				Version: stub.InstrumentationScope.Version,
+			}})
+		}
+		resourceSpans.ScopeSpans[s].Spans = append(resourceSpans.ScopeSpans[s].Spans, newSpan(stub))
+	}
+	return
+}
+
+func newSpan(stub tracetest.SpanStub) (span otlpSpan) {
+	span = otlpSpan{
+		TraceID:           stub.SpanContext.TraceID().String(),
+		SpanID:            stub.SpanContext.SpanID().String(),
+		TraceState:        stub.SpanContext.TraceState().String(),
+		Name:              stub.Name,
+		Kind:              int(stub.SpanKind),
+		StartTimeUnixNano: strconv.FormatInt(stub.StartTime.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(stub.EndTime.UnixNano(), 10),
+		Attributes:        newKeyValues(stub.Attributes),
+	}
+	if stub.Parent.HasSpanID() {
+		span.ParentSpanID = stub.Parent.SpanID().String()
+	}
+	for _, event := range stub.Events {
+		span.Events = append(span.Events, otlpEvent{
+			Name:         event.Name,
+			TimeUnixNano: strconv.FormatInt(event.Time.UnixNano(), 10),
+			Attributes:   newKeyValues(event.Attributes),
+		})
+	}
+	switch stub.Status.Code {
+	case codes.Ok:
+		span.Status.Code = otlpStatusOK
+	case codes.Error:
+		span.Status.Code = otlpStatusError
+		span.Status.Message = stub.Status.Description
+	}
+	return
+}
+
+func newKeyValues(attrs []attribute.KeyValue) (kvs []otlpKeyValue) {
+	for _, attr := range attrs {
+		kvs = append(kvs, newKeyValue(attr))
+	}
+	return
+}
+
+func newKeyValue(attr attribute.KeyValue) (kv otlpKeyValue) {
+	kv.Key = string(attr.Key)
+	switch attr.Value.Type() {
+	case attribute.BOOL:
+		value := attr.Value.AsBool()
+		kv.Value.BoolValue = &value
+	case attribute.INT64:
+		value := strconv.FormatInt(attr.Value.AsInt64(), 10)
+		kv.Value.IntValue = &value
+	case attribute.FLOAT64:
+		value := attr.Value.AsFloat64()
+		kv.Value.DoubleValue = &value
+	default:
+		value := attr.Value.Emit()
+		kv.Value.StringValue = &value
+	}
+	return
+}
@@ -0,0 +1,24 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// propagator reads and writes the W3C traceparent and tracestate headers.
+var propagator = propagation.TraceContext{}
+
+// Extract returns a copy of ctx holding the span context of the traceparent
+// and tracestate headers, the parent of the spans started with it. ctx is
+// returned as is when there is no valid traceparent.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	return propagator.Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// Inject sets the traceparent and tracestate headers of the span of ctx, so
+// the server called continues the trace.
+func Inject(ctx context.Context, header http.Header) {
+	propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
@@ -0,0 +1,126 @@
+// Package tracing records the spans of the requests served with the
+// OpenTelemetry SDK and exports them to a collector, propagating the W3C trace
+// context to upstream.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// scopeName is the instrumentation scope of the spans of sjrpc.
+const scopeName = "github.com/jeffprestes/sjrpc"
+
+// exportTimeout bounds the export of the spans queued on Flush and Shutdown.
+const exportTimeout = 10 * time.Second
+
+var current atomic.Pointer[sdktrace.TracerProvider]
+
+// dropped counts the spans whose export failed.
+var dropped atomic.Uint64
+
+// Dropped returns the number of spans not exported because the export failed.
+func Dropped() uint64 {
+	return dropped.Load()
+}
+
+// countingExporter counts the spans of the exports that failed.
+type countingExporter struct {
+	sdktrace.SpanExporter
+}
+
+func (e countingExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) (err error) {
+	err = e.SpanExporter.ExportSpans(ctx, spans)
+	if err != nil {
+		dropped.Add(uint64(len(spans)))
+	}
+	return
+}
+
+// Setup records spans from now on and exports them in batches with exporter,
+// sampling traces at sampleRatio, from 0 to 1. Spans with a parent follow the
+// decision of their parent. It replaces the provider of an earlier Setup,
+// whose spans are exported first.
+func Setup(exporter sdktrace.SpanExporter, serviceName, version string, sampleRatio float64) (err error) {
+	if exporter == nil {
+		err = errors.New("no span exporter")
+		return
+	}
+	if sampleRatio < 0 || sampleRatio > 1 {
+		err = fmt.Errorf("invalid sample ratio: %g", sampleRatio)
+		return
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(countingExporter{exporter}),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", serviceName),
+			attribute.String("service.version", version),
+		)),
+	)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		slog.Warn("tracing error", "error", err)
+	}))
+	otel.SetTextMapPropagator(propagator)
+	otel.SetTracerProvider(provider)
+	if previous := current.Swap(provider); previous != nil {
+		shutdown(previous)
+	}
+	return
+}
+
+// Shutdown stops recording spans and exports the ones queued.
+func Shutdown() {
+	otel.SetTracerProvider(noop.NewTracerProvider())
+	if provider := current.Swap(nil); provider != nil {
+		shutdown(provider)
+	}
+}
+
+// Flush exports the spans queued.
+func Flush() {
+	provider := current.Load()
+	if provider == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+	provider.ForceFlush(ctx)
+}
+
+func shutdown(provider *sdktrace.TracerProvider) {
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+	err := provider.Shutdown(ctx)
+	if err != nil {
+		slog.Warn("error stopping the tracer provider", "error", err)
+	}
+}
+
+// Start starts a span, child of the span of ctx, and returns a copy of ctx
+// holding it. The span does not record when tracing is off.
+func Start(ctx context.Context, name string, kind trace.SpanKind, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(scopeName).Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+}
+
+// RecordError records err in span and sets its status to error when err is
+// not nil.
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,161 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/jeffprestes/sjrpc/logging"
+)
+
+// Reorg describes blocks seen by a tracker that are no longer part of the
+// canonical chain.
+type Reorg struct {
+	// Fork is the number of the last block common to both chains.
+	Fork uint64
+	// Depth is the number of blocks of the old chain after Fork.
+	Depth   uint64
+	OldHead Head
+	NewHead Head
+	// Orphaned holds the hashes of the replaced blocks by number.
+	Orphaned map[uint64]string
+	When     time.Time
+}
+
+// ReorgMetrics counts the reorgs seen by every tracker.
+type ReorgMetrics struct {
+	Reorgs         uint64
+	OrphanedBlocks uint64
+	DeepestReorg   uint64
+}
+
+var (
+	reorgsCount    atomic.Uint64
+	orphanedBlocks atomic.Uint64
+	deepestReorg   atomic.Uint64
+)
+
+// Metrics returns the reorg counters of every tracker.
+func Metrics() (metrics ReorgMetrics) {
+	metrics.Reorgs = reorgsCount.Load()
+	metrics.OrphanedBlocks = orphanedBlocks.Load()
+	metrics.DeepestReorg = deepestReorg.Load()
+	return
+}
+
+// isReorg reports if head replaces a block already seen: another block at
+// the same height, or a parent different from the block seen at the previous
+// height. t.mutex must be held.
+func (t *Tracker) isReorg(head Head) bool {
+	hash, ok := t.hashes[head.Number]
+	if ok && hash != head.Hash {
+		return true
+	}
+	if head.Number < 1 || len(head.ParentHash) < 1 {
+		return false
+	}
+	parent, ok := t.hashes[head.Number-1]
+	return ok && parent != head.ParentHash
+}
+
+// isReplaced reports if upstream has another block at the height of head.
+func (t *Tracker) isReplaced(head Head) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), pollTimeout)
+	defer cancel()
+	header, err := t.fetchHeader(ctx, fmt.Sprintf("0x%x", head.Number))
+	if err != nil {
+		slog.Warn("error checking block", "upstream", logging.RedactURL(t.rpcUrl), "block", head.Number, "error", err)
+		return false
+	}
+	return header.Hash != head.Hash
+}
+
+// rollback walks the new chain back from head until it finds a block seen
+// before with the same hash, drops the hashes of the replaced blocks and returns the reorg.
+func (t *Tracker) rollback(head Head) (event Reorg) {
+	ctx, cancel := context.WithTimeout(context.Background(), pollTimeout)
+	defer cancel()
+
+	t.mutex.RLock()
+	event.OldHead = t.latest
+	finalized := t.finalized
+	known := make(map[uint64]string, len(t.hashes))
+	for number, hash := range t.hashes {
+		known[number] = hash
+	}
+	t.mutex.RUnlock()
+
+	event.NewHead = head
+	event.When = time.Now()
+	event.Orphaned = make(map[uint64]string)
+	canonical := map[uint64]string{head.Number: head.Hash}
+	for number, hash := range known {
+		if number > head.Number || (number == head.Number && hash != head.Hash) {
+			event.Orphaned[number] = hash
+		}
+	}
+
+	lowest := head.Number
+	for number := range known {
+		if number < lowest {
+			lowest = number
+		}
+	}
+	parent := head.ParentHash
+	for number := head.Number; number > 0; number-- {
+		event.Fork = number - 1
+		hash, ok := known[event.Fork]
+		if ok && hash == parent {
+			break
+		}
+		if ok {
+			event.Orphaned[event.Fork] = hash
+		}
+		// below the heights seen the blocks can not be compared
+		if event.Fork <= lowest || head.Number-event.Fork >= recentHashes {
+			if ok && finalized < event.Fork {
+				// every block seen was replaced: only the finalized block is
+				// known to be common
+				event.Fork = finalized
+			} else if ok {
+				event.Fork--
+			}
+			break
+		}
+		header, err := t.fetchHeader(ctx, fmt.Sprintf("0x%x", event.Fork))
+		if err != nil {
+			// blocks before are kept: they are compared again with the next
+			// heads
+			slog.Warn("error looking for the fork block of a reorg", "upstream", logging.RedactURL(t.rpcUrl), "error", err)
+			break
+		}
+		canonical[event.Fork] = header.Hash
+		parent = header.ParentHash
+	}
+	if event.OldHead.Number > event.Fork {
+		event.Depth = event.OldHead.Number - event.Fork
+	}
+
+	t.mutex.Lock()
+	for number := range event.Orphaned {
+		delete(t.hashes, number)
+	}
+	for number, hash := range canonical {
+		t.hashes[number] = hash
+	}
+	t.mutex.Unlock()
+
+	reorgsCount.Add(1)
+	orphanedBlocks.Add(uint64(len(event.Orphaned)))
+	for {
+		deepest := deepestReorg.Load()
+		if event.Depth <= deepest || deepestReorg.CompareAndSwap(deepest, event.Depth) {
+			break
+		}
+	}
+	slog.Info("reorg", "upstream", logging.RedactURL(t.rpcUrl), "depth", event.Depth, "fork", event.Fork,
+		"old_head", event.OldHead.Number, "old_hash", event.OldHead.Hash, "new_head", head.Number, "new_hash", head.Hash)
+	return
+}
@@ -0,0 +1,122 @@
+package tracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/jeffprestes/sjrpc/model"
+)
+
+// chainServer answers eth_getBlockByNumber with the blocks of its chain,
+// named by fork: block n of fork "b" has hash "b<n>".
+type chainServer struct {
+	mutex sync.Mutex
+	// forks holds the fork of each height
+	forks map[uint64]string
+}
+
+func (s *chainServer) head(number uint64) Head {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return Head{Number: number, Hash: fmt.Sprintf("%s%d", s.forks[number], number), ParentHash: fmt.Sprintf("%s%d", s.forks[number-1], number-1)}
+}
+
+func (s *chainServer) serve(w http.ResponseWriter, r *http.Request) {
+	var request model.RPCRequest
+	json.NewDecoder(r.Body).Decode(&request)
+	tag, _ := request.Params[0].(string)
+	number, err := ParseHexUint64(tag)
+	if err != nil {
+		json.NewEncoder(w).Encode(model.NewErrorResponse(request.ID, -32000, "unsupported block tag"))
+		return
+	}
+	head := s.head(number)
+	header := model.BlockHeader{Number: tag, Hash: head.Hash, ParentHash: head.ParentHash, Timestamp: "0x1"}
+	json.NewEncoder(w).Encode(model.NewResultResponse(request.ID, header))
+}
+
+func newChainTracker(t *testing.T, forks map[uint64]string) (*chainServer, *Tracker) {
+	server := &chainServer{forks: forks}
+	httpServer := httptest.NewServer(http.HandlerFunc(server.serve))
+	t.Cleanup(httpServer.Close)
+	t.Cleanup(func() { EvictIdle(0, func(string) bool { return false }) })
+	return server, For(httpServer.URL, "")
+}
+
+func TestReorgDetected(t *testing.T) {
+	server, headTracker := newChainTracker(t, map[uint64]string{99: "a", 100: "a", 101: "a", 102: "a"})
+	var events []Reorg
+	headTracker.OnReorg(func(event Reorg) { events = append(events, event) })
+	for number := uint64(100); number <= 102; number++ {
+		headTracker.setHead(server.head(number))
+	}
+	if len(events) != 0 {
+		t.Fatalf("reorg reported on a linear chain: %v", events)
+	}
+
+	server.mutex.Lock()
+	server.forks[101] = "b"
+	server.forks[102] = "b"
+	server.forks[103] = "b"
+	server.mutex.Unlock()
+	headTracker.setHead(server.head(103))
+
+	if len(events) != 1 {
+		t.Fatalf("%d reorgs reported, want 1", len(events))
+	}
+	event := events[0]
+	if event.Fork != 100 || event.Depth != 2 {
+		t.Errorf("fork %d depth %d, want fork 100 depth 2", event.Fork, event.Depth)
+	}
+	if len(event.Orphaned) != 2 || event.Orphaned[101] != "a101" || event.Orphaned[102] != "a102" {
+		t.Errorf("orphaned %v, want a101 and a102", event.Orphaned)
+	}
+	for number := uint64(101); number <= 103; number++ {
+		if hash, _ := headTracker.HashAt(number); hash != fmt.Sprintf("b%d", number) {
+			t.Errorf("hash at %d = %q after the reorg", number, hash)
+		}
+	}
+}
+
+func TestReorgOfSkippedHeads(t *testing.T) {
+	server, headTracker := newChainTracker(t, map[uint64]string{99: "a", 100: "a"})
+	var events []Reorg
+	headTracker.OnReorg(func(event Reorg) { events = append(events, event) })
+	headTracker.setHead(server.head(100))
+
+	// polling missed the blocks after 100, which was replaced
+	server.mutex.Lock()
+	for number := uint64(100); number <= 105; number++ {
+		server.forks[number] = "b"
+	}
+	server.mutex.Unlock()
+	headTracker.setHead(server.head(105))
+
+	if len(events) != 1 {
+		t.Fatalf("%d reorgs reported, want 1", len(events))
+	}
+	if events[0].Orphaned[100] != "a100" {
+		t.Errorf("orphaned %v, want a100", events[0].Orphaned)
+	}
+	if events[0].Fork >= 100 {
+		t.Errorf("fork %d, want before 100", events[0].Fork)
+	}
+}
+
+func TestNoReorgOnSkippedHeads(t *testing.T) {
+	server, headTracker := newChainTracker(t, map[uint64]string{99: "a", 100: "a", 101: "a", 102: "a", 103: "a"})
+	var events []Reorg
+	headTracker.OnReorg(func(event Reorg) { events = append(events, event) })
+	headTracker.setHead(server.head(100))
+	headTracker.setHead(server.head(103))
+	if len(events) != 0 {
+		t.Errorf("reorg reported for skipped heads of the same chain: %v", events)
+	}
+	if latest := headTracker.latest; latest.Number != 103 {
+		t.Errorf("latest head %d, want 103", latest.Number)
+	}
+}
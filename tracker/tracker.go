@@ -0,0 +1,376 @@
+package tracker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jeffprestes/sjrpc/logging"
+	"github.com/jeffprestes/sjrpc/model"
+	"github.com/jeffprestes/sjrpc/subscription"
+	"github.com/jeffprestes/sjrpc/upstream"
+)
+
+const (
+	// Polling interval when newHeads notifications are not available.
+	pollInterval = 4 * time.Second
+
+	// Polling interval when newHeads notifications are available, to catch
+	// missed notifications.
+	subscribedPollInterval = 30 * time.Second
+
+	// A head older than this is refreshed before being returned by Latest.
+	staleAfter = 15 * time.Second
+
+	// Timeout of the polling requests.
+	pollTimeout = 10 * time.Second
+
+	// Number of recent heights whose block hash is kept.
+	recentHashes = 256
+
+	// How long the finalized block number is reused before asking upstream
+	// again.
+	finalizedMaxAge = 30 * time.Second
+
+	// Depth considered final when the upstream server does not support the
+	// finalized block tag.
+	fallbackConfirmations = 64
+)
+
+// Head is a block at the head of the chain.
+type Head struct {
+	Number     uint64
+	Hash       string
+	ParentHash string
+	Timestamp  int64
+}
+
+// Tracker follows the head of the chain of an upstream server using newHeads
+// notifications when the upstream WebSocket server is available, falling back
+// to polling eth_getBlockByNumber("latest").
+type Tracker struct {
+	rpcUrl string
+	wsUrl  string
+
+	startOnce sync.Once
+	stop      chan struct{}
+	lastUsed  atomic.Int64
+
+	// headMutex serializes setHead, which calls upstream on reorgs.
+	headMutex sync.Mutex
+
+	mutex          sync.RWMutex
+	latest         Head
+	updated        time.Time
+	hashes         map[uint64]string
+	listeners      []func(Head)
+	reorgListeners []func(Reorg)
+
+	finalized        uint64
+	finalizedUpdated time.Time
+}
+
+var (
+	trackersMutex sync.Mutex
+	trackers      = make(map[string]*Tracker)
+)
+
+// For returns the tracker of the upstream rpcUrl, creating it if needed.
+// wsUrl is the upstream WebSocket URL used for newHeads notifications; it can
+// be empty.
+func For(rpcUrl, wsUrl string) *Tracker {
+	trackersMutex.Lock()
+	defer trackersMutex.Unlock()
+	t, ok := trackers[rpcUrl]
+	if !ok {
+		t = &Tracker{rpcUrl: rpcUrl, wsUrl: wsUrl, hashes: make(map[uint64]string), stop: make(chan struct{})}
+		trackers[rpcUrl] = t
+	}
+	t.lastUsed.Store(time.Now().UnixNano())
+	return t
+}
+
+// EvictIdle removes the trackers not returned by For for longer than idle,
+// except the ones of the upstreams keep reports true for, and stops them.
+func EvictIdle(idle time.Duration, keep func(rpcUrl string) bool) (evicted []*Tracker) {
+	deadline := time.Now().Add(-idle).UnixNano()
+	trackersMutex.Lock()
+	defer trackersMutex.Unlock()
+	for rpcUrl, t := range trackers {
+		if t.lastUsed.Load() < deadline && !keep(rpcUrl) {
+			delete(trackers, rpcUrl)
+			close(t.stop)
+			evicted = append(evicted, t)
+		}
+	}
+	return
+}
+
+// Start starts following the chain head in background until the tracker is
+// evicted. It is safe to call it more than once. Trackers not started fetch
+// the head from upstream when it is asked for and stale.
+func (t *Tracker) Start() {
+	t.startOnce.Do(func() {
+		go t.run()
+	})
+}
+
+// Latest returns the latest known head, fetching it from upstream if it is
+// unknown or stale.
+func (t *Tracker) Latest(ctx context.Context) (head Head, err error) {
+	t.mutex.RLock()
+	head = t.latest
+	fresh := head.Number > 0 && time.Since(t.updated) < staleAfter
+	t.mutex.RUnlock()
+	if fresh {
+		return
+	}
+	head, err = t.poll(ctx)
+	return
+}
+
+// Finalized returns the number of the latest finalized block. When the
+// upstream server does not support the finalized block tag, blocks
+// fallbackConfirmations deep are considered final.
+func (t *Tracker) Finalized(ctx context.Context) (number uint64, err error) {
+	t.mutex.RLock()
+	number = t.finalized
+	fresh := number > 0 && time.Since(t.finalizedUpdated) < finalizedMaxAge
+	t.mutex.RUnlock()
+	if fresh {
+		return
+	}
+
+	header, errFinalized := t.fetchHeader(ctx, "finalized")
+	if errFinalized == nil {
+		number, err = ParseHexUint64(header.Number)
+		if err != nil {
+			return
+		}
+	} else {
+		var latest Head
+		latest, err = t.Latest(ctx)
+		if err != nil {
+			return
+		}
+		number = 0
+		if latest.Number > fallbackConfirmations {
+			number = latest.Number - fallbackConfirmations
+		}
+	}
+
+	t.mutex.Lock()
+	if number > t.finalized {
+		t.finalized = number
+	}
+	number = t.finalized
+	t.finalizedUpdated = time.Now()
+	t.mutex.Unlock()
+	return
+}
+
+// HashAt returns the hash of the block at number seen by the tracker, if it
+// is one of the recent heads.
+func (t *Tracker) HashAt(number uint64) (hash string, ok bool) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	hash, ok = t.hashes[number]
+	return
+}
+
+// Observe records the hash of a block near the head seen outside the
+// tracker, so a reorg replacing it is detected down to its height.
+func (t *Tracker) Observe(number uint64, hash string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if len(hash) < 1 || number+recentHashes <= t.latest.Number {
+		return
+	}
+	_, ok := t.hashes[number]
+	if !ok {
+		t.hashes[number] = hash
+	}
+}
+
+// OnHead registers fn to be called with every new head.
+func (t *Tracker) OnHead(fn func(Head)) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.listeners = append(t.listeners, fn)
+}
+
+// OnReorg registers fn to be called when blocks seen by the tracker are
+// replaced by a reorg. fn is called before the listeners of the new head.
+func (t *Tracker) OnReorg(fn func(Reorg)) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.reorgListeners = append(t.reorgListeners, fn)
+}
+
+// URL returns the upstream URL followed by the tracker.
+func (t *Tracker) URL() string {
+	return t.rpcUrl
+}
+
+func (t *Tracker) run() {
+	interval := pollInterval
+	if len(t.wsUrl) > 0 {
+		hub := subscription.HubFor(t.wsUrl)
+		id, err := hub.Subscribe([]any{"newHeads"}, t.onNotification, nil)
+		if err != nil {
+			slog.Info("newHeads not available, polling", "ws_upstream", logging.RedactURL(t.wsUrl), "upstream", logging.RedactURL(t.rpcUrl), "error", err)
+		} else {
+			interval = subscribedPollInterval
+			defer hub.Unsubscribe(id)
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.stop:
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), pollTimeout)
+		_, err := t.poll(ctx)
+		cancel()
+		if err != nil {
+			slog.Warn("error polling the head", "upstream", logging.RedactURL(t.rpcUrl), "error", err)
+		}
+	}
+}
+
+func (t *Tracker) onNotification(subscriptionID string, result json.RawMessage) {
+	var header model.BlockHeader
+	err := json.Unmarshal(result, &header)
+	if err != nil {
+		slog.Warn("invalid newHeads notification", "ws_upstream", logging.RedactURL(t.wsUrl), "error", err)
+		return
+	}
+	head, err := HeadFromHeader(&header)
+	if err != nil {
+		slog.Warn("invalid newHeads notification", "ws_upstream", logging.RedactURL(t.wsUrl), "error", err)
+		return
+	}
+	t.setHead(head)
+}
+
+func (t *Tracker) poll(ctx context.Context) (head Head, err error) {
+	header, err := t.fetchHeader(ctx, "latest")
+	if err != nil {
+		return
+	}
+	head, err = HeadFromHeader(header)
+	if err != nil {
+		return
+	}
+	t.setHead(head)
+	return
+}
+
+// fetchHeader returns the header of the block with the number or tag.
+func (t *Tracker) fetchHeader(ctx context.Context, block string) (header *model.BlockHeader, err error) {
+	var request model.RPCRequest
+	request.JsonRpcVersion = "2.0"
+	request.Method = "eth_getBlockByNumber"
+	request.ID = 1
+	request.Params = append(request.Params, block, false)
+	resp, err := upstream.For(t.rpcUrl).Call(ctx, &request)
+	if err != nil {
+		return
+	}
+	var blockResp model.BlockHeaderResponse
+	err = json.Unmarshal([]byte(resp), &blockResp)
+	if err != nil {
+		return
+	}
+	if blockResp.Result == nil {
+		err = fmt.Errorf("block %s not found: %s", block, resp)
+		return
+	}
+	header = blockResp.Result
+	return
+}
+
+// setHead makes head the latest head if it is not behind the current one, or
+// if it replaces blocks already seen, and calls the listeners when it is a
+// new block.
+func (t *Tracker) setHead(head Head) {
+	t.headMutex.Lock()
+	defer t.headMutex.Unlock()
+
+	t.mutex.RLock()
+	reorged := t.isReorg(head)
+	previous := t.latest
+	t.mutex.RUnlock()
+	if head.Number < previous.Number && !reorged {
+		return
+	}
+	if !reorged && previous.Number > 0 && head.Number > previous.Number+1 {
+		// heads were skipped: the previous head must still be in the chain
+		reorged = t.isReplaced(previous)
+	}
+	var event Reorg
+	if reorged {
+		event = t.rollback(head)
+	}
+
+	t.mutex.Lock()
+	changed := head.Hash != t.latest.Hash
+	t.latest = head
+	t.updated = time.Now()
+	t.hashes[head.Number] = head.Hash
+	if head.Number >= recentHashes {
+		for number := range t.hashes {
+			if number <= head.Number-recentHashes {
+				delete(t.hashes, number)
+			}
+		}
+	}
+	listeners := append([]func(Head){}, t.listeners...)
+	reorgListeners := append([]func(Reorg){}, t.reorgListeners...)
+	t.mutex.Unlock()
+
+	if reorged {
+		for _, listener := range reorgListeners {
+			listener(event)
+		}
+	}
+	if changed {
+		for _, listener := range listeners {
+			listener(head)
+		}
+	}
+}
+
+// HeadFromHeader converts the hexadecimal fields of a block header.
+func HeadFromHeader(header *model.BlockHeader) (head Head, err error) {
+	head.Number, err = ParseHexUint64(header.Number)
+	if err != nil {
+		err = fmt.Errorf("invalid block number %q: %w", header.Number, err)
+		return
+	}
+	timestamp, err := ParseHexUint64(header.Timestamp)
+	if err != nil {
+		err = fmt.Errorf("invalid block timestamp %q: %w", header.Timestamp, err)
+		return
+	}
+	head.Timestamp = int64(timestamp)
+	head.Hash = header.Hash
+	head.ParentHash = header.ParentHash
+	return
+}
+
+// ParseHexUint64 parses a 0x prefixed hexadecimal quantity.
+func ParseHexUint64(value string) (uint64, error) {
+	tmp, _ := strings.CutPrefix(strings.TrimSpace(value), "0x")
+	return strconv.ParseUint(tmp, 16, 64)
+}
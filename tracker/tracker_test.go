@@ -0,0 +1,32 @@
+package tracker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEvictIdle(t *testing.T) {
+	const configuredUrl = "http://configured.example.com"
+	client := For("http://client.example.com", "")
+	For(configuredUrl, "")
+
+	time.Sleep(time.Millisecond)
+	evicted := EvictIdle(0, func(rpcUrl string) bool { return rpcUrl == configuredUrl })
+	if len(evicted) != 1 || evicted[0] != client {
+		t.Fatalf("evicted %v, want the client tracker", evicted)
+	}
+	select {
+	case <-client.stop:
+	default:
+		t.Error("evicted tracker not stopped")
+	}
+	if For("http://client.example.com", "") == client {
+		t.Error("evicted tracker returned again")
+	}
+	trackersMutex.Lock()
+	_, ok := trackers[configuredUrl]
+	trackersMutex.Unlock()
+	if !ok {
+		t.Error("tracker of a configured upstream evicted")
+	}
+}
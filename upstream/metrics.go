@@ -0,0 +1,57 @@
+package upstream
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/jeffprestes/sjrpc/config"
+	"github.com/jeffprestes/sjrpc/model"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Provider label of the upstreams given by clients.
+const otherProvider = "other"
+
+var (
+	callDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sjrpc_upstream_request_duration_seconds",
+		Help:    "Duration of the upstream calls by provider.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"provider"})
+	callErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sjrpc_upstream_errors_total",
+		Help: "Failed upstream calls by provider and kind: transport, or rpc for JSON-RPC error responses.",
+	}, []string{"provider", "kind"})
+)
+
+// observeCall records the duration and outcome of an upstream call started at
+// start.
+func observeCall(rawUrl string, start time.Time, resp string, err error) {
+	provider := ProviderLabel(rawUrl)
+	callDuration.WithLabelValues(provider).Observe(time.Since(start).Seconds())
+	if err != nil {
+		callErrors.WithLabelValues(provider, "transport").Inc()
+	} else if model.IsResponseError(resp) {
+		callErrors.WithLabelValues(provider, "rpc").Inc()
+	}
+}
+
+// Provider names the upstream at rawUrl by its host, leaving out the path
+// and query, which often hold credentials.
+func Provider(rawUrl string) string {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil || len(parsed.Host) < 1 {
+		return "unknown"
+	}
+	return parsed.Host
+}
+
+// ProviderLabel names the upstream at rawUrl in the metrics. Upstreams not
+// configured are counted together, as clients choose them.
+func ProviderLabel(rawUrl string) string {
+	if !config.Get().IsConfiguredURL(rawUrl) {
+		return otherProvider
+	}
+	return Provider(rawUrl)
+}
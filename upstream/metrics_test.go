@@ -0,0 +1,28 @@
+package upstream
+
+import (
+	"testing"
+
+	"github.com/jeffprestes/sjrpc/config"
+)
+
+func TestProviderLabel(t *testing.T) {
+	cfg := config.Default()
+	cfg.Upstreams = []string{"https://eth.example.com/v2/secret"}
+	cfg.Chains = []config.Chain{{Name: "sepolia", ChainID: 11155111, Upstreams: []string{"https://sepolia.example.com"}}}
+	config.Set(cfg)
+	defer config.Set(config.Default())
+
+	tests := map[string]string{
+		"https://eth.example.com/v2/secret":    "eth.example.com",
+		"wss://eth.example.com/v2/secret":      "eth.example.com",
+		"https://sepolia.example.com":          "sepolia.example.com",
+		"https://eth.example.com/v2/other-key": "other",
+		"https://client.example.org":           "other",
+	}
+	for rawUrl, want := range tests {
+		if got := ProviderLabel(rawUrl); got != want {
+			t.Errorf("ProviderLabel(%s) = %s, want %s", rawUrl, got, want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Timeout to connect to restricted upstreams.
+const restrictedDialTimeout = 10 * time.Second
+
+var (
+	// upstream URLs only reachable on public addresses, with the time they
+	// were last restricted
+	restricted sync.Map
+
+	// address ranges not public besides the ones of the net.IP methods
+	nonPublicNets = []*net.IPNet{
+		mustParseCIDR("0.0.0.0/8"),
+		mustParseCIDR("100.64.0.0/10"),
+		mustParseCIDR("192.0.0.0/24"),
+		mustParseCIDR("198.18.0.0/15"),
+		mustParseCIDR("240.0.0.0/4"),
+	}
+
+	publicDialer = &net.Dialer{
+		Timeout: restrictedDialTimeout,
+		Control: publicOnly,
+	}
+
+	// publicClient connects only to public addresses, checked when
+	// connecting, so DNS changes and redirects cannot reach internal
+	// services.
+	publicClient = &http.Client{
+		Transport: &http.Transport{
+			DialContext:         publicDialer.DialContext,
+			TLSHandshakeTimeout: restrictedDialTimeout,
+			MaxIdleConnsPerHost: 16,
+		},
+	}
+)
+
+// Restrict makes the upstream url reachable only on public addresses. It is
+// used for URLs given by clients, and must be called on every use of them so
+// the restriction is not evicted with the idle transports.
+func Restrict(url string) {
+	restricted.Store(url, time.Now())
+	transportsMutex.Lock()
+	defer transportsMutex.Unlock()
+	if transport, ok := httpTransports[url]; ok {
+		transport.restricted.Store(true)
+	}
+	if transport, ok := wsTransports[url]; ok {
+		transport.restricted.Store(true)
+	}
+}
+
+// IsRestricted reports if the upstream url is only reachable on public
+// addresses.
+func IsRestricted(url string) bool {
+	_, ok := restricted.Load(url)
+	return ok
+}
+
+// stickyRestriction remembers that the URL of a transport was restricted, so
+// the transport stays restricted after its URL is evicted.
+type stickyRestriction struct {
+	atomic.Bool
+}
+
+// check reports if url is restricted, now or before.
+func (r *stickyRestriction) check(url string) bool {
+	if IsRestricted(url) {
+		r.Store(true)
+	}
+	return r.Load()
+}
+
+// CheckPublicURL returns an error when rawUrl is not an http, https, ws or
+// wss URL whose host resolves only to public addresses.
+func CheckPublicURL(ctx context.Context, rawUrl string) (err error) {
+	tmp, err := url.Parse(rawUrl)
+	if err != nil {
+		return
+	}
+	switch tmp.Scheme {
+	case "http", "https", "ws", "wss":
+	default:
+		err = fmt.Errorf("unsupported upstream scheme: %q", tmp.Scheme)
+		return
+	}
+	host := tmp.Hostname()
+	if len(host) < 1 {
+		err = fmt.Errorf("upstream URL without host")
+		return
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return
+	}
+	for _, addr := range addrs {
+		if !IsPublicIP(addr.IP) {
+			err = fmt.Errorf("upstream host %s has the non public address %s", host, addr.IP)
+			return
+		}
+	}
+	return
+}
+
+// IsPublicIP reports if ip is a public unicast address: not private,
+// loopback, link-local, multicast or otherwise reserved.
+func IsPublicIP(ip net.IP) bool {
+	if ip == nil || ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, ipNet := range nonPublicNets {
+		if ipNet.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// publicOnly is a net.Dialer Control function refusing non public addresses.
+func publicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if !IsPublicIP(net.ParseIP(host)) {
+		return fmt.Errorf("connection to non public address %s refused", host)
+	}
+	return nil
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return ipNet
+}
@@ -0,0 +1,155 @@
+package upstream
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/carlmjohnson/requests"
+	"github.com/jeffprestes/sjrpc/logging"
+	"github.com/jeffprestes/sjrpc/model"
+	"github.com/jeffprestes/sjrpc/tracing"
+)
+
+// Transport sends JSON-RPC requests to an upstream server.
+type Transport interface {
+	// Call sends the request and returns the raw JSON-RPC response.
+	Call(ctx context.Context, request *model.RPCRequest) (resp string, err error)
+	// URL returns the upstream server URL.
+	URL() string
+	Close() error
+}
+
+var (
+	transportsMutex sync.Mutex
+	httpTransports  = make(map[string]*HTTPTransport)
+	wsTransports    = make(map[string]*WSTransport)
+)
+
+// For returns the transport of the upstream url, creating it if needed. URLs
+// with the ws or wss scheme use a persistent WebSocket connection, any other
+// URL uses HTTP.
+func For(url string) Transport {
+	if IsWebSocketURL(url) {
+		return WebSocket(url)
+	}
+	transportsMutex.Lock()
+	defer transportsMutex.Unlock()
+	transport, ok := httpTransports[url]
+	if !ok {
+		transport = NewHTTPTransport(url)
+		httpTransports[url] = transport
+	}
+	transport.lastUsed.Store(time.Now().UnixNano())
+	return transport
+}
+
+// WebSocket returns the WebSocket transport of the upstream url, creating it
+// if needed.
+func WebSocket(url string) *WSTransport {
+	transportsMutex.Lock()
+	defer transportsMutex.Unlock()
+	transport, ok := wsTransports[url]
+	if !ok {
+		transport = NewWSTransport(url)
+		wsTransports[url] = transport
+	}
+	transport.lastUsed.Store(time.Now().UnixNano())
+	return transport
+}
+
+// EvictIdle removes the transports not used for longer than idle, except the
+// ones of the upstreams keep reports true for, and forgets the public address
+// restriction of their URLs. WebSocket transports with calls in progress or
+// held are kept; the others are disconnected, and connect again if something
+// still uses them.
+func EvictIdle(idle time.Duration, keep func(url string) bool) (evicted int) {
+	deadline := time.Now().Add(-idle).UnixNano()
+	transportsMutex.Lock()
+	defer transportsMutex.Unlock()
+	for url, transport := range httpTransports {
+		if transport.lastUsed.Load() < deadline && !keep(url) {
+			delete(httpTransports, url)
+			evicted++
+		}
+	}
+	for url, transport := range wsTransports {
+		if transport.lastUsed.Load() < deadline && !keep(url) && transport.disconnectIdle() {
+			delete(wsTransports, url)
+			evicted++
+		}
+	}
+	restricted.Range(func(key, value any) bool {
+		url := key.(string)
+		_, hasHTTP := httpTransports[url]
+		_, hasWS := wsTransports[url]
+		if value.(time.Time).UnixNano() < deadline && !hasHTTP && !hasWS {
+			restricted.Delete(url)
+		}
+		return true
+	})
+	return
+}
+
+// IsWebSocketURL reports if url has the ws or wss scheme.
+func IsWebSocketURL(url string) bool {
+	return strings.HasPrefix(url, "ws://") || strings.HasPrefix(url, "wss://")
+}
+
+// HTTPTransport sends each request in its own HTTP POST.
+type HTTPTransport struct {
+	url        string
+	restricted stickyRestriction
+	lastUsed   atomic.Int64
+}
+
+// NewHTTPTransport returns a transport for the upstream HTTP url.
+func NewHTTPTransport(url string) *HTTPTransport {
+	t := &HTTPTransport{url: url}
+	t.restricted.Store(IsRestricted(url))
+	return t
+}
+
+// Call implements the Transport interface.
+func (t *HTTPTransport) Call(ctx context.Context, request *model.RPCRequest) (resp string, err error) {
+	defer func(start time.Time) {
+		observeCall(t.url, start, resp, err)
+	}(time.Now())
+	tmpResp := new(bytes.Buffer)
+	builder := requests.URL(t.url).BodyJSON(request).ContentType("application/json").ToBytesBuffer(tmpResp)
+	if t.restricted.check(t.url) {
+		builder = builder.Client(publicClient)
+	}
+	header := http.Header{}
+	tracing.Inject(ctx, header)
+	for key, values := range header {
+		builder = builder.Header(key, values...)
+	}
+	err = builder.Fetch(ctx)
+	if err != nil {
+		// errors of the HTTP client hold the URL, often with an API key
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = logging.RedactURL(urlErr.URL)
+		}
+		return
+	}
+	resp = tmpResp.String()
+	return
+}
+
+// URL implements the Transport interface.
+func (t *HTTPTransport) URL() string {
+	return t.url
+}
+
+// Close implements the Transport interface.
+func (t *HTTPTransport) Close() error {
+	return nil
+}
@@ -0,0 +1,37 @@
+package upstream
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEvictIdle(t *testing.T) {
+	const (
+		clientUrl     = "http://client.example.com/rpc"
+		configuredUrl = "http://configured.example.com/rpc"
+	)
+	Restrict(clientUrl)
+	evictedTransport := For(clientUrl).(*HTTPTransport)
+	For(configuredUrl)
+
+	keep := func(url string) bool { return url == configuredUrl }
+	if n := EvictIdle(time.Hour, keep); n != 0 {
+		t.Fatalf("%d transports used recently evicted", n)
+	}
+	time.Sleep(time.Millisecond)
+	EvictIdle(0, keep)
+
+	transportsMutex.Lock()
+	_, hasClient := httpTransports[clientUrl]
+	_, hasConfigured := httpTransports[configuredUrl]
+	transportsMutex.Unlock()
+	if hasClient || !hasConfigured {
+		t.Errorf("transports kept: client %v, configured %v", hasClient, hasConfigured)
+	}
+	if IsRestricted(clientUrl) {
+		t.Error("restriction of an evicted URL kept")
+	}
+	if !evictedTransport.restricted.check(clientUrl) {
+		t.Error("evicted transport no longer restricted")
+	}
+}
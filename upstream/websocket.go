@@ -0,0 +1,449 @@
+package upstream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jeffprestes/sjrpc/logging"
+	"github.com/jeffprestes/sjrpc/model"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// Timeout of a request sent over the WebSocket connection, including the
+	// time spent reconnecting.
+	wsCallTimeout = 30 * time.Second
+
+	// Timeout to establish the WebSocket connection.
+	wsDialTimeout = 10 * time.Second
+
+	// Timeout to write a message to the WebSocket connection.
+	wsWriteTimeout = 10 * time.Second
+
+	// Maximum wait between two reconnection attempts.
+	wsMaxReconnectDelay = 30 * time.Second
+)
+
+var (
+	// ErrClosed is returned by calls to a closed transport.
+	ErrClosed = errors.New("transport closed")
+
+	// ErrConnectionLost is returned by calls that cannot be sent again when
+	// the connection drops before their response, as they may have reached
+	// the upstream server.
+	ErrConnectionLost = errors.New("upstream connection lost before the response")
+)
+
+// NotificationFunc receives the eth_subscription notifications sent by the
+// upstream server.
+type NotificationFunc func(subscription string, result json.RawMessage)
+
+type (
+	// WSTransport sends requests over a persistent WebSocket connection to the
+	// upstream server. The connection is established on the first call and
+	// restored when it drops, sending again the idempotent requests still
+	// waiting for a response. The others fail with ErrConnectionLost, so a
+	// transaction is never submitted twice.
+	WSTransport struct {
+		url        string
+		restricted stickyRestriction
+		lastUsed   atomic.Int64
+
+		writeMutex sync.Mutex
+
+		mutex                sync.Mutex
+		conn                 *websocket.Conn
+		connecting           bool
+		connectedBefore      bool
+		closed               bool
+		holds                int
+		nextID               int
+		inflight             map[int]*inflightCall
+		notificationHandlers []NotificationFunc
+		reconnectHandlers    []func()
+	}
+
+	inflightCall struct {
+		data       []byte
+		respChan   chan callResult
+		idempotent bool
+		// sent is true once the request was written to a connection
+		sent bool
+	}
+
+	callResult struct {
+		data []byte
+		err  error
+	}
+
+	// wsMessage is any message received from the upstream server: a response
+	// or a subscription notification.
+	wsMessage struct {
+		ID     *int   `json:"id"`
+		Method string `json:"method"`
+		Params struct {
+			Subscription string          `json:"subscription"`
+			Result       json.RawMessage `json:"result"`
+		} `json:"params"`
+	}
+
+	// rawResponse keeps the result and error of a response untouched.
+	rawResponse struct {
+		Jsonrpc string          `json:"jsonrpc"`
+		ID      int             `json:"id"`
+		Result  json.RawMessage `json:"result,omitempty"`
+		Error   json.RawMessage `json:"error,omitempty"`
+	}
+)
+
+// NewWSTransport returns a transport for the upstream WebSocket url.
+func NewWSTransport(url string) *WSTransport {
+	t := &WSTransport{
+		url:      url,
+		inflight: make(map[int]*inflightCall),
+	}
+	t.restricted.Store(IsRestricted(url))
+	return t
+}
+
+// Call implements the Transport interface. The request is sent with an
+// internal id, which is replaced by the request id in the response.
+func (t *WSTransport) Call(ctx context.Context, request *model.RPCRequest) (resp string, err error) {
+	defer func(start time.Time) {
+		observeCall(t.url, start, resp, err)
+	}(time.Now())
+	t.lastUsed.Store(time.Now().UnixNano())
+	tmpRequest := *request
+	call := &inflightCall{respChan: make(chan callResult, 1), idempotent: request.IsIdempotent()}
+
+	t.mutex.Lock()
+	if t.closed {
+		t.mutex.Unlock()
+		err = ErrClosed
+		return
+	}
+	t.nextID++
+	tmpRequest.ID = t.nextID
+	call.data, err = json.Marshal(tmpRequest)
+	if err != nil {
+		t.mutex.Unlock()
+		return
+	}
+	t.inflight[tmpRequest.ID] = call
+	conn := t.conn
+	if conn == nil {
+		t.startConnectLocked()
+	} else {
+		call.sent = true
+	}
+	t.mutex.Unlock()
+
+	defer func() {
+		t.mutex.Lock()
+		delete(t.inflight, tmpRequest.ID)
+		t.mutex.Unlock()
+	}()
+
+	if conn != nil {
+		// a write error means the connection dropped, the request is sent
+		// again once it is restored
+		t.write(conn, call.data)
+	}
+
+	timer := time.NewTimer(wsCallTimeout)
+	defer timer.Stop()
+	select {
+	case result := <-call.respChan:
+		if result.err != nil {
+			err = result.err
+			return
+		}
+		resp, err = withID(result.data, request.ID)
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timer.C:
+		err = fmt.Errorf("%s timeout calling %s", request.Method, logging.RedactURL(t.url))
+	}
+	return
+}
+
+// URL implements the Transport interface.
+func (t *WSTransport) URL() string {
+	return t.url
+}
+
+// Close implements the Transport interface. Pending calls fail with
+// ErrClosed.
+func (t *WSTransport) Close() error {
+	t.mutex.Lock()
+	t.closed = true
+	conn := t.conn
+	t.conn = nil
+	t.failInflightLocked(ErrClosed)
+	t.mutex.Unlock()
+	if conn != nil {
+		return conn.Close()
+	}
+	return nil
+}
+
+// OnNotification registers fn to receive every subscription notification.
+func (t *WSTransport) OnNotification(fn NotificationFunc) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.notificationHandlers = append(t.notificationHandlers, fn)
+}
+
+// OnReconnect registers fn to be called every time the connection is
+// restored after a drop.
+func (t *WSTransport) OnReconnect(fn func()) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.reconnectHandlers = append(t.reconnectHandlers, fn)
+}
+
+// Hold keeps the connection alive, reconnecting even when there are no
+// pending calls, until Release is called as many times as Hold.
+func (t *WSTransport) Hold() {
+	t.lastUsed.Store(time.Now().UnixNano())
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.holds++
+	if t.conn == nil {
+		t.startConnectLocked()
+	}
+}
+
+// Release undoes a Hold.
+func (t *WSTransport) Release() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.holds > 0 {
+		t.holds--
+	}
+}
+
+// disconnectIdle closes the connection when there are no calls in progress
+// and no holds, and reports if it did. The transport connects again on the
+// next call.
+func (t *WSTransport) disconnectIdle() bool {
+	t.mutex.Lock()
+	if len(t.inflight) > 0 || t.holds > 0 || t.connecting {
+		t.mutex.Unlock()
+		return false
+	}
+	conn := t.conn
+	t.conn = nil
+	t.mutex.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+	return true
+}
+
+func (t *WSTransport) startConnectLocked() {
+	if t.connecting || t.closed {
+		return
+	}
+	t.connecting = true
+	go t.connect()
+}
+
+// connect dials the upstream server until it succeeds, then sends every
+// pending request. Before the first successful connection, a dial error fails
+// the pending calls instead of retrying.
+func (t *WSTransport) connect() {
+	delay := time.Second
+	for {
+		conn, err := dial(t.url, t.restricted.check(t.url))
+		if err == nil {
+			t.connected(conn)
+			return
+		}
+
+		t.mutex.Lock()
+		if !t.connectedBefore {
+			t.failInflightLocked(fmt.Errorf("connecting to %s: %w", logging.RedactURL(t.url), err))
+		}
+		if t.closed || (!t.connectedBefore && t.holds < 1) || (len(t.inflight) < 1 && t.holds < 1) {
+			t.connecting = false
+			t.mutex.Unlock()
+			return
+		}
+		t.mutex.Unlock()
+
+		slog.Warn("error connecting to upstream, retrying", "upstream", logging.RedactURL(t.url), "delay", delay, "error", err)
+		time.Sleep(delay)
+		delay *= 2
+		if delay > wsMaxReconnectDelay {
+			delay = wsMaxReconnectDelay
+		}
+	}
+}
+
+func (t *WSTransport) connected(conn *websocket.Conn) {
+	t.mutex.Lock()
+	if t.closed {
+		t.mutex.Unlock()
+		conn.Close()
+		return
+	}
+	t.conn = conn
+	t.connecting = false
+	reconnected := t.connectedBefore
+	t.connectedBefore = true
+	t.failLostLocked()
+	pending := make([][]byte, 0, len(t.inflight))
+	for _, call := range t.inflight {
+		call.sent = true
+		pending = append(pending, call.data)
+	}
+	handlers := append([]func(){}, t.reconnectHandlers...)
+	t.mutex.Unlock()
+
+	go t.readLoop(conn)
+	for _, data := range pending {
+		t.write(conn, data)
+	}
+	if reconnected {
+		slog.Info("upstream connection restored", "upstream", logging.RedactURL(t.url), "resent", len(pending))
+		for _, handler := range handlers {
+			go handler()
+		}
+	}
+}
+
+func (t *WSTransport) readLoop(conn *websocket.Conn) {
+	for {
+		var data []byte
+		err := websocket.Message.Receive(conn, &data)
+		if err != nil {
+			t.connectionLost(conn, err)
+			return
+		}
+		var msg wsMessage
+		err = json.Unmarshal(data, &msg)
+		if err != nil {
+			slog.Warn("invalid message from upstream", "upstream", logging.RedactURL(t.url), "error", err)
+			continue
+		}
+		if msg.ID != nil {
+			t.mutex.Lock()
+			call, ok := t.inflight[*msg.ID]
+			t.mutex.Unlock()
+			if ok {
+				select {
+				case call.respChan <- callResult{data: data}:
+				default:
+					// response to a request sent twice
+				}
+			}
+			continue
+		}
+		if msg.Method == "eth_subscription" {
+			t.mutex.Lock()
+			handlers := append([]NotificationFunc{}, t.notificationHandlers...)
+			t.mutex.Unlock()
+			for _, handler := range handlers {
+				handler(msg.Params.Subscription, msg.Params.Result)
+			}
+		}
+	}
+}
+
+// connectionLost reconnects if there are pending calls or holds.
+func (t *WSTransport) connectionLost(conn *websocket.Conn, reason error) {
+	conn.Close()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.conn != conn {
+		return
+	}
+	t.conn = nil
+	if t.closed {
+		return
+	}
+	slog.Warn("upstream connection lost", "upstream", logging.RedactURL(t.url), "error", reason)
+	t.failLostLocked()
+	if len(t.inflight) > 0 || t.holds > 0 {
+		t.startConnectLocked()
+	}
+}
+
+func (t *WSTransport) write(conn *websocket.Conn, data []byte) {
+	t.writeMutex.Lock()
+	defer t.writeMutex.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	err := websocket.Message.Send(conn, string(data))
+	if err != nil {
+		// unblock the read loop so the connection is restored
+		conn.Close()
+	}
+}
+
+// failLostLocked fails with ErrConnectionLost the calls sent to a dropped
+// connection that cannot be sent again.
+func (t *WSTransport) failLostLocked() {
+	for id, call := range t.inflight {
+		if call.sent && !call.idempotent {
+			delete(t.inflight, id)
+			select {
+			case call.respChan <- callResult{err: ErrConnectionLost}:
+			default:
+			}
+		}
+	}
+}
+
+func (t *WSTransport) failInflightLocked(err error) {
+	for _, call := range t.inflight {
+		select {
+		case call.respChan <- callResult{err: err}:
+		default:
+		}
+	}
+}
+
+func dial(url string, restricted bool) (conn *websocket.Conn, err error) {
+	wsConfig, err := websocket.NewConfig(url, "http://localhost/")
+	if err != nil {
+		return
+	}
+	wsConfig.Dialer = &net.Dialer{Timeout: wsDialTimeout}
+	if restricted {
+		wsConfig.Dialer.Control = publicOnly
+	}
+	conn, err = websocket.DialConfig(wsConfig)
+	var dialErr *websocket.DialError
+	if errors.As(err, &dialErr) {
+		// the error holds the URL, often with an API key
+		err = fmt.Errorf("websocket.Dial %s: %w", logging.RedactURL(url), dialErr.Err)
+	}
+	return
+}
+
+// withID replaces the id of the raw response by id.
+func withID(data []byte, id int) (resp string, err error) {
+	var tmp rawResponse
+	err = json.Unmarshal(data, &tmp)
+	if err != nil {
+		return
+	}
+	tmp.ID = id
+	if len(tmp.Jsonrpc) < 1 {
+		tmp.Jsonrpc = "2.0"
+	}
+	out, err := json.Marshal(tmp)
+	if err != nil {
+		return
+	}
+	resp = string(out)
+	return
+}
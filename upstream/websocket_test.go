@@ -0,0 +1,100 @@
+package upstream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jeffprestes/sjrpc/model"
+	"golang.org/x/net/websocket"
+)
+
+// flakyServer is a WebSocket JSON-RPC server that drops its first connection
+// after receiving a request, without answering it.
+type flakyServer struct {
+	mutex       sync.Mutex
+	connections int
+	received    []string
+}
+
+func (s *flakyServer) handle(conn *websocket.Conn) {
+	s.mutex.Lock()
+	s.connections++
+	first := s.connections == 1
+	s.mutex.Unlock()
+	for {
+		var request model.RPCRequest
+		err := websocket.JSON.Receive(conn, &request)
+		if err != nil {
+			return
+		}
+		s.mutex.Lock()
+		s.received = append(s.received, request.Method)
+		s.mutex.Unlock()
+		if first {
+			conn.Close()
+			return
+		}
+		websocket.JSON.Send(conn, map[string]any{"jsonrpc": "2.0", "id": request.ID, "result": "0x1"})
+	}
+}
+
+func (s *flakyServer) count(method string) (n int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, item := range s.received {
+		if item == method {
+			n++
+		}
+	}
+	return
+}
+
+func newFlakyTransport(t *testing.T) (*flakyServer, *WSTransport) {
+	server := &flakyServer{}
+	httpServer := httptest.NewServer(websocket.Handler(server.handle))
+	t.Cleanup(httpServer.Close)
+	transport := NewWSTransport("ws" + strings.TrimPrefix(httpServer.URL, "http"))
+	t.Cleanup(func() { transport.Close() })
+	return server, transport
+}
+
+func TestWSTransportResendsIdempotentCalls(t *testing.T) {
+	server, transport := newFlakyTransport(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	request := &model.RPCRequest{JsonRpcVersion: "2.0", ID: 7, Method: "eth_blockNumber", Params: []any{}}
+	resp, err := transport.Call(ctx, request)
+	if err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	var respObj model.RPCResponse
+	if err := json.Unmarshal([]byte(resp), &respObj); err != nil || respObj.ID != 7 {
+		t.Errorf("response %s has not the request id", resp)
+	}
+	if n := server.count("eth_blockNumber"); n != 2 {
+		t.Errorf("request received %d times, want 2", n)
+	}
+}
+
+func TestWSTransportDoesNotResendTransactions(t *testing.T) {
+	server, transport := newFlakyTransport(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	request := &model.RPCRequest{JsonRpcVersion: "2.0", ID: 1, Method: "eth_sendRawTransaction", Params: []any{"0x00"}}
+	_, err := transport.Call(ctx, request)
+	if !errors.Is(err, ErrConnectionLost) {
+		t.Fatalf("call error = %v, want ErrConnectionLost", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := server.count("eth_sendRawTransaction"); n != 1 {
+		t.Errorf("transaction received %d times, want 1", n)
+	}
+}